@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Access policies of the Invoke routes
+//   PolicyAny:           every enrolled identity
+//   PolicyAdmin:         participants with IsAdmin or certificates carrying exchain.admin=true
+//   PolicyTicketOwner:   the participant who created the ticket (or an admin)
+//   PolicySelf:          the participant the call is made for (or an admin)
+//   PolicyRegistered:    any registered participant, the route checks the details itself
+const (
+	PolicyAny = iota
+	PolicyAdmin
+	PolicyTicketOwner
+	PolicySelf
+	PolicyRegistered
+)
+
+//Error codes returned to the client when a call is rejected
+const (
+	ErrCodeUnauthenticated = "UNAUTHENTICATED"
+	ErrCodeForbidden       = "FORBIDDEN"
+	ErrCodeNotFound        = "NOT_FOUND"
+	ErrCodeBadRequest      = "BAD_REQUEST"
+)
+
+//Certificate attributes read from the creator identity
+//   hf.EnrollmentID:   added by fabric-ca, equals Participant.UserID
+//   exchain.admin:     "true" lets an identity act as admin before it is registered as a participant
+const (
+	AttrEnrollmentID = "hf.EnrollmentID"
+	AttrAdmin        = "exchain.admin"
+)
+
+//Caller information resolved from stub.GetCreator()
+//   MSPID:          Org1MSP
+//   EnrollmentID:   iXXXXXX
+//   Participant:    the stored participant record, if Registered
+//   IsAdmin:        participant is admin or certificate has exchain.admin=true
+type Caller struct {
+	MSPID        string
+	EnrollmentID string
+
+	Participant Participant
+	Registered  bool
+	IsAdmin     bool
+}
+
+//AccessRule - the policy of a route and how to get the user or ticket it is checked against
+type AccessRule struct {
+	Policy  int
+	Subject func(args []string) (string, error)
+}
+
+//ErrorResponse - structured error put in the Message of a rejected response
+//Details lists the invalid fields of a BAD_REQUEST
+type ErrorResponse struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+var accessRules = map[string]AccessRule{
+	"addParticipant":     {Policy: PolicyAdmin},
+	"readParticipant":    {Policy: PolicyAny},
+	"readAllParticipant": {Policy: PolicyAny},
+	"updateParticipant":  {Policy: PolicyAdmin},
+	"deleteParticipant":  {Policy: PolicyAdmin},
+
+	"ParticipantOffboard":  {Policy: PolicyAdmin},
+	"TombstoneRead":        {Policy: PolicyAny},
+	"ParticipantChangeLoB": {Policy: PolicyAdmin},
+	"ParticipantLoBMoves":  {Policy: PolicyAny},
+
+	"authenticateParticipant":     {Policy: PolicyAny},
+	"migrateParticipantPasswords": {Policy: PolicyAdmin},
+	"migrateKeySchema":            {Policy: PolicyAdmin},
+
+	"CreditCreate": {Policy: PolicyAdmin},
+	"CreditRead":   {Policy: PolicyAny},
+	"CreditAdd":    {Policy: PolicyAdmin},
+	"CreditDelete": {Policy: PolicyAdmin},
+	"TopTenCredit": {Policy: PolicyAny},
+
+	"Leaderboard":    {Policy: PolicyAny},
+	"LoBLeaderboard": {Policy: PolicyAny},
+	"CreditRank":     {Policy: PolicyAny},
+
+	"rebuildCreditRanking": {Policy: PolicyAdmin},
+
+	"CreditTransfer":     {Policy: PolicySelf, Subject: jsonFieldArg("from")},
+	"CreditTransferList": {Policy: PolicySelf, Subject: plainArg(0)},
+	"CreditStatement":    {Policy: PolicySelf, Subject: plainArg(0)},
+
+	"RewardCreate":     {Policy: PolicyAdmin},
+	"RewardUpdate":     {Policy: PolicyAdmin},
+	"RewardRead":       {Policy: PolicyAny},
+	"RewardList":       {Policy: PolicyAny},
+	"Redeem":           {Policy: PolicySelf, Subject: jsonFieldArg("userID")},
+	"RedemptionRead":   {Policy: PolicyAdmin},
+	"RedemptionList":   {Policy: PolicySelf, Subject: plainArg(0)},
+	"RedemptionFulfil": {Policy: PolicyAdmin},
+	"RedemptionRefund": {Policy: PolicyAdmin},
+
+	"LoBReadAll": {Policy: PolicyAny},
+	"LoBRead":    {Policy: PolicyAny},
+	"LoBList":    {Policy: PolicyAny},
+	"LoBCreate":  {Policy: PolicyAdmin},
+	"LoBRename":  {Policy: PolicyAdmin},
+	"LoBArchive": {Policy: PolicyAdmin},
+
+	"TicketCreate":           {Policy: PolicySelf, Subject: jsonFieldArg("Ticket_UserID")},
+	"TicketRead":             {Policy: PolicyAny},
+	"TicketRead2":            {Policy: PolicyAny},
+	"TicketList":             {Policy: PolicyAny},
+	"TicketUpdate":           {Policy: PolicyTicketOwner, Subject: jsonFieldArg("Ticket_TicketID")},
+	"AutoUpdateTicketStatus": {Policy: PolicyTicketOwner, Subject: plainArg(0)},
+	"TicketDelete":           {Policy: PolicyTicketOwner, Subject: plainArg(0)},
+	"EscrowRead":             {Policy: PolicyAny},
+	"TicketEscrowRefund":     {Policy: PolicyTicketOwner, Subject: plainArg(0)},
+	"TicketExpireOverdue":    {Policy: PolicyAdmin},
+	"PolicyValidate":         {Policy: PolicyAny},
+
+	"TicketTypeCreate": {Policy: PolicyAdmin},
+	"TicketTypeUpdate": {Policy: PolicyAdmin},
+	"TicketTypeRead":   {Policy: PolicyAny},
+	"TicketTypeList":   {Policy: PolicyAny},
+	"TicketTypeStats":  {Policy: PolicyAny},
+
+	"OrderCreate": {Policy: PolicySelf, Subject: jsonFieldArg("UserID")},
+	"OrderRead":   {Policy: PolicyAny},
+	"OrderRead2":  {Policy: PolicyAny},
+	"OrderUpdate": {Policy: PolicyRegistered},
+
+	"OrderApprove": {Policy: PolicyTicketOwner, Subject: plainArg(0)},
+
+	"TicketHistory":      {Policy: PolicyAny},
+	"ParticipantHistory": {Policy: PolicySelf, Subject: plainArg(0)},
+	"history":            {Policy: PolicyAny},
+}
+
+//Helper: subject is the plain argument at index i
+func plainArg(i int) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		if len(args) <= i {
+			return "", errors.New("Incorrect number of arguments")
+		}
+		return args[i], nil
+	}
+}
+
+//Helper: subject is a string field of the JSON object in args[0]
+func jsonFieldArg(field string) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		var raw map[string]interface{}
+		if len(args) < 1 {
+			return "", errors.New("Incorrect number of arguments")
+		}
+		err := json.Unmarshal([]byte(args[0]), &raw)
+		if err != nil {
+			return "", errors.New("Input is not a valid JSON object")
+		}
+		value, ok := raw[field].(string)
+		if !ok || value == "" {
+			return "", errors.New("Missing field: " + field)
+		}
+		return value, nil
+	}
+}
+
+//Helper: build a rejected response carrying an ErrorResponse
+func errorResponse(code string, message string) peer.Response {
+	return errorDetailsResponse(code, message, nil)
+}
+
+func errorDetailsResponse(code string, message string, details []FieldError) peer.Response {
+	bytes, err := json.Marshal(ErrorResponse{Code: code, Message: message, Details: details})
+	if err != nil {
+		return shim.Error(code + ": " + message)
+	}
+	return peer.Response{Status: shim.ERROR, Message: string(bytes)}
+}
+
+//Helper: resolve the caller from the creator certificate and the stored participant
+func getCaller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+	var err error
+
+	caller.MSPID, err = cid.GetMSPID(stub)
+	if err != nil {
+		return caller, errors.New("getCaller: Error getting MSP ID of creator: " + err.Error())
+	}
+
+	enrollmentID, found, err := cid.GetAttributeValue(stub, AttrEnrollmentID)
+	if err != nil {
+		return caller, errors.New("getCaller: Error reading creator attributes: " + err.Error())
+	}
+	if !found || enrollmentID == "" {
+		cert, err := cid.GetX509Certificate(stub)
+		if err != nil || cert == nil {
+			return caller, errors.New("getCaller: Error reading creator certificate")
+		}
+		enrollmentID = cert.Subject.CommonName
+	}
+	caller.EnrollmentID = enrollmentID
+
+	adminAttr, found, err := cid.GetAttributeValue(stub, AttrAdmin)
+	if err == nil && found && adminAttr == "true" {
+		caller.IsAdmin = true
+	}
+
+	// participants are bound to MSP ID + enrollment ID, a certificate of any MSP can carry the enrollment ID
+	// of a record without an MSP ID, so those records count only after migrateParticipantPasswords bound them
+	userID := enrollmentID
+	key, err := identityIndexKey(stub, caller.MSPID, enrollmentID)
+	if err != nil {
+		return caller, wrapError("getCaller", err)
+	}
+	boundID, err := stub.GetState(key)
+	if err != nil {
+		return caller, errors.New("getCaller: Error getting identity index")
+	}
+	if boundID != nil {
+		userID = string(boundID)
+	}
+
+	bytes, err := getEntityState(stub, ObjectParticipant, userID)
+	if err != nil {
+		return caller, errors.New("getCaller: Error getting participant with ID: " + userID)
+	}
+	if len(bytes) != 0 {
+		var participant Participant
+		err = json.Unmarshal(bytes, &participant)
+		if err != nil {
+			return caller, errors.New("getCaller: Corrupt participant record " + string(bytes))
+		}
+		if boundID != nil {
+			caller.Participant = participant
+			caller.Registered = true
+			caller.IsAdmin = caller.IsAdmin || participant.IsAdmin
+		}
+	}
+	return caller, nil
+}
+
+//Helper: get the creator of a ticket
+func getTicketOwner(stub shim.ChaincodeStubInterface, ticketID string) (string, error) {
+	var ticket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return "", errors.New("getTicketOwner: Error getting ticket " + ticketID)
+	}
+	if ticketAsBytes == nil {
+		return "", notFoundError("getTicketOwner: The ticket does not exist: " + ticketID)
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return "", errors.New("getTicketOwner: Corrupt ticket record " + string(ticketAsBytes))
+	}
+	return ticket.UserID, nil
+}
+
+//Helper: check the caller against the access rule of the route
+//returns ok == false and the response to send back if the call is rejected
+func (rdg *SmartContract) checkAccess(stub shim.ChaincodeStubInterface, function string, args []string) (peer.Response, bool) {
+	rule, exists := accessRules[function]
+	if !exists || rule.Policy == PolicyAny {
+		return peer.Response{}, true
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		logger.Error(" ****** checkAccess: ", err.Error())
+		return errorResponse(ErrCodeUnauthenticated, err.Error()), false
+	}
+	logger.Info(" ****** checkAccess: caller ", caller.MSPID, caller.EnrollmentID, " function ", function)
+
+	if caller.IsAdmin {
+		return peer.Response{}, true
+	}
+	if rule.Policy == PolicyAdmin {
+		return errorResponse(ErrCodeForbidden, function+": only admins are allowed"), false
+	}
+	if !caller.Registered {
+		return errorResponse(ErrCodeUnauthenticated, "No participant is registered for "+caller.EnrollmentID), false
+	}
+
+	if rule.Policy == PolicyRegistered {
+		return peer.Response{}, true
+	}
+
+	subject, err := rule.Subject(args)
+	if err != nil {
+		return errorResponse(ErrCodeBadRequest, function+": "+err.Error()), false
+	}
+
+	switch rule.Policy {
+	case PolicySelf:
+		if subject != caller.Participant.UserID {
+			return errorResponse(ErrCodeForbidden, function+": only allowed for the participant itself"), false
+		}
+	case PolicyTicketOwner:
+		owner, err := getTicketOwner(stub, subject)
+		if err != nil {
+			return errorResponse(ErrCodeNotFound, err.Error()), false
+		}
+		if owner != caller.Participant.UserID {
+			return errorResponse(ErrCodeForbidden, function+": only allowed for the ticket owner"), false
+		}
+	}
+	return peer.Response{}, true
+}
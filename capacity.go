@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//Maximum number of assignees a ticket can declare
+const MaxTicketAssignees = 1000
+
+//ticketCapacity - the assignees and waitlist of a ticket while a transaction moves its orders
+//range scans do not show writes of the running transaction, so every transition is followed here
+//assigned holds the order status of every assignee holding a slot, statuses the one of every order
+type ticketCapacity struct {
+	max      int
+	assigned map[string]int
+	statuses map[string]int
+	waitlist []Order
+}
+
+//Helper: orders holding one of the assignee slots of a ticket
+func holdsSlot(status int) bool {
+	return status == OrderConfirmed || status == OrderDone || status == OrderAwarded
+}
+
+//Helper: read the assignees and the waitlist of a ticket, the waitlist in the order orders joined it
+func loadCapacity(stub shim.ChaincodeStubInterface, ticket Ticket) (*ticketCapacity, error) {
+	capacity := &ticketCapacity{max: ticket.MaxAssignees, assigned: map[string]int{}, statuses: map[string]int{}}
+	orders, err := listOrders(stub, []string{ticket.TicketID})
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		capacity.statuses[order.UserID] = order.Status
+		if holdsSlot(order.Status) {
+			capacity.assigned[order.UserID] = order.Status
+		}
+		if order.Status == OrderWaitlisted {
+			capacity.waitlist = append(capacity.waitlist, order)
+		}
+	}
+	capacity.sortWaitlist()
+	return capacity, nil
+}
+
+func (capacity *ticketCapacity) sortWaitlist() {
+	sort.SliceStable(capacity.waitlist, func(i, j int) bool {
+		a, b := capacity.waitlist[i], capacity.waitlist[j]
+		if a.WaitlistedAt != nil && b.WaitlistedAt != nil && !a.WaitlistedAt.Equal(*b.WaitlistedAt) {
+			return a.WaitlistedAt.Before(*b.WaitlistedAt)
+		}
+		return a.UserID < b.UserID
+	})
+}
+
+//Helper: whether every assignee slot is taken, tickets without MaxAssignees are never full
+func (capacity *ticketCapacity) full() bool {
+	return capacity.max > 0 && len(capacity.assigned) >= capacity.max
+}
+
+//Helper: assignees holding a slot in UserID order, the payees of a reward split
+func (capacity *ticketCapacity) holders() []string {
+	userIDs := make([]string, 0, len(capacity.assigned))
+	for userID := range capacity.assigned {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+//Helper: whether every assignee holding a slot has been awarded, false if nobody holds one
+func (capacity *ticketCapacity) settled() bool {
+	for _, status := range capacity.assigned {
+		if status != OrderAwarded {
+			return false
+		}
+	}
+	return len(capacity.assigned) != 0
+}
+
+//Helper: status of the ticket, the furthest status of its orders
+//closed, rejected and expired orders do not move the ticket, waitlisted ones count as applied
+func (capacity *ticketCapacity) ticketStatus() int {
+	maxStatus := 0
+	for _, status := range capacity.statuses {
+		if status == OrderClosed || status == OrderRejected || status == OrderExpired {
+			continue
+		}
+		if status == OrderWaitlisted {
+			status = OrderApplied
+		}
+		if maxStatus < status {
+			maxStatus = status
+		}
+	}
+	return maxStatus
+}
+
+//Helper: follow an order moving to a new status
+func (capacity *ticketCapacity) follow(order Order, to int) {
+	capacity.statuses[order.UserID] = to
+	if holdsSlot(to) {
+		capacity.assigned[order.UserID] = to
+	} else {
+		delete(capacity.assigned, order.UserID)
+	}
+	for i, waiting := range capacity.waitlist {
+		if waiting.UserID == order.UserID {
+			capacity.waitlist = append(capacity.waitlist[:i], capacity.waitlist[i+1:]...)
+			break
+		}
+	}
+	if to == OrderWaitlisted {
+		capacity.waitlist = append(capacity.waitlist, order)
+		capacity.sortWaitlist()
+	}
+}
+
+//Helper: put an order the ticket owner confirmed on the waitlist of a full ticket
+func waitlistOrder(stub shim.ChaincodeStubInterface, order Order) (Order, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return order, err
+	}
+	from := order.Status
+	order.Status = OrderWaitlisted
+	order.WaitlistedAt = &txTime
+	_, err = OrderSaving(stub, order)
+	if err != nil {
+		return order, err
+	}
+	return order, emitEvent(stub, ChaincodeEvent{
+		Type:      EventOrderStatusChanged,
+		TicketID:  order.TicketID,
+		UserID:    order.UserID,
+		OldStatus: eventStatus(from),
+		NewStatus: eventStatus(OrderWaitlisted)})
+}
+
+//Helper: confirm waitlisted orders, first come first served, while the ticket has free slots
+func promoteWaitlist(stub shim.ChaincodeStubInterface, ticket Ticket, capacity *ticketCapacity) ([]OrderTransitionResult, error) {
+	var results []OrderTransitionResult
+	for !capacity.full() && len(capacity.waitlist) != 0 {
+		order := capacity.waitlist[0]
+		capacity.follow(order, OrderConfirmed)
+
+		order.Status = OrderConfirmed
+		order.WaitlistedAt = nil
+		_, err := OrderSaving(stub, order)
+		if err != nil {
+			return results, err
+		}
+		err = emitEvent(stub, ChaincodeEvent{
+			Type:      EventOrderStatusChanged,
+			TicketID:  ticket.TicketID,
+			UserID:    order.UserID,
+			OldStatus: eventStatus(OrderWaitlisted),
+			NewStatus: eventStatus(OrderConfirmed)})
+		if err != nil {
+			return results, err
+		}
+		results = append(results, OrderTransitionResult{
+			UserID: order.UserID,
+			From:   OrderStatusName[OrderWaitlisted],
+			To:     OrderStatusName[OrderConfirmed],
+			OK:     true,
+			Reason: "Promoted from the waitlist of ticket " + ticket.TicketID})
+	}
+	return results, nil
+}
+
+//Helper: refill the slot an order freed when it was closed outside OrderUpdate
+func refillTicket(stub shim.ChaincodeStubInterface, closed Order, previousStatus int) error {
+	if !holdsSlot(previousStatus) {
+		return nil
+	}
+	ticket, err := retrieveTicket(stub, closed.TicketID)
+	if err != nil {
+		return err
+	}
+	capacity, err := loadCapacity(stub, ticket)
+	if err != nil {
+		return err
+	}
+	capacity.follow(closed, closed.Status)
+	_, err = promoteWaitlist(stub, ticket, capacity)
+	return err
+}
+
+//Helper: applications are taken from ApplicationOpens until ApplicationCloses, both optional
+func checkApplicationWindow(ticket Ticket, txTime time.Time) error {
+	if !ticket.ApplicationOpens.IsZero() && txTime.Before(ticket.ApplicationOpens) {
+		return conflictError("Applications to ticket " + ticket.TicketID + " open at " + ticket.ApplicationOpens.UTC().Format(time.RFC3339))
+	}
+	if !ticket.ApplicationCloses.IsZero() && !txTime.Before(ticket.ApplicationCloses) {
+		return conflictError("Applications to ticket " + ticket.TicketID + " closed at " + ticket.ApplicationCloses.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
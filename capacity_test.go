@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicketCapacityAndWaitlist(t *testing.T) {
+	stub := newTestStub(t)
+	applicants := []string{"i000002", "i000003", "i000004", "i000005"}
+	stub.addUser("i000001", HANA)
+	for _, userID := range applicants {
+		stub.addUser(userID, HANA)
+	}
+	ticket := stub.createTicket("i000001", 0, map[string]interface{}{"Ticket_MaxAssignees": 2})
+	for _, userID := range applicants {
+		stub.apply(ticket.TicketID, userID)
+	}
+
+	// confirmations beyond the capacity go to the waitlist
+	stub.asUser("i000001")
+	result := stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: applicants})
+	expected := map[string]int{"i000002": OrderConfirmed, "i000003": OrderConfirmed, "i000004": OrderWaitlisted, "i000005": OrderWaitlisted}
+	for userID, status := range expected {
+		if order := stub.order(ticket.TicketID, userID); order.Status != status {
+			t.Errorf("%s: expected %s, got %s", userID, OrderStatusName[status], OrderStatusName[order.Status])
+		}
+	}
+	if len(result.Results) != 4 || result.Results[2].To != OrderStatusName[OrderWaitlisted] {
+		t.Errorf("unexpected results %+v", result.Results)
+	}
+
+	// a full ticket confirms nobody from its waitlist
+	stub.asUser("i000001")
+	var rejected OrderUpdateResult
+	stub.mustInvoke(&rejected, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000004"}}))
+	if len(rejected.Results) != 1 || rejected.Results[0].OK {
+		t.Errorf("unexpected results %+v", rejected.Results)
+	}
+
+	// a withdrawing assignee frees the slot for the first on the waitlist
+	stub.asUser("i000002")
+	result = stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Close: []string{"i000002"}})
+	if len(result.Results) != 2 || result.Results[1].UserID != "i000004" || result.Results[1].To != OrderStatusName[OrderConfirmed] {
+		t.Errorf("unexpected results %+v", result.Results)
+	}
+	if order := stub.order(ticket.TicketID, "i000004"); order.Status != OrderConfirmed || order.WaitlistedAt != nil {
+		t.Errorf("waitlist not promoted %+v", order)
+	}
+	if order := stub.order(ticket.TicketID, "i000005"); order.Status != OrderWaitlisted {
+		t.Errorf("second on the waitlist moved %+v", order)
+	}
+}
+
+func TestApplicationWindow(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", HANA)
+	ticket := stub.createTicket("i000001", 0, map[string]interface{}{
+		"Ticket_ApplicationOpens":  stub.now.Add(time.Hour),
+		"Ticket_ApplicationCloses": stub.now.Add(2 * time.Hour),
+		"Ticket_Deadline":          stub.now.Add(3 * time.Hour),
+	})
+
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeConflict, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000002"}))
+	stub.advance(time.Hour)
+	stub.apply(ticket.TicketID, "i000002")
+	stub.advance(time.Hour)
+	stub.asUser("i000003")
+	stub.expectError(ErrCodeConflict, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000003"}))
+
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != OrderApplied {
+		t.Errorf("unexpected order %+v", order)
+	}
+}
+
+func TestTicketCapacityRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+	cases := []map[string]interface{}{
+		{"Ticket_MaxAssignees": -1},
+		{"Ticket_MaxAssignees": MaxTicketAssignees + 1},
+		{"Ticket_ApplicationOpens": stub.now.Add(2 * time.Hour), "Ticket_ApplicationCloses": stub.now.Add(time.Hour)},
+		{"Ticket_ApplicationCloses": stub.now.Add(2 * time.Hour), "Ticket_Deadline": stub.now.Add(time.Hour)},
+	}
+	for _, fields := range cases {
+		input := map[string]interface{}{"Ticket_Title": "t", "Ticket_Type": P1, "Ticket_Value": 0, "Ticket_UserID": "i000001"}
+		for field, value := range fields {
+			input[field] = value
+		}
+		stub.expectError(ErrCodeBadRequest, "TicketCreate", toJSON(t, input))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//txContext - state of one invocation, passed to the routes in place of the stub it wraps
+//the peer may simulate several transactions at once, so nothing of a transaction is kept in package variables
+//events:    events emitted so far, sent together as one EventBatch
+//journal:   journal entries written so far, numbers the next entry
+//writes:    keys written so far, nil for deleted ones
+type txContext struct {
+	shim.ChaincodeStubInterface
+
+	events  []ChaincodeEvent
+	journal int
+	writes  map[string][]byte
+}
+
+func newTxContext(stub shim.ChaincodeStubInterface) *txContext {
+	return &txContext{ChaincodeStubInterface: stub, writes: map[string][]byte{}}
+}
+
+//GetState - Fabric hands a transaction the committed value even after it wrote the key,
+//so a record saved twice in one transaction would be based on a stale read, the context returns its own writes
+//range scans still only see committed state
+func (ctx *txContext) GetState(key string) ([]byte, error) {
+	if value, written := ctx.writes[key]; written {
+		return value, nil
+	}
+	return ctx.ChaincodeStubInterface.GetState(key)
+}
+
+func (ctx *txContext) PutState(key string, value []byte) error {
+	err := ctx.ChaincodeStubInterface.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	ctx.writes[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (ctx *txContext) DelState(key string) error {
+	err := ctx.ChaincodeStubInterface.DelState(key)
+	if err != nil {
+		return err
+	}
+	ctx.writes[key] = nil
+	return nil
+}
+
+//Helper: context of the running invocation, every route is called with one by Invoke
+func txState(stub shim.ChaincodeStubInterface) (*txContext, error) {
+	ctx, ok := stub.(*txContext)
+	if !ok {
+		return nil, errors.New("txState: Called outside of an invocation")
+	}
+	return ctx, nil
+}
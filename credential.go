@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Transient map key carrying a participant password
+//passwords are only read from the transient map so that they never end up in a block
+const TransientPassword = "password"
+
+//Number of SHA-256 rounds applied to salt+password
+const credentialHashRounds = 10000
+
+//Key marking that migrateParticipantPasswords has run
+const passwordMigrationKey = "migration_participantPasswords"
+
+//Credential information, stored apart from the participant so reads never return it
+//UserID:       iXXXXXX
+//Salt:         hex salt derived from the transaction that set the password
+//Hash:         hex of the iterated SHA-256 of salt+password
+type Credential struct {
+	UserID string `json:"Credential_UserID"`
+	Salt   string `json:"Credential_Salt"`
+	Hash   string `json:"Credential_Hash"`
+}
+
+//AuthenticationResult - returned by authenticateParticipant
+type AuthenticationResult struct {
+	UserID        string `json:"Participant_UserID"`
+	Authenticated bool   `json:"authenticated"`
+}
+
+//PasswordMigrationResult - returned by migrateParticipantPasswords
+type PasswordMigrationResult struct {
+	Stripped []string `json:"stripped"`
+	Bound    []string `json:"bound"`
+}
+
+func credentialKey(userID string) string {
+	return "Credential_UserID_" + userID
+}
+
+//Helper: hash a password with the given salt
+func hashPassword(salt string, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	for i := 1; i < credentialHashRounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+//Helper: store the salted hash of a password
+//the salt is derived from the transaction ID so every endorser computes the same record
+func saveCredential(stub shim.ChaincodeStubInterface, userID string, password string) error {
+	saltSum := sha256.Sum256([]byte(stub.GetTxID() + userID))
+	salt := hex.EncodeToString(saltSum[:16])
+
+	credential := Credential{UserID: userID, Salt: salt, Hash: hashPassword(salt, password)}
+	bytes, err := json.Marshal(credential)
+	if err != nil {
+		return errors.New("saveCredential: Error marshalling credential")
+	}
+	err = stub.PutState(credentialKey(userID), bytes)
+	if err != nil {
+		return errors.New("saveCredential: Error storing credential")
+	}
+	return nil
+}
+
+//Helper: store a credential if the transient map carries a password
+func setCredentialFromTransient(stub shim.ChaincodeStubInterface, userID string) error {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errors.New("setCredentialFromTransient: Error getting transient map")
+	}
+	password, ok := transient[TransientPassword]
+	if !ok || len(password) == 0 {
+		return nil
+	}
+	return saveCredential(stub, userID, string(password))
+}
+
+//Helper: index mapping an enrolled identity to its participant
+func identityIndexKey(stub shim.ChaincodeStubInterface, mspID string, enrollmentID string) (string, error) {
+	return stub.CreateCompositeKey("Identity", []string{mspID, enrollmentID})
+}
+
+func saveIdentityIndex(stub shim.ChaincodeStubInterface, participant Participant) error {
+	key, err := identityIndexKey(stub, participant.MSPID, participant.EnrollmentID)
+	if err != nil {
+		return wrapError("saveIdentityIndex", err)
+	}
+	record, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("saveIdentityIndex: Error getting identity index")
+	}
+	if record != nil && string(record) != participant.UserID {
+		return conflictError("saveIdentityIndex: Identity " + participant.MSPID + "/" + participant.EnrollmentID + " is already bound to " + string(record))
+	}
+	err = stub.PutState(key, []byte(participant.UserID))
+	if err != nil {
+		return errors.New("saveIdentityIndex: Error storing identity index")
+	}
+	return nil
+}
+
+func deleteIdentityIndex(stub shim.ChaincodeStubInterface, participant Participant) error {
+	if participant.MSPID == "" || participant.EnrollmentID == "" {
+		return nil
+	}
+	key, err := identityIndexKey(stub, participant.MSPID, participant.EnrollmentID)
+	if err != nil {
+		return wrapError("deleteIdentityIndex", err)
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errors.New("deleteIdentityIndex: Error deleting identity index")
+	}
+	return nil
+}
+
+//Query Route: authenticateParticipant
+//checks the password passed in the transient map against the stored hash
+func (rdg *SmartContract) authenticateParticipant(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	var credential Credential
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("authenticateParticipant: Error getting transient map")
+	}
+	password, ok := transient[TransientPassword]
+	if !ok || len(password) == 0 {
+		return errorResponse(ErrCodeBadRequest, "authenticateParticipant: password is missing in the transient map")
+	}
+
+	bytes, err := stub.GetState(credentialKey(userID))
+	if err != nil {
+		return shim.Error("authenticateParticipant: Error getting credential of " + userID)
+	}
+	if bytes == nil {
+		return errorResponse(ErrCodeUnauthenticated, "authenticateParticipant: invalid user or password")
+	}
+	err = json.Unmarshal(bytes, &credential)
+	if err != nil {
+		return shim.Error("authenticateParticipant: Corrupt credential record")
+	}
+
+	hash := hashPassword(credential.Salt, string(password))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(credential.Hash)) != 1 {
+		return errorResponse(ErrCodeUnauthenticated, "authenticateParticipant: invalid user or password")
+	}
+	return successResponse("authenticateParticipant", AuthenticationResult{UserID: userID, Authenticated: true})
+}
+
+//Invoke Route: migrateParticipantPasswords
+//one-time migration: moves plaintext passwords of existing participants to salted hashes,
+//rewrites the participant records without them and binds every participant to an identity,
+//until then legacy participants cannot call restricted routes, admins run it with the exchain.admin attribute
+//records still under their bare key are moved to the namespaced key
+//older versions of the records remain in the key history of the ledger
+func (rdg *SmartContract) migrateParticipantPasswords(stub shim.ChaincodeStubInterface) peer.Response {
+	var readingIDs ReadingIDIndex
+	var result PasswordMigrationResult
+
+	done, err := stub.GetState(passwordMigrationKey)
+	if err != nil {
+		return shim.Error("migrateParticipantPasswords: Error getting migration state")
+	}
+	if done != nil {
+		return errorResponse(ErrCodeConflict, "migrateParticipantPasswords: Migration has already run in transaction "+string(done))
+	}
+
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return shim.Error("migrateParticipantPasswords: Error getting readingIDIndex array")
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return shim.Error("migrateParticipantPasswords: Error unmarshalling readingIDIndex array JSON")
+	}
+
+	for _, participantID := range readingIDs.UserIDs {
+		var raw map[string]interface{}
+		var participant Participant
+
+		bytes, err := getEntityState(stub, ObjectParticipant, participantID)
+		if err != nil || bytes == nil {
+			return shim.Error("migrateParticipantPasswords: Error getting participant " + participantID)
+		}
+		err = json.Unmarshal(bytes, &raw)
+		if err != nil {
+			return shim.Error("migrateParticipantPasswords: Corrupt participant record " + participantID)
+		}
+		err = json.Unmarshal(bytes, &participant)
+		if err != nil {
+			return shim.Error("migrateParticipantPasswords: Corrupt participant record " + participantID)
+		}
+
+		if password, ok := raw["Participant_Password"]; ok {
+			if plain, isString := password.(string); isString && plain != "" {
+				err = saveCredential(stub, participantID, plain)
+				if err != nil {
+					return routeError(err)
+				}
+			}
+			result.Stripped = append(result.Stripped, participantID)
+		}
+
+		// legacy records have no identity, they are bound to their user ID in the MSP of the admin migrating them
+		if participant.MSPID == "" {
+			if participant.EnrollmentID == "" {
+				participant.EnrollmentID = participant.UserID
+			}
+			participant.MSPID, err = cid.GetMSPID(stub)
+			if err != nil {
+				return shim.Error("migrateParticipantPasswords: Error getting MSP ID of creator: " + err.Error())
+			}
+			err = saveIdentityIndex(stub, participant)
+			if err != nil {
+				return routeError(err)
+			}
+			result.Bound = append(result.Bound, participantID)
+		}
+
+		_, err = rdg.saveParticipant(stub, participant)
+		if err != nil {
+			return routeError(err)
+		}
+
+		// before migrateKeySchema the record is also under its bare key, still with the password
+		legacy, err := getLegacyEntityState(stub, ObjectParticipant, participantID)
+		if err != nil {
+			return routeError(err)
+		}
+		if legacy != nil {
+			err = stub.DelState(legacyEntityKey(ObjectParticipant, participantID))
+			if err != nil {
+				return shim.Error("migrateParticipantPasswords: Error deleting legacy participant " + participantID)
+			}
+		}
+	}
+
+	err = stub.PutState(passwordMigrationKey, []byte(stub.GetTxID()))
+	if err != nil {
+		return shim.Error("migrateParticipantPasswords: Error storing migration state")
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error("migrateParticipantPasswords: Error marshalling result")
+	}
+	return shim.Success(resultAsBytes)
+}
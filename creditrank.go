@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Offset turning credit values into descending sort keys, CreditRank~<creditRankOffset - value>~<userID>
+//a scan of CreditRank returns the highest credit first, equal credits ordered by UserID
+const creditRankOffset = int64(1) << 62
+
+//CreditRankResult - returned by CreditRank
+type CreditRankResult struct {
+	UserID string `json:"userID"`
+	Credit int    `json:"credit"`
+	Rank   int    `json:"rank"`
+}
+
+//CreditRankRebuildResult - returned by rebuildCreditRanking
+type CreditRankRebuildResult struct {
+	Removed int `json:"removed"`
+	Indexed int `json:"indexed"`
+}
+
+func creditRankKey(stub shim.ChaincodeStubInterface, credit Credit) (string, error) {
+	score := fmt.Sprintf("%020d", creditRankOffset-int64(credit.Value))
+	return stub.CreateCompositeKey("CreditRank", []string{score, credit.UserID})
+}
+
+func saveCreditRank(stub shim.ChaincodeStubInterface, credit Credit) error {
+	key, err := creditRankKey(stub, credit)
+	if err != nil {
+		return wrapError("saveCreditRank", err)
+	}
+	err = stub.PutState(key, []byte(credit.UserID))
+	if err != nil {
+		return errors.New("saveCreditRank: Error storing credit rank of " + credit.UserID)
+	}
+	return nil
+}
+
+func deleteCreditRank(stub shim.ChaincodeStubInterface, credit Credit) error {
+	key, err := creditRankKey(stub, credit)
+	if err != nil {
+		return wrapError("deleteCreditRank", err)
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errors.New("deleteCreditRank: Error deleting credit rank of " + credit.UserID)
+	}
+	return nil
+}
+
+//Helper: move the rank entry of a credit from its stored value to its new value
+//a credit saved earlier in the same transaction is read back from the context, so its entry is the one moved
+func updateCreditRank(stub shim.ChaincodeStubInterface, credit Credit) error {
+	var previous Credit
+	previousAsBytes, err := getEntityState(stub, ObjectCredit, credit.UserID)
+	if err != nil {
+		return err
+	}
+	if previousAsBytes != nil && json.Unmarshal(previousAsBytes, &previous) == nil {
+		if previous.Value == credit.Value {
+			return saveCreditRank(stub, credit)
+		}
+		err = deleteCreditRank(stub, previous)
+		if err != nil {
+			return err
+		}
+	}
+	return saveCreditRank(stub, credit)
+}
+
+//Helper: delete a credit record together with its rank entry
+func deleteCredit(stub shim.ChaincodeStubInterface, userID string) error {
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err == nil {
+		err = deleteCreditRank(stub, credit)
+		if err != nil {
+			return err
+		}
+	}
+	return delEntityState(stub, ObjectCredit, userID)
+}
+
+//Helper: the n highest credits of registered participants, ties ordered by UserID
+func topCredits(stub shim.ChaincodeStubInterface, n int) ([]Credit, error) {
+	var credits []Credit
+	rankIterator, err := stub.GetStateByPartialCompositeKey("CreditRank", []string{})
+	if err != nil {
+		return nil, wrapError("topCredits", err)
+	}
+	defer rankIterator.Close()
+
+	for len(credits) < n && rankIterator.HasNext() {
+		queryResponse, err := rankIterator.Next()
+		if err != nil {
+			return nil, wrapError("topCredits", err)
+		}
+		userID := string(queryResponse.Value)
+		// archived participants keep their credit but are not ranked
+		_, registered, err := lookupParticipant(stub, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !registered {
+			continue
+		}
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, credit)
+	}
+	return credits, nil
+}
+
+//Helper: rank of a credit, 1 + the number of higher credits
+//the ranking is walked from the top like topCredits does, up to the first entry not above the credit
+func creditRank(stub shim.ChaincodeStubInterface, credit Credit) (int, error) {
+	own := fmt.Sprintf("%020d", creditRankOffset-int64(credit.Value))
+	rankIterator, err := stub.GetStateByPartialCompositeKey("CreditRank", []string{})
+	if err != nil {
+		return 0, wrapError("creditRank", err)
+	}
+	defer rankIterator.Close()
+
+	rank := 1
+	for rankIterator.HasNext() {
+		queryResponse, err := rankIterator.Next()
+		if err != nil {
+			return 0, wrapError("creditRank", err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) == 0 {
+			return 0, errors.New("creditRank: Corrupt ranking key " + queryResponse.Key)
+		}
+		if attributes[0] >= own {
+			break
+		}
+		rank++
+	}
+	return rank, nil
+}
+
+//Query Route: CreditRank
+func (rdg *SmartContract) CreditRank(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return routeError(err)
+	}
+	rank, err := creditRank(stub, credit)
+	if err != nil {
+		return routeError(err)
+	}
+	return successResponse("CreditRank", CreditRankResult{UserID: userID, Credit: credit.Value, Rank: rank})
+}
+
+//Invoke Route: rebuildCreditRanking
+//rebuilds the credit ranking from the credits of all registered participants,
+//needed once on ledgers written before the ranking existed
+func (rdg *SmartContract) rebuildCreditRanking(stub shim.ChaincodeStubInterface) peer.Response {
+	var readingIDs ReadingIDIndex
+	var result CreditRankRebuildResult
+	var err error
+
+	result.Removed, err = deleteByPartialKey(stub, "CreditRank", []string{})
+	if err != nil {
+		return routeError(err)
+	}
+
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return shim.Error("rebuildCreditRanking: Error getting readingIDIndex array")
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return shim.Error("rebuildCreditRanking: Error unmarshalling readingIDIndex array JSON")
+	}
+	for _, userID := range readingIDs.UserIDs {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return routeError(err)
+		}
+		err = saveCreditRank(stub, credit)
+		if err != nil {
+			return routeError(err)
+		}
+		result.Indexed++
+	}
+	return successResponse("rebuildCreditRanking", result)
+}
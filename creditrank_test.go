@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//Helper: UserIDs of the credit ranking in key order
+func (stub *testStub) rankedUserIDs() []string {
+	stub.t.Helper()
+	userIDs := []string{}
+	prefix, _ := stub.CreateCompositeKey("CreditRank", []string{})
+	for element := stub.Keys.Front(); element != nil; element = element.Next() {
+		key := element.Value.(string)
+		if strings.HasPrefix(key, prefix) {
+			userIDs = append(userIDs, string(stub.State[key]))
+		}
+	}
+	return userIDs
+}
+
+func TestCreditRankMaintained(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.grant("i000001", 30)
+	stub.grant("i000002", 20)
+	stub.grant("i000003", 20)
+	if ranked := stub.rankedUserIDs(); !equalStrings(ranked, []string{"i000001", "i000002", "i000003"}) {
+		t.Errorf("unexpected ranking %v", ranked)
+	}
+
+	// a transfer moves both entries, the old ones are removed
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "CreditTransfer", `{"from":"i000001","to":"i000003","value":25}`)
+	if ranked := stub.rankedUserIDs(); !equalStrings(ranked, []string{"i000003", "i000002", "i000001"}) {
+		t.Errorf("unexpected ranking after transfer %v", ranked)
+	}
+
+	// a credit changed twice in one transaction keeps one entry
+	stub.completeTicket(stub.createTicket("i000003", 10, nil), "i000001", "i000002")
+	if ranked := stub.rankedUserIDs(); !equalStrings(ranked, []string{"i000003", "i000002", "i000001"}) {
+		t.Errorf("unexpected ranking after award %v", ranked)
+	}
+
+	var top []ParticipantCredit
+	stub.mustInvoke(&top, "TopTenCredit")
+	if len(top) != 3 || top[0].UserID != "i000003" || top[0].Credit != 35 || top[0].UserName != "User i000003" {
+		t.Errorf("unexpected top credits %+v", top)
+	}
+
+	var rank CreditRankResult
+	stub.mustInvoke(&rank, "CreditRank", "i000002")
+	if rank.Rank != 2 || rank.Credit != 25 {
+		t.Errorf("unexpected rank %+v", rank)
+	}
+	stub.expectError(ErrCodeNotFound, "CreditRank", "i000009")
+}
+
+func TestCreditRankSharedAndArchived(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.grant("i000001", 20)
+	stub.grant("i000002", 20)
+
+	// equal credits share a rank
+	for _, userID := range []string{"i000001", "i000002"} {
+		var rank CreditRankResult
+		stub.mustInvoke(&rank, "CreditRank", userID)
+		if rank.Rank != 1 {
+			t.Errorf("%s: expected rank 1, got %+v", userID, rank)
+		}
+	}
+
+	// archived participants keep their credit but leave the ranking
+	stub.asAdmin()
+	stub.mustInvoke(nil, "ParticipantOffboard", `{"userID":"i000001","mode":"archive"}`)
+	var top []ParticipantCredit
+	stub.mustInvoke(&top, "TopTenCredit")
+	if len(top) != 2 || top[0].UserID != "i000002" {
+		t.Errorf("unexpected top credits %+v", top)
+	}
+}
+
+func TestRebuildCreditRanking(t *testing.T) {
+	stub := newLegacyStub(t)
+	if ranked := stub.rankedUserIDs(); len(ranked) != 0 {
+		t.Fatalf("legacy ledger already ranked %v", ranked)
+	}
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "rebuildCreditRanking")
+
+	stub.asAdmin()
+	var result CreditRankRebuildResult
+	stub.mustInvoke(&result, "rebuildCreditRanking")
+	if result.Indexed != 1 || result.Removed != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	stub.mustInvoke(&result, "rebuildCreditRanking")
+	if result.Indexed != 1 || result.Removed != 1 {
+		t.Errorf("unexpected result of a second rebuild %+v", result)
+	}
+	if ranked := stub.rankedUserIDs(); !equalStrings(ranked, []string{"i000001"}) {
+		t.Errorf("unexpected ranking %v", ranked)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Argument types checked before a route runs
+//   ArgString:   any non-empty string
+//   ArgID:       a UserID, TicketID or enrollment ID, see idPattern
+//   ArgInt:      a decimal integer
+//   ArgBool:     true or false
+//   ArgJSON:     a JSON object, its fields are validated by the route
+const (
+	ArgString = iota
+	ArgID
+	ArgInt
+	ArgBool
+	ArgJSON
+)
+
+var argTypeName = map[int]string{
+	ArgString: "a non-empty string",
+	ArgID:     "an ID",
+	ArgInt:    "an integer",
+	ArgBool:   "true or false",
+	ArgJSON:   "a JSON object",
+}
+
+//Route - an Invoke function and the arguments it takes
+//Args are required, Optional may follow them
+type Route struct {
+	Handler  func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response
+	Args     []int
+	Optional []int
+}
+
+//Dispatch table of Invoke, access rules are in auth.go
+var routes = map[string]Route{
+	//Participant Read Delete Update Add
+	"addParticipant": {Handler: (*SmartContract).addParticipant, Args: []int{ArgJSON}},
+	"readParticipant": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.readParticipant(stub, args[0])
+	}, Args: []int{ArgID}},
+	"readAllParticipant": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.readAllParticipant(stub)
+	}},
+	"updateParticipant": {Handler: (*SmartContract).updateParticipant, Args: []int{ArgJSON}},
+	"deleteParticipant": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.deleteParticipant(stub, args[0])
+	}, Args: []int{ArgID}},
+	"ParticipantOffboard": {Handler: (*SmartContract).ParticipantOffboard, Args: []int{ArgJSON}},
+	"TombstoneRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TombstoneRead(stub, args[0])
+	}, Args: []int{ArgID}},
+	"ParticipantChangeLoB": {Handler: (*SmartContract).ParticipantChangeLoB, Args: []int{ArgJSON}},
+	"ParticipantLoBMoves": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.ParticipantLoBMoves(stub, args[0])
+	}, Args: []int{ArgID}},
+	"authenticateParticipant": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.authenticateParticipant(stub, args[0])
+	}, Args: []int{ArgID}},
+	"migrateParticipantPasswords": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.migrateParticipantPasswords(stub)
+	}},
+	"migrateKeySchema": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.migrateKeySchema(stub)
+	}},
+
+	//Credit Read Delete Update Add
+	"CreditCreate": {Handler: (*SmartContract).CreditCreate, Args: []int{ArgID, ArgInt}},
+	"CreditRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.CreditRead(stub, args[0])
+	}, Args: []int{ArgID}},
+	"CreditAdd": {Handler: (*SmartContract).CreditAdd, Args: []int{ArgJSON}},
+	"CreditDelete": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.CreditDelete(stub, args[0])
+	}, Args: []int{ArgID}},
+	"TopTenCredit": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TopTenCredit(stub)
+	}},
+	"CreditTransfer": {Handler: (*SmartContract).CreditTransfer, Args: []int{ArgJSON}},
+
+	"Leaderboard":    {Handler: (*SmartContract).Leaderboard, Optional: []int{ArgJSON}},
+	"LoBLeaderboard": {Handler: (*SmartContract).LoBLeaderboard, Optional: []int{ArgString}},
+	"CreditRank": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.CreditRank(stub, args[0])
+	}, Args: []int{ArgID}},
+	"rebuildCreditRanking": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.rebuildCreditRanking(stub)
+	}},
+	"CreditTransferList": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.CreditTransferList(stub, args[0])
+	}, Args: []int{ArgID}},
+	"CreditStatement": {Handler: (*SmartContract).CreditStatement, Args: []int{ArgID}, Optional: []int{ArgString, ArgString}},
+
+	//Reward catalog and redemptions
+	"RewardCreate": {Handler: (*SmartContract).RewardCreate, Args: []int{ArgJSON}},
+	"RewardUpdate": {Handler: (*SmartContract).RewardUpdate, Args: []int{ArgJSON}},
+	"RewardRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.RewardRead(stub, args[0])
+	}, Args: []int{ArgID}},
+	"RewardList": {Handler: (*SmartContract).RewardList, Optional: []int{ArgBool}},
+	"Redeem":     {Handler: (*SmartContract).Redeem, Args: []int{ArgJSON}},
+	"RedemptionRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.RedemptionRead(stub, args[0])
+	}, Args: []int{ArgString}},
+	"RedemptionList": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.RedemptionList(stub, args[0])
+	}, Args: []int{ArgID}},
+	"RedemptionFulfil": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.RedemptionFulfil(stub, args[0])
+	}, Args: []int{ArgString}},
+	"RedemptionRefund": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.RedemptionRefund(stub, args[0])
+	}, Args: []int{ArgString}},
+
+	// Lob Read List Create Rename Archive
+	"LoBReadAll": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.LoBReadAll(stub)
+	}},
+	"LoBRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.LoBRead(stub, args[0])
+	}, Args: []int{ArgInt}},
+	"LoBList":   {Handler: (*SmartContract).LoBList, Optional: []int{ArgBool}},
+	"LoBCreate": {Handler: (*SmartContract).LoBCreate, Args: []int{ArgJSON}},
+	"LoBRename": {Handler: (*SmartContract).LoBRename, Args: []int{ArgInt, ArgString}},
+	"LoBArchive": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.LoBArchive(stub, args[0])
+	}, Args: []int{ArgInt}},
+
+	//Ticket Read Delete Update Add
+	"TicketCreate": {Handler: (*SmartContract).TicketCreate, Args: []int{ArgJSON}},
+	"TicketRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketRead(stub, args[0])
+	}, Args: []int{ArgID}},
+	"TicketRead2": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketRead2(stub)
+	}},
+	"TicketList":   {Handler: (*SmartContract).TicketList, Optional: []int{ArgJSON}},
+	"TicketUpdate": {Handler: (*SmartContract).TicketUpdate, Args: []int{ArgJSON}},
+	"AutoUpdateTicketStatus": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.AutoUpdateTicketStatus(stub, args[0])
+	}, Args: []int{ArgID}},
+	"TicketDelete": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketDelete(stub, args[0])
+	}, Args: []int{ArgID}},
+	"EscrowRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.EscrowRead(stub, args[0])
+	}, Args: []int{ArgID}},
+	"TicketEscrowRefund": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketEscrowRefund(stub, args[0])
+	}, Args: []int{ArgID}},
+	"TicketExpireOverdue": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketExpireOverdue(stub)
+	}},
+	"PolicyValidate": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.PolicyValidate(stub, args[0])
+	}, Args: []int{ArgString}},
+
+	//Ticket type registry
+	"TicketTypeCreate": {Handler: (*SmartContract).TicketTypeCreate, Args: []int{ArgJSON}},
+	"TicketTypeUpdate": {Handler: (*SmartContract).TicketTypeUpdate, Args: []int{ArgJSON}},
+	"TicketTypeRead": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketTypeRead(stub, args[0])
+	}, Args: []int{ArgInt}},
+	"TicketTypeList":  {Handler: (*SmartContract).TicketTypeList, Optional: []int{ArgBool}},
+	"TicketTypeStats": {Handler: (*SmartContract).TicketTypeStats, Optional: []int{ArgInt}},
+
+	//Order Read Delete Update Add
+	"OrderCreate": {Handler: (*SmartContract).OrderCreate, Args: []int{ArgJSON}},
+	"OrderRead":   {Handler: (*SmartContract).OrderRead, Args: []int{ArgID, ArgID}},
+	"OrderRead2":  {Handler: (*SmartContract).OrderRead2, Args: []int{ArgID}},
+	"OrderUpdate": {Handler: (*SmartContract).OrderUpdate, Args: []int{ArgJSON}},
+
+	"OrderApprove": {Handler: (*SmartContract).OrderApprove, Args: []int{ArgID, ArgID}},
+
+	//History of tickets and participants
+	"TicketHistory": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketHistory(stub, args[0])
+	}, Args: []int{ArgID}},
+	"history": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.TicketHistory(stub, args[0])
+	}, Args: []int{ArgID}},
+	"ParticipantHistory": {Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return rdg.ParticipantHistory(stub, args[0])
+	}, Args: []int{ArgID}},
+}
+
+//Helper: check one argument against its type
+func checkArg(errs *ValidationErrors, field string, arg string, argType int) {
+	valid := true
+	switch argType {
+	case ArgString:
+		valid = arg != ""
+	case ArgID:
+		valid = len(arg) <= MaxIDLength && idPattern.MatchString(arg)
+	case ArgInt:
+		_, err := strconv.Atoi(arg)
+		valid = err == nil
+	case ArgBool:
+		_, err := strconv.ParseBool(arg)
+		valid = err == nil
+	case ArgJSON:
+		var raw map[string]json.RawMessage
+		valid = json.Unmarshal([]byte(arg), &raw) == nil && raw != nil
+	}
+	if !valid {
+		errs.add(field, "must be "+argTypeName[argType])
+	}
+}
+
+//Helper: check the number and types of the arguments of a route
+func checkArgs(route Route, args []string) error {
+	var errs ValidationErrors
+	if len(args) < len(route.Args) || len(args) > len(route.Args)+len(route.Optional) {
+		expected := strconv.Itoa(len(route.Args))
+		if len(route.Optional) != 0 {
+			expected += " to " + strconv.Itoa(len(route.Args)+len(route.Optional))
+		}
+		errs.add("args", "expected "+expected+" arguments, got "+strconv.Itoa(len(args)))
+		return errs
+	}
+	for i, arg := range args {
+		argType := 0
+		if i < len(route.Args) {
+			argType = route.Args[i]
+		} else if arg == "" {
+			// an empty optional argument counts as not given
+			continue
+		} else {
+			argType = route.Optional[i-len(route.Args)]
+		}
+		checkArg(&errs, "args["+strconv.Itoa(i)+"]", arg, argType)
+	}
+	return errs.err()
+}
+
+//Helper: run a route and wrap its result into the response envelope
+func (rdg *SmartContract) dispatch(stub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+	return envelope(stub, rdg.runRoute(stub, function, args))
+}
+
+//Helper: run a route, a panic anywhere in it is turned into an error response
+func (rdg *SmartContract) runRoute(stub shim.ChaincodeStubInterface, function string, args []string) (response peer.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(" ****** dispatch: panic in ", function, " tx ", stub.GetTxID(), ": ", r, "\n", string(debug.Stack()))
+			response = shim.Error(fmt.Sprintf("%s: internal error in transaction %s: %v", function, stub.GetTxID(), r))
+		}
+	}()
+
+	route, exists := routes[function]
+	if !exists {
+		logger.Error("Received unknown function invocation: ", function)
+		return errorResponse(ErrCodeBadRequest, "Received unknown function invocation: "+function)
+	}
+	err := checkArgs(route, args)
+	if err != nil {
+		return validationResponse(function, err)
+	}
+
+	// ==== Check whether the caller may invoke the function ====
+	if response, ok := rdg.checkAccess(stub, function, args); !ok {
+		return response
+	}
+	return route.Handler(rdg, stub, args)
+}
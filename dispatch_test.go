@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestCheckArgs(t *testing.T) {
+	route := Route{Args: []int{ArgID, ArgInt}, Optional: []int{ArgBool}}
+	cases := []struct {
+		args   []string
+		fields []string
+	}{
+		{[]string{"i000001", "5"}, []string{}},
+		{[]string{"i000001", "5", "true"}, []string{}},
+		{[]string{"i000001", "5", ""}, []string{}},
+		{[]string{"i000001"}, []string{"args"}},
+		{[]string{"i000001", "5", "true", "x"}, []string{"args"}},
+		{[]string{"i 000001", "five"}, []string{"args[0]", "args[1]"}},
+		{[]string{"i000001", "5", "yes"}, []string{"args[2]"}},
+	}
+	for _, c := range cases {
+		errs, _ := checkArgs(route, c.args).(ValidationErrors)
+		if !equalStrings(detailFields(errs), c.fields) {
+			t.Errorf("%q: expected %v, got %v", c.args, c.fields, errs)
+		}
+	}
+	if errs := checkArgs(Route{Args: []int{ArgJSON}}, []string{`["x"]`}); errs == nil {
+		t.Error("a JSON array taken for an object")
+	}
+}
+
+func TestDispatchRejectsMalformedCalls(t *testing.T) {
+	stub := newTestStub(t)
+
+	response := stub.expectError(ErrCodeBadRequest, "readParticipant")
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"args"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+	stub.expectError(ErrCodeBadRequest, "CreditCreate", "i000001", "ten")
+	stub.expectError(ErrCodeBadRequest, "CreditAdd", `"i000001"`)
+	stub.expectError(ErrCodeBadRequest, "CreditAdd", `{"userID":7,"value":1}`)
+	stub.expectError(ErrCodeBadRequest, "OrderUpdate", `{"TicketID":"1","Confirm":"i000001"}`)
+	stub.expectError(ErrCodeBadRequest, "noSuchRoute")
+	stub.expectError(ErrCodeBadRequest, "")
+}
+
+func TestDispatchRecoversPanics(t *testing.T) {
+	routes["panicRoute"] = Route{Handler: func(rdg *SmartContract, stub shim.ChaincodeStubInterface, args []string) peer.Response {
+		var participant *Participant
+		return shim.Success([]byte(participant.UserID))
+	}}
+	accessRules["panicRoute"] = AccessRule{Policy: PolicyAny}
+	defer func() {
+		delete(routes, "panicRoute")
+		delete(accessRules, "panicRoute")
+	}()
+
+	stub := newTestStub(t)
+	response := stub.expectError(ErrCodeInternal, "panicRoute")
+	if !strings.HasPrefix(response.Message, "panicRoute: internal error in transaction "+stub.LastTxID) {
+		t.Errorf("unexpected message %s", response.Message)
+	}
+	// the chaincode keeps serving
+	var lobs []LoB
+	stub.mustInvoke(&lobs, "LoBList")
+}
+
+func TestCreditCreate(t *testing.T) {
+	stub := newTestStub(t)
+	stub.expectError(ErrCodeNotFound, "CreditRead", "i000002")
+	stub.mustInvoke(nil, "CreditCreate", "i000002", "10")
+	if value := stub.credit("i000002").Value; value != 10 {
+		t.Errorf("expected 10, got %d", value)
+	}
+	stub.expectError(ErrCodeConflict, "CreditCreate", "i000002", "5")
+
+	// participants get their credit when they are added
+	stub.addUser("i000001", HANA)
+	stub.expectError(ErrCodeConflict, "CreditCreate", "i000001", "5")
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "CreditCreate", "i000002", "5")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Error code of failures that carry no structured ErrorResponse
+const ErrCodeInternal = "INTERNAL"
+
+//Error codes of failed business rules
+//CONFLICT:              the state of a record does not allow the call, e.g. it exists already or is closed
+//INSUFFICIENT_CREDIT:   a participant has not enough credit for the call
+const (
+	ErrCodeConflict           = "CONFLICT"
+	ErrCodeInsufficientCredit = "INSUFFICIENT_CREDIT"
+)
+
+//CodedError - error of a helper that knows the ErrorResponse code it is reported with
+//Code:      one of the ErrCode constants
+//Message:   the message, prefixed with the helpers it passed like any other error
+type CodedError struct {
+	Code    string
+	Message string
+}
+
+func (err *CodedError) Error() string {
+	return err.Message
+}
+
+func notFoundError(message string) error {
+	return &CodedError{Code: ErrCodeNotFound, Message: message}
+}
+
+func forbiddenError(message string) error {
+	return &CodedError{Code: ErrCodeForbidden, Message: message}
+}
+
+func conflictError(message string) error {
+	return &CodedError{Code: ErrCodeConflict, Message: message}
+}
+
+func insufficientCreditError(message string) error {
+	return &CodedError{Code: ErrCodeInsufficientCredit, Message: message}
+}
+
+//Helper: prefix an error with the helper passing it on, a CodedError keeps its code
+func wrapError(prefix string, err error) error {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return &CodedError{Code: coded.Code, Message: prefix + ": " + err.Error()}
+	}
+	return errors.New(prefix + ": " + err.Error())
+}
+
+//Helper: failed response of a route, a CodedError is returned with its code and any other error as INTERNAL
+func routeError(err error) peer.Response {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return errorResponse(coded.Code, err.Error())
+	}
+	return shim.Error(err.Error())
+}
+
+//ResponseEnvelope - what every route returns, as payload on success and as message on failure
+//OK:      the route succeeded
+//Data:    the result of the route, null if it has none or failed
+//Error:   the failure, null on success
+//TxID:    transaction the route ran in
+type ResponseEnvelope struct {
+	OK    bool            `json:"ok"`
+	Data  json.RawMessage `json:"data"`
+	Error *ErrorResponse  `json:"error"`
+	TxID  string          `json:"txId"`
+}
+
+//Helper: success response carrying data marshalled as JSON
+func successResponse(function string, data interface{}) peer.Response {
+	dataAsBytes, err := json.Marshal(data)
+	if err != nil {
+		return shim.Error(function + ": Error marshalling result")
+	}
+	return shim.Success(dataAsBytes)
+}
+
+//Helper: wrap the response of a route into a ResponseEnvelope
+//routes return plain JSON or a shim.Error/errorResponse/routeError, this is the single place building the envelope
+func envelope(stub shim.ChaincodeStubInterface, response peer.Response) peer.Response {
+	result := ResponseEnvelope{TxID: stub.GetTxID()}
+
+	if response.Status < shim.ERRORTHRESHOLD {
+		result.OK = true
+		switch {
+		case len(response.Payload) == 0:
+			result.Data = json.RawMessage("null")
+		case json.Valid(response.Payload):
+			result.Data = json.RawMessage(response.Payload)
+		default:
+			result.Data, _ = json.Marshal(string(response.Payload))
+		}
+	} else {
+		var errorResult ErrorResponse
+		if json.Unmarshal([]byte(response.Message), &errorResult) != nil || errorResult.Code == "" {
+			errorResult = ErrorResponse{Code: ErrCodeInternal, Message: response.Message}
+		}
+		result.Data = json.RawMessage("null")
+		result.Error = &errorResult
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error("envelope: Error marshalling response")
+	}
+	if result.OK {
+		return peer.Response{Status: response.Status, Message: response.Message, Payload: resultAsBytes}
+	}
+	// the peer only hands the message of a failed proposal back to the client
+	return peer.Response{Status: response.Status, Message: string(resultAsBytes), Payload: resultAsBytes}
+}
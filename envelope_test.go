@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestEnvelopeOfSuccess(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+
+	response := stub.invoke("readParticipant", "i000001")
+	if !response.OK || response.Error != nil || response.TxID != stub.LastTxID {
+		t.Fatalf("unexpected envelope %+v", response)
+	}
+	var participant Participant
+	err := json.Unmarshal(response.Data, &participant)
+	if err != nil || participant.UserID != "i000001" {
+		t.Errorf("unexpected data %s", response.Data)
+	}
+
+	// routes without a result return null data
+	ticket := stub.createTicket("i000001", 0, nil)
+	response = stub.invoke("TicketDelete", ticket.TicketID)
+	if !response.OK || string(response.Data) != "null" {
+		t.Errorf("unexpected envelope %+v", response)
+	}
+}
+
+func TestEnvelopeOfFailure(t *testing.T) {
+	stub := newTestStub(t)
+
+	// the failure is both the message and the payload of the response
+	stub.args = [][]byte{[]byte("readParticipant"), []byte("i000009")}
+	stub.startTx()
+	response := stub.cc.Invoke(stub)
+	stub.endTx(false)
+	if response.Status == shim.OK || response.Message != string(response.Payload) {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	var result ResponseEnvelope
+	err := json.Unmarshal(response.Payload, &result)
+	if err != nil || result.OK || string(result.Data) != "null" || result.Error == nil || result.TxID != stub.LastTxID {
+		t.Fatalf("unexpected envelope %s", response.Payload)
+	}
+
+	errorResult := stub.expectError(ErrCodeNotFound, "readParticipant", "i000009")
+	if errorResult.Message == "" {
+		t.Error("error without message")
+	}
+	stub.expectError(ErrCodeNotFound, "TicketRead", "unknown")
+	stub.expectError(ErrCodeNotFound, "CreditRead", "i000009")
+
+	errorResult = stub.expectError(ErrCodeBadRequest, "addParticipant", `{"Participant_UserID":"i000001"}`)
+	if len(errorResult.Details) == 0 {
+		t.Errorf("BAD_REQUEST without details %+v", errorResult)
+	}
+}
+
+func TestRouteErrorKeepsCode(t *testing.T) {
+	err := wrapError("outer", wrapError("inner", conflictError("failed")))
+	response := routeError(err)
+	var result ErrorResponse
+	if json.Unmarshal([]byte(response.Message), &result) != nil || result.Code != ErrCodeConflict || result.Message != "outer: inner: failed" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	response = envelope(newTestStub(t), routeError(wrapError("outer", errors.New("plain"))))
+	var envelopeResult ResponseEnvelope
+	if json.Unmarshal(response.Payload, &envelopeResult) != nil || envelopeResult.Error.Code != ErrCodeInternal {
+		t.Errorf("plain errors should be INTERNAL, got %s", response.Payload)
+	}
+}
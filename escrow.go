@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Escrow status
+//Locked  ->  Released
+//        ->  Refunded
+const (
+	EscrowLocked = iota
+	EscrowReleased
+	EscrowRefunded
+)
+
+//Escrow information, the reward of a ticket reserved from its creator's credit
+//TicketID:    ticket the reward belongs to
+//UserID:      creator of the ticket, receives refunds
+//Amount:      value locked at creation
+//Remaining:   value not yet paid out or refunded
+//Status:      Locked, Released or Refunded
+//Unfunded:    nothing was taken from the creator, the ticket was awarded before it had an escrow
+//Payouts:     amount paid to each awarded user
+type Escrow struct {
+	TicketID string `json:"Escrow_TicketID"`
+	UserID   string `json:"Escrow_UserID"`
+
+	Amount    int  `json:"Escrow_Amount"`
+	Remaining int  `json:"Escrow_Remaining"`
+	Status    int  `json:"Escrow_Status"`
+	Unfunded  bool `json:"Escrow_Unfunded,omitempty"`
+
+	Payouts map[string]int `json:"Escrow_Payouts"`
+}
+
+//escrowRefund - what was left of a closed escrow, owed to the creator of its ticket
+type escrowRefund struct {
+	UserID   string
+	TicketID string
+	Value    int
+}
+
+func escrowKey(ticketID string) string {
+	return "Escrow_TicketID_" + ticketID
+}
+
+func saveEscrow(stub shim.ChaincodeStubInterface, escrow Escrow) ([]byte, error) {
+	escrowAsBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return nil, errors.New("saveEscrow: Error marshalling escrow of ticket " + escrow.TicketID)
+	}
+	err = stub.PutState(escrowKey(escrow.TicketID), escrowAsBytes)
+	if err != nil {
+		return nil, errors.New("saveEscrow: Error storing escrow of ticket " + escrow.TicketID)
+	}
+	return escrowAsBytes, nil
+}
+
+func retrieveEscrow(stub shim.ChaincodeStubInterface, ticketID string) (Escrow, error) {
+	var escrow Escrow
+	escrowAsBytes, err := stub.GetState(escrowKey(ticketID))
+	if err != nil {
+		return escrow, errors.New("retrieveEscrow: Error getting escrow of ticket " + ticketID)
+	}
+	if escrowAsBytes == nil {
+		return escrow, notFoundError("retrieveEscrow: Ticket " + ticketID + " has no escrow")
+	}
+	err = json.Unmarshal(escrowAsBytes, &escrow)
+	if err != nil {
+		return escrow, errors.New("retrieveEscrow: Corrupt escrow record " + string(escrowAsBytes))
+	}
+	return escrow, nil
+}
+
+//Helper: move credit between a participant and an escrow
+//a positive value is taken from the participant, a negative one is given back
+//LoB totals count awarded credit and are left alone, so tickets of one LoB do not conflict on it
+func debitForEscrow(stub shim.ChaincodeStubInterface, userID string, ticketID string, value int) error {
+	if value == 0 {
+		return nil
+	}
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return wrapError("debitForEscrow", err)
+	}
+	if credit.Value < value {
+		return insufficientCreditError("debitForEscrow: Insufficient credit of " + userID + " to reserve " + strconv.Itoa(value))
+	}
+	credit.Value -= value
+	_, err = saveCredit(stub, credit)
+	if err != nil {
+		return err
+	}
+	return journalCredit(stub, credit, JournalEntry{Amount: -value, Reason: ReasonEscrow, TicketID: ticketID})
+}
+
+//Helper: escrow an award is paid from
+//tickets created before escrows existed get an unfunded one, their awards are new credit as they always were
+func awardEscrow(stub shim.ChaincodeStubInterface, ticket Ticket) (Escrow, error) {
+	escrowAsBytes, err := stub.GetState(escrowKey(ticket.TicketID))
+	if err != nil {
+		return Escrow{}, errors.New("awardEscrow: Error getting escrow of ticket " + ticket.TicketID)
+	}
+	if escrowAsBytes != nil {
+		return retrieveEscrow(stub, ticket.TicketID)
+	}
+	escrow := Escrow{
+		TicketID:  ticket.TicketID,
+		UserID:    ticket.UserID,
+		Amount:    ticket.Value,
+		Remaining: ticket.Value,
+		Status:    EscrowLocked,
+		Unfunded:  true,
+		Payouts:   map[string]int{}}
+	return escrow, nil
+}
+
+//Helper: reserve the ticket value from the creator's credit
+func lockEscrow(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	err := debitForEscrow(stub, ticket.UserID, ticket.TicketID, ticket.Value)
+	if err != nil {
+		return err
+	}
+	escrow := Escrow{
+		TicketID:  ticket.TicketID,
+		UserID:    ticket.UserID,
+		Amount:    ticket.Value,
+		Remaining: ticket.Value,
+		Status:    EscrowLocked,
+		Payouts:   map[string]int{}}
+	_, err = saveEscrow(stub, escrow)
+	return err
+}
+
+//Helper: follow a change of the ticket value while nothing has been paid out
+//tickets created before escrows existed get theirs locked on their first update
+func adjustEscrow(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	escrowAsBytes, err := stub.GetState(escrowKey(ticket.TicketID))
+	if err != nil {
+		return errors.New("adjustEscrow: Error getting escrow of ticket " + ticket.TicketID)
+	}
+	if escrowAsBytes == nil {
+		return lockEscrow(stub, ticket)
+	}
+	escrow, err := retrieveEscrow(stub, ticket.TicketID)
+	if err != nil {
+		return err
+	}
+	if escrow.Amount == ticket.Value {
+		return nil
+	}
+	if escrow.Status != EscrowLocked || len(escrow.Payouts) != 0 || escrow.Unfunded {
+		return conflictError("adjustEscrow: The value of ticket " + ticket.TicketID + " cannot change after payouts")
+	}
+	err = debitForEscrow(stub, escrow.UserID, ticket.TicketID, ticket.Value-escrow.Amount)
+	if err != nil {
+		return err
+	}
+	escrow.Amount = ticket.Value
+	escrow.Remaining = ticket.Value
+	_, err = saveEscrow(stub, escrow)
+	return err
+}
+
+//Helper: pay an awarded user out of the escrow
+func payFromEscrow(escrow *Escrow, userID string, value int) error {
+	if escrow.Status != EscrowLocked {
+		return conflictError("payFromEscrow: Escrow of ticket " + escrow.TicketID + " is closed")
+	}
+	if value > escrow.Remaining {
+		return errors.New("payFromEscrow: Escrow of ticket " + escrow.TicketID + " cannot cover " + strconv.Itoa(value))
+	}
+	if escrow.Payouts == nil {
+		escrow.Payouts = map[string]int{}
+	}
+	escrow.Remaining -= value
+	escrow.Payouts[userID] += value
+	return nil
+}
+
+//Helper: close an escrow without touching any credit, the caller gives the returned refund to the creator
+//an unfunded escrow took nothing from the creator and refunds nothing
+func closeEscrow(stub shim.ChaincodeStubInterface, escrow *Escrow, status int) (escrowRefund, error) {
+	refund := escrowRefund{UserID: escrow.UserID, TicketID: escrow.TicketID, Value: escrow.Remaining}
+	if escrow.Unfunded {
+		refund.Value = 0
+	}
+	if escrow.Status != EscrowLocked {
+		return refund, conflictError("closeEscrow: Escrow of ticket " + escrow.TicketID + " is closed")
+	}
+	escrow.Remaining = 0
+	escrow.Status = status
+	_, err := saveEscrow(stub, *escrow)
+	return refund, err
+}
+
+//Helper: give the remaining value back to the creator and close the escrow
+func refundEscrow(stub shim.ChaincodeStubInterface, escrow *Escrow, status int) error {
+	refund, err := closeEscrow(stub, escrow, status)
+	if err != nil {
+		return err
+	}
+	return debitForEscrow(stub, refund.UserID, refund.TicketID, -refund.Value)
+}
+
+//Helper: add refunds to a credit the caller saves afterwards, every refund keeps its own journal entry
+func applyRefunds(stub shim.ChaincodeStubInterface, credit *Credit, refunds []escrowRefund) error {
+	for _, refund := range refunds {
+		if refund.Value == 0 {
+			continue
+		}
+		credit.Value += refund.Value
+		err := journalCredit(stub, *credit, JournalEntry{Amount: refund.Value, Reason: ReasonEscrow, TicketID: refund.TicketID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Helper: give the refunds of several escrows back, the credit of each creator is read and saved once
+//creators are handled in the order of their first refund, so every endorser writes the same
+func creditRefunds(stub shim.ChaincodeStubInterface, refunds []escrowRefund) error {
+	var creators []string
+	byCreator := map[string][]escrowRefund{}
+	for _, refund := range refunds {
+		if refund.Value == 0 {
+			continue
+		}
+		if _, seen := byCreator[refund.UserID]; !seen {
+			creators = append(creators, refund.UserID)
+		}
+		byCreator[refund.UserID] = append(byCreator[refund.UserID], refund)
+	}
+	for _, userID := range creators {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return wrapError("creditRefunds", err)
+		}
+		err = applyRefunds(stub, &credit, byCreator[userID])
+		if err != nil {
+			return err
+		}
+		_, err = saveCredit(stub, credit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Query Route: EscrowRead
+func (sc *SmartContract) EscrowRead(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
+	escrowAsBytes, err := stub.GetState(escrowKey(ticketID))
+	if err != nil {
+		return routeError(err)
+	}
+	if escrowAsBytes == nil {
+		return errorResponse(ErrCodeNotFound, "EscrowRead: Ticket "+ticketID+" has no escrow")
+	}
+	return shim.Success(escrowAsBytes)
+}
+
+//Invoke Route: TicketEscrowRefund
+//refunds the reward of a ticket whose deadline passed before any assignee completed it
+func (sc *SmartContract) TicketEscrowRefund(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
+	var ticket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return routeError(err)
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return errorResponse(ErrCodeNotFound, "TicketEscrowRefund: The ticket does not exist.")
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	if ticket.DeadLine.IsZero() || txTime.Before(ticket.DeadLine) {
+		return errorResponse(ErrCodeConflict, "TicketEscrowRefund: The ticket has not expired yet")
+	}
+
+	completed, _, err := countCompletedOrders(stub, ticketID)
+	if err != nil {
+		return routeError(err)
+	}
+	if completed != 0 {
+		return errorResponse(ErrCodeConflict, "TicketEscrowRefund: The ticket has completed orders, award them instead")
+	}
+
+	escrow, err := retrieveEscrow(stub, ticketID)
+	if err != nil {
+		return routeError(err)
+	}
+	err = refundEscrow(stub, &escrow, EscrowRefunded)
+	if err != nil {
+		return routeError(err)
+	}
+	escrowAsBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return shim.Error("TicketEscrowRefund: Error marshalling escrow")
+	}
+	return shim.Success(escrowAsBytes)
+}
+
+//Helper: count the orders of a ticket that are done or awarded
+//returns completed orders and how many of them still wait for their award
+func countCompletedOrders(stub shim.ChaincodeStubInterface, ticketID string) (int, int, error) {
+	completed := 0
+	waiting := 0
+	orderIterator, err := stub.GetStateByPartialCompositeKey("Order", []string{ticketID})
+	if err != nil {
+		return 0, 0, wrapError("countCompletedOrders", err)
+	}
+	defer orderIterator.Close()
+
+	for orderIterator.HasNext() {
+		var order Order
+		queryResponse, err := orderIterator.Next()
+		if err != nil {
+			return 0, 0, wrapError("countCompletedOrders", err)
+		}
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return 0, 0, errors.New("countCompletedOrders: Corrupt order record " + string(queryResponse.Value))
+		}
+		if order.Status == OrderDone || order.Status == OrderAwarded {
+			completed++
+		}
+		if order.Status == OrderDone {
+			waiting++
+		}
+	}
+	return completed, waiting, nil
+}
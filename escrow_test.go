@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//Helper: escrow of a ticket
+func (stub *testStub) escrow(ticketID string) Escrow {
+	stub.t.Helper()
+	var escrow Escrow
+	stub.mustInvoke(&escrow, "EscrowRead", ticketID)
+	return escrow
+}
+
+func TestEscrowLockedAndReleased(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", SMB)
+	stub.grant("i000001", 100)
+
+	ticket := stub.createTicket("i000001", 40, nil)
+	if value := stub.credit("i000001").Value; value != 60 {
+		t.Errorf("creator after TicketCreate: expected 60, got %d", value)
+	}
+	escrow := stub.escrow(ticket.TicketID)
+	if escrow.Status != EscrowLocked || escrow.Amount != 40 || escrow.Remaining != 40 || escrow.UserID != "i000001" {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+
+	// a new value takes the difference from the creator
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "TicketUpdate", toJSON(t, map[string]interface{}{
+		"Ticket_TicketID": ticket.TicketID, "Ticket_Title": ticket.Title, "Ticket_Type": P1, "Ticket_Value": 50}))
+	if value := stub.credit("i000001").Value; value != 50 {
+		t.Errorf("creator after TicketUpdate: expected 50, got %d", value)
+	}
+
+	stub.completeTicket(ticket, "i000002")
+	if value := stub.credit("i000002").Value; value != 50 {
+		t.Errorf("assignee: expected 50, got %d", value)
+	}
+	escrow = stub.escrow(ticket.TicketID)
+	if escrow.Status != EscrowReleased || escrow.Remaining != 0 || escrow.Payouts["i000002"] != 50 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+
+	// nothing is left to change once paid out
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeConflict, "TicketUpdate", toJSON(t, map[string]interface{}{
+		"Ticket_TicketID": ticket.TicketID, "Ticket_Title": ticket.Title, "Ticket_Type": P1, "Ticket_Value": 60}))
+}
+
+func TestEscrowRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.grant("i000001", 10)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeInsufficientCredit, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":1,"Ticket_Value":11,"Ticket_UserID":"i000001"}`)
+	stub.expectError(ErrCodeNotFound, "EscrowRead", "unknown")
+
+	ticket := stub.createTicket("i000001", 10, map[string]interface{}{"Ticket_Deadline": stub.now.Add(24 * time.Hour)})
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeConflict, "TicketEscrowRefund", ticket.TicketID)
+	stub.asAdmin()
+	stub.expectError(ErrCodeNotFound, "TicketEscrowRefund", "unknown")
+}
+
+func TestTicketEscrowRefund(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.grant("i000001", 10)
+	ticket := stub.createTicket("i000001", 10, map[string]interface{}{"Ticket_Deadline": stub.now.Add(24 * time.Hour)})
+
+	stub.advance(48 * time.Hour)
+	stub.asUser("i000001")
+	var escrow Escrow
+	stub.mustInvoke(&escrow, "TicketEscrowRefund", ticket.TicketID)
+	if escrow.Status != EscrowRefunded || escrow.Remaining != 0 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if value := stub.credit("i000001").Value; value != 10 {
+		t.Errorf("creator: expected 10, got %d", value)
+	}
+	stub.expectError(ErrCodeConflict, "TicketEscrowRefund", ticket.TicketID)
+}
+
+func TestTicketDelete(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 30)
+	ticket := stub.createTicket("i000001", 30, nil)
+	stub.apply(ticket.TicketID, "i000002")
+
+	// only the owner deletes
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "TicketDelete", ticket.TicketID)
+
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "TicketDelete", ticket.TicketID)
+	if value := stub.credit("i000001").Value; value != 30 {
+		t.Errorf("creator: expected the escrow back, got %d", value)
+	}
+	if escrow := stub.escrow(ticket.TicketID); escrow.Status != EscrowRefunded {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != -1 {
+		t.Errorf("order kept after TicketDelete: %+v", order)
+	}
+	stub.expectError(ErrCodeNotFound, "TicketDelete", ticket.TicketID)
+
+	stub.asAdmin()
+	stub.expectError(ErrCodeNotFound, "TicketDelete", ticket.TicketID)
+}
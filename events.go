@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//Name of the chaincode event and version of its payload
+//Fabric keeps a single event per transaction, so all events of a transaction are sent as one EventBatch
+const (
+	EventName    = "ExchainEvent"
+	EventVersion = 1
+)
+
+//Event types
+const (
+	EventParticipantAdded   = "ParticipantAdded"
+	EventParticipantUpdated = "ParticipantUpdated"
+	EventParticipantDeleted = "ParticipantDeleted"
+
+	EventParticipantLoBChanged = "ParticipantLoBChanged"
+
+	EventCreditAdded       = "CreditAdded"
+	EventCreditAwarded     = "CreditAwarded"
+	EventCreditTransferred = "CreditTransferred"
+
+	EventTicketCreated       = "TicketCreated"
+	EventTicketUpdated       = "TicketUpdated"
+	EventTicketStatusChanged = "TicketStatusChanged"
+
+	EventOrderCreated       = "OrderCreated"
+	EventOrderStatusChanged = "OrderStatusChanged"
+
+	EventRewardRedeemed          = "RewardRedeemed"
+	EventRedemptionStatusChanged = "RedemptionStatusChanged"
+)
+
+//ChaincodeEvent information
+//Type:        one of the event types above
+//TicketID:    ticket concerned, if any
+//UserID:      participant concerned, if any
+//OldStatus:   status before the change, if any
+//NewStatus:   status after the change, if any
+//Value:       credit value concerned, if any
+type ChaincodeEvent struct {
+	Type     string `json:"type"`
+	TicketID string `json:"ticketId,omitempty"`
+	UserID   string `json:"userId,omitempty"`
+
+	OldStatus *int `json:"oldStatus,omitempty"`
+	NewStatus *int `json:"newStatus,omitempty"`
+	Value     int  `json:"value,omitempty"`
+}
+
+//EventBatch - payload of the chaincode event
+type EventBatch struct {
+	Version int              `json:"version"`
+	TxID    string           `json:"txId"`
+	Events  []ChaincodeEvent `json:"events"`
+}
+
+//Helper: address of a status for the optional event fields
+func eventStatus(status int) *int {
+	return &status
+}
+
+//Helper: add an event to the transaction and set the whole batch as chaincode event
+func emitEvent(stub shim.ChaincodeStubInterface, event ChaincodeEvent) error {
+	ctx, err := txState(stub)
+	if err != nil {
+		return err
+	}
+	ctx.events = append(ctx.events, event)
+	batch := EventBatch{Version: EventVersion, TxID: stub.GetTxID(), Events: ctx.events}
+
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return errors.New("emitEvent: Error marshalling event " + event.Type)
+	}
+	err = stub.SetEvent(EventName, payload)
+	if err != nil {
+		return errors.New("emitEvent: Error setting event " + event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+//Helper: types of the events of the last invoke
+func (stub *testStub) eventTypes() []string {
+	stub.t.Helper()
+	var types []string
+	for _, event := range stub.events() {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventsOfTicketLifecycle(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 10)
+	if types := stub.eventTypes(); !equalStrings(types, []string{EventCreditAdded}) {
+		t.Errorf("CreditAdd: unexpected events %v", types)
+	}
+
+	ticket := stub.createTicket("i000001", 10, nil)
+	events := stub.events()
+	if len(events) != 1 || events[0].Type != EventTicketCreated || events[0].TicketID != ticket.TicketID ||
+		events[0].NewStatus == nil || *events[0].NewStatus != ticket.Status || events[0].Value != 10 {
+		t.Errorf("TicketCreate: unexpected events %+v", events)
+	}
+
+	stub.apply(ticket.TicketID, "i000002")
+	events = stub.events()
+	if len(events) != 1 || events[0].Type != EventOrderCreated || events[0].OldStatus != nil || *events[0].NewStatus != OrderApplied {
+		t.Errorf("OrderCreate: unexpected events %+v", events)
+	}
+
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}})
+	if types := stub.eventTypes(); !equalStrings(types, []string{EventOrderStatusChanged, EventTicketStatusChanged}) {
+		t.Errorf("confirm: unexpected events %v", types)
+	}
+	stub.asUser("i000002")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{"i000002"}})
+
+	// every event of a transaction is in its one batch
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002"}})
+	if types := stub.eventTypes(); !equalStrings(types, []string{EventOrderStatusChanged, EventCreditAwarded, EventTicketStatusChanged}) {
+		t.Errorf("award: unexpected events %v", types)
+	}
+	events = stub.events()
+	if *events[0].OldStatus != OrderDone || *events[0].NewStatus != OrderAwarded || events[1].UserID != "i000002" || events[1].Value != 10 {
+		t.Errorf("award: unexpected events %+v", events)
+	}
+}
+
+func TestNoEventsWithoutChange(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	ticket := stub.createTicket("i000001", 0, nil)
+	stub.apply(ticket.TicketID, "i000002")
+
+	// a refused transition changes nothing and emits nothing
+	stub.asUser("i000002")
+	stub.mustInvoke(nil, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}}))
+	if events := stub.events(); len(events) != 0 {
+		t.Errorf("refused transition: unexpected events %+v", events)
+	}
+
+	// queries emit nothing
+	stub.ticket(ticket.TicketID)
+	if events := stub.events(); len(events) != 0 {
+		t.Errorf("TicketRead: unexpected events %+v", events)
+	}
+
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "updateParticipant", `{"Participant_UserID":"i000002","Participant_UserName":"x","Participant_IsAdmin":true,"Participant_LoBID":0}`)
+	if events := stub.events(); len(events) != 0 {
+		t.Errorf("rejected call: unexpected events %+v", events)
+	}
+}
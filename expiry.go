@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//TicketExpireResult - returned by TicketExpireOverdue
+//Tickets:         tickets moved to Expired
+//OrdersExpired:   open orders of those tickets moved to Expired
+//Refunded:        tickets whose remaining reward went back to the creator
+type TicketExpireResult struct {
+	Tickets       []string `json:"tickets"`
+	OrdersExpired int      `json:"ordersExpired"`
+	Refunded      []string `json:"refunded"`
+}
+
+//Helper: whether the deadline of a ticket has passed at the given time, tickets without deadline never expire
+func deadlinePassed(ticket Ticket, txTime time.Time) bool {
+	return !ticket.DeadLine.IsZero() && !txTime.Before(ticket.DeadLine)
+}
+
+//Helper: a new deadline has to lie after the transaction, a deadline kept by an update may have passed
+func checkDeadline(stub shim.ChaincodeStubInterface, ticket Ticket, previous time.Time) error {
+	if ticket.DeadLine.IsZero() || ticket.DeadLine.Equal(previous) {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(ticket.DeadLine) {
+		var errs ValidationErrors
+		errs.add("Ticket_Deadline", "must be after the transaction time "+txTime.UTC().Format(time.RFC3339))
+		return errs
+	}
+	return nil
+}
+
+//Helper: whether a ticket can still expire, finished and cancelled tickets stay as they are
+func ticketExpirable(ticket Ticket) bool {
+	return ticket.Status != OrderAwarded && ticket.Status != OrderClosed && ticket.Status != OrderRejected && ticket.Status != OrderExpired
+}
+
+//Helper: move a ticket and its open orders to Expired
+//done orders keep waiting for their award, the rest of the reward goes back once none is waiting,
+//the refund is added to refunds and credited by the caller
+func expireTicket(stub shim.ChaincodeStubInterface, ticket Ticket, result *TicketExpireResult, refunds *[]escrowRefund) error {
+	orders, err := listOrders(stub, []string{ticket.TicketID})
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if order.Status != OrderCreated && order.Status != OrderApplied && order.Status != OrderConfirmed && order.Status != OrderWaitlisted {
+			continue
+		}
+		from := order.Status
+		order.Status = OrderExpired
+		order.WaitlistedAt = nil
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return err
+		}
+		err = emitEvent(stub, ChaincodeEvent{
+			Type:      EventOrderStatusChanged,
+			TicketID:  order.TicketID,
+			UserID:    order.UserID,
+			OldStatus: eventStatus(from),
+			NewStatus: eventStatus(OrderExpired)})
+		if err != nil {
+			return err
+		}
+		result.OrdersExpired++
+	}
+
+	_, waiting, err := countCompletedOrders(stub, ticket.TicketID)
+	if err != nil {
+		return err
+	}
+	escrow, err := retrieveEscrow(stub, ticket.TicketID)
+	if err == nil && escrow.Status == EscrowLocked && waiting == 0 {
+		refund, err := closeEscrow(stub, &escrow, EscrowRefunded)
+		if err != nil {
+			return err
+		}
+		*refunds = append(*refunds, refund)
+		result.Refunded = append(result.Refunded, ticket.TicketID)
+	}
+
+	oldStatus := ticket.Status
+	ticket.Status = OrderExpired
+	_, err = saveTicket(stub, ticket)
+	if err != nil {
+		return err
+	}
+	result.Tickets = append(result.Tickets, ticket.TicketID)
+	return emitEvent(stub, ChaincodeEvent{
+		Type:      EventTicketStatusChanged,
+		TicketID:  ticket.TicketID,
+		OldStatus: eventStatus(oldStatus),
+		NewStatus: eventStatus(OrderExpired)})
+}
+
+//Invoke Route: TicketExpireOverdue
+//expires every ticket whose deadline passed before the transaction time
+//a creator may get several refunds, they are summed and the credit written once
+func (sc *SmartContract) TicketExpireOverdue(stub shim.ChaincodeStubInterface) peer.Response {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	tickets, err := listAllTickets(stub)
+	if err != nil {
+		return routeError(err)
+	}
+
+	result := TicketExpireResult{Tickets: []string{}, Refunded: []string{}}
+	var refunds []escrowRefund
+	for _, ticket := range tickets {
+		if !ticketExpirable(ticket) || !deadlinePassed(ticket, txTime) {
+			continue
+		}
+		err = expireTicket(stub, ticket, &result, &refunds)
+		if err != nil {
+			return routeError(wrapError("TicketExpireOverdue", err))
+		}
+	}
+	err = creditRefunds(stub, refunds)
+	if err != nil {
+		return routeError(wrapError("TicketExpireOverdue", err))
+	}
+	return successResponse("TicketExpireOverdue", result)
+}
+
+//Helper: refuse applications to tickets that expired, whether or not TicketExpireOverdue ran since,
+//and applications outside the application window
+func checkTicketOpenForOrders(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	if ticket.Status == OrderExpired || deadlinePassed(ticket, txTime) {
+		return conflictError("Ticket " + ticket.TicketID + " has expired")
+	}
+	return checkApplicationWindow(ticket, txTime)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestString2Time(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"2024-03-01T10:00:00+01:00", time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"2024-03-01 10:00:00", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		parsed, err := string2time(c.input)
+		if err != nil || !parsed.Equal(c.expected) {
+			t.Errorf("%s: expected %v, got %v %v", c.input, c.expected, parsed, err)
+		}
+	}
+	if _, err := string2time("01.03.2024"); err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
+
+func TestTicketDeadlineChecked(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+
+	stub.asUser("i000001")
+	response := stub.expectError(ErrCodeBadRequest, "TicketCreate", toJSON(t, map[string]interface{}{
+		"Ticket_Title": "t", "Ticket_Type": P1, "Ticket_Value": 0, "Ticket_UserID": "i000001", "Ticket_Deadline": stub.now}))
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Deadline"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+
+	deadline := stub.now.Add(24 * time.Hour)
+	ticket := stub.createTicket("i000001", 0, map[string]interface{}{"Ticket_Deadline": deadline})
+	stub.advance(48 * time.Hour)
+
+	// a passed deadline may be kept, not set
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "TicketUpdate", toJSON(t, map[string]interface{}{
+		"Ticket_TicketID": ticket.TicketID, "Ticket_Title": "Renamed", "Ticket_Type": P1, "Ticket_Value": 0, "Ticket_Deadline": deadline}))
+	stub.expectError(ErrCodeBadRequest, "TicketUpdate", toJSON(t, map[string]interface{}{
+		"Ticket_TicketID": ticket.TicketID, "Ticket_Title": "Renamed", "Ticket_Type": P1, "Ticket_Value": 0, "Ticket_Deadline": stub.now.Add(-time.Hour)}))
+
+	// applications close with the deadline, whether or not the ticket was expired yet
+	stub.addUser("i000002", HANA)
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeConflict, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000002"}))
+}
+
+func TestTicketExpireOverdue(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", HANA)
+	stub.grant("i000001", 50)
+	deadline := map[string]interface{}{"Ticket_Deadline": stub.now.Add(24 * time.Hour)}
+
+	open := stub.createTicket("i000001", 20, deadline)
+	stub.apply(open.TicketID, "i000002")
+	stub.apply(open.TicketID, "i000003")
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: open.TicketID, Confirm: []string{"i000003"}})
+
+	// done work keeps its reward until it is awarded
+	waiting := stub.createTicket("i000001", 10, deadline)
+	stub.apply(waiting.TicketID, "i000002")
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: waiting.TicketID, Confirm: []string{"i000002"}})
+	stub.asUser("i000002")
+	stub.updateOrders(OrderUpdateRequest{TicketID: waiting.TicketID, Done: []string{"i000002"}})
+
+	undated := stub.createTicket("i000001", 0, nil)
+
+	stub.advance(48 * time.Hour)
+	stub.asAdmin()
+	var result TicketExpireResult
+	stub.mustInvoke(&result, "TicketExpireOverdue")
+	sort.Strings(result.Tickets)
+	expected := []string{open.TicketID, waiting.TicketID}
+	sort.Strings(expected)
+	if !equalStrings(result.Tickets, expected) || result.OrdersExpired != 2 || !equalStrings(result.Refunded, []string{open.TicketID}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if value := stub.credit("i000001").Value; value != 40 {
+		t.Errorf("creator: expected 40, got %d", value)
+	}
+	for _, userID := range []string{"i000002", "i000003"} {
+		if order := stub.order(open.TicketID, userID); order.Status != OrderExpired {
+			t.Errorf("%s: expected Expired, got %+v", userID, order)
+		}
+	}
+	if order := stub.order(waiting.TicketID, "i000002"); order.Status != OrderDone {
+		t.Errorf("done order expired %+v", order)
+	}
+	if ticket := stub.ticket(undated.TicketID); ticket.Status == OrderExpired {
+		t.Errorf("ticket without deadline expired %+v", ticket)
+	}
+
+	// the waiting award can still be paid out
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: waiting.TicketID, Award: []string{"i000002"}})
+	if value := stub.credit("i000002").Value; value != 10 {
+		t.Errorf("assignee: expected 10, got %d", value)
+	}
+
+	// expired tickets are not expired again
+	stub.asAdmin()
+	var again TicketExpireResult
+	stub.mustInvoke(&again, "TicketExpireOverdue")
+	if len(again.Tickets) != 0 || again.OrdersExpired != 0 {
+		t.Errorf("unexpected second run %+v", again)
+	}
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "TicketExpireOverdue")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//HistoryEntry - one version of a ledger record
+//TxID:        transaction that wrote the version
+//Timestamp:   timestamp of that transaction
+//IsDelete:    the transaction deleted the record
+//Value:       the record as written, null for deletes
+//Diff:        fields changed compared to the previous version
+type HistoryEntry struct {
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+
+	Value json.RawMessage        `json:"value"`
+	Diff  map[string]FieldChange `json:"diff,omitempty"`
+}
+
+//Fields left out of every history, participant records before migrateParticipantPasswords held plaintext passwords
+var hiddenHistoryFields = map[string][]string{
+	ObjectParticipant: {"Participant_Password"},
+}
+
+//FieldChange - old and new value of a changed field
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+//Helper: read the history of one key
+//legacy keys may have held other objects, only versions of the requested object are kept
+func readKeyHistory(stub shim.ChaincodeStubInterface, key string, objectType string, id string, legacy bool) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+
+	historyIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, wrapError("readKeyHistory", err)
+	}
+	defer historyIterator.Close()
+
+	lastMatched := false
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, wrapError("readKeyHistory", err)
+		}
+
+		if legacy {
+			if modification.IsDelete {
+				if !lastMatched {
+					continue
+				}
+				lastMatched = false
+			} else {
+				lastMatched = isLegacyEntity(objectType, id, modification.Value)
+				if !lastMatched {
+					continue
+				}
+			}
+		}
+
+		entry := HistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp, _ = ptypes.Timestamp(modification.Timestamp)
+		}
+		if !modification.IsDelete {
+			entry.Value = json.RawMessage(modification.Value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+//Helper: a version of a record without the given fields, versions without them are returned as they are
+func hideFields(value json.RawMessage, fields []string) json.RawMessage {
+	var record map[string]json.RawMessage
+	if value == nil || json.Unmarshal(value, &record) != nil {
+		return value
+	}
+	hidden := false
+	for _, field := range fields {
+		if _, ok := record[field]; ok {
+			delete(record, field)
+			hidden = true
+		}
+	}
+	if !hidden {
+		return value
+	}
+	valueAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(valueAsBytes)
+}
+
+//Helper: fields that differ between two JSON objects
+func diffRecords(previous json.RawMessage, current json.RawMessage) map[string]FieldChange {
+	var oldFields map[string]interface{}
+	var newFields map[string]interface{}
+	diff := map[string]FieldChange{}
+
+	if previous != nil {
+		json.Unmarshal(previous, &oldFields)
+	}
+	if current != nil {
+		json.Unmarshal(current, &newFields)
+	}
+	for field, oldValue := range oldFields {
+		newValue, ok := newFields[field]
+		if !ok || !reflect.DeepEqual(oldValue, newValue) {
+			diff[field] = FieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	for field, newValue := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			diff[field] = FieldChange{Old: nil, New: newValue}
+		}
+	}
+	return diff
+}
+
+//Helper: full history of an object, across its legacy and namespaced keys, oldest first
+func readEntityHistory(stub shim.ChaincodeStubInterface, objectType string, id string) ([]HistoryEntry, error) {
+	key, err := entityKey(stub, objectType, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readKeyHistory(stub, key, objectType, id, false)
+	if err != nil {
+		return nil, err
+	}
+	legacyKey := legacyEntityKey(objectType, id)
+	if legacyKey != "" {
+		legacyEntries, err := readKeyHistory(stub, legacyKey, objectType, id, true)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(legacyEntries, entries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	var previous json.RawMessage
+	for i := range entries {
+		entries[i].Value = hideFields(entries[i].Value, hiddenHistoryFields[objectType])
+		entries[i].Diff = diffRecords(previous, entries[i].Value)
+		previous = entries[i].Value
+	}
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+	return entries, nil
+}
+
+//Helper: respond with the history of an object
+func historyResponse(stub shim.ChaincodeStubInterface, objectType string, id string) peer.Response {
+	entries, err := readEntityHistory(stub, objectType, id)
+	if err != nil {
+		return routeError(err)
+	}
+	entriesAsBytes, err := json.Marshal(entries)
+	if err != nil {
+		return shim.Error("historyResponse: Error marshalling history of " + objectType + " " + id)
+	}
+	return shim.Success(entriesAsBytes)
+}
+
+//Query Route: TicketHistory
+func (sc *SmartContract) TicketHistory(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
+	return historyResponse(stub, ObjectTicket, ticketID)
+}
+
+//Query Route: ParticipantHistory
+//only for the participant itself or admins, passwords of old versions are left out
+func (sc *SmartContract) ParticipantHistory(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	return historyResponse(stub, ObjectParticipant, userID)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTicketHistory(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	ticket := stub.createTicket("i000001", 0, nil)
+	created := stub.LastTxID
+
+	stub.advance(time.Hour)
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "TicketUpdate", toJSON(t, map[string]interface{}{
+		"Ticket_TicketID": ticket.TicketID, "Ticket_Title": "Renamed", "Ticket_Type": P1, "Ticket_Value": 0}))
+	updated := stub.LastTxID
+
+	stub.advance(time.Hour)
+	stub.mustInvoke(nil, "TicketDelete", ticket.TicketID)
+	deleted := stub.LastTxID
+
+	var entries []HistoryEntry
+	stub.mustInvoke(&entries, "TicketHistory", ticket.TicketID)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 versions, got %+v", entries)
+	}
+	if entries[0].TxID != created || entries[1].TxID != updated || entries[2].TxID != deleted || !entries[2].IsDelete {
+		t.Errorf("unexpected versions %+v", entries)
+	}
+	if !entries[1].Timestamp.Equal(entries[0].Timestamp.Add(time.Hour)) {
+		t.Errorf("unexpected timestamps %v %v", entries[0].Timestamp, entries[1].Timestamp)
+	}
+	if len(entries[1].Diff) != 1 || entries[1].Diff["Ticket_Title"].New != "Renamed" || entries[1].Diff["Ticket_Title"].Old != ticket.Title {
+		t.Errorf("unexpected diff %+v", entries[1].Diff)
+	}
+	if string(entries[2].Value) != "null" || len(entries[2].Diff) == 0 {
+		t.Errorf("unexpected delete %+v", entries[2])
+	}
+
+	// the former route name returns the same
+	var former []HistoryEntry
+	stub.mustInvoke(&former, "history", ticket.TicketID)
+	if len(former) != len(entries) {
+		t.Errorf("history: expected %d versions, got %d", len(entries), len(former))
+	}
+
+	var none []HistoryEntry
+	stub.mustInvoke(&none, "TicketHistory", "unknown")
+	if none == nil || len(none) != 0 {
+		t.Errorf("expected an empty history, got %+v", none)
+	}
+}
+
+func TestParticipantHistoryHidesPasswords(t *testing.T) {
+	stub := newTestStubWithState(t, map[string]string{
+		"readingIDIndex": `{"UserIDs":["i000001"]}`,
+		"i000001":        `{"Participant_UserID":"i000001","Participant_UserName":"Legacy","Participant_Password":"secret","Participant_IsAdmin":false,"Participant_LoBID":1}`,
+	})
+
+	var entries []HistoryEntry
+	stub.mustInvoke(&entries, "ParticipantHistory", "i000001")
+	if len(entries) != 1 {
+		t.Fatalf("expected the legacy version, got %+v", entries)
+	}
+	var record map[string]interface{}
+	err := json.Unmarshal(entries[0].Value, &record)
+	if err != nil || record["Participant_UserName"] != "Legacy" {
+		t.Errorf("unexpected version %s", entries[0].Value)
+	}
+	if _, ok := record["Participant_Password"]; ok {
+		t.Errorf("password in history %s", entries[0].Value)
+	}
+	if _, ok := entries[0].Diff["Participant_Password"]; ok {
+		t.Errorf("password in diff %+v", entries[0].Diff)
+	}
+
+	// only the participant itself and admins read it
+	stub.addUser("i000002", HANA)
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "ParticipantHistory", "i000001")
+}
+
+func TestDiffRecords(t *testing.T) {
+	diff := diffRecords(json.RawMessage(`{"a":1,"b":"x","c":true}`), json.RawMessage(`{"a":1,"b":"y","d":null}`))
+	if len(diff) != 3 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if diff["b"].Old != "x" || diff["b"].New != "y" || diff["c"].New != nil || diff["d"].Old != nil {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+	if diff := diffRecords(nil, json.RawMessage(`{"a":1}`)); len(diff) != 1 || diff["a"].New != float64(1) {
+		t.Errorf("unexpected diff of a new record %+v", diff)
+	}
+}
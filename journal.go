@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Reasons of a credit journal entry
+//   award:        paid out of the escrow of a completed ticket
+//   grant:        added by an admin through CreditCreate or CreditAdd
+//   transfer:     sent to or received from another participant
+//   redemption:   spent on a reward
+//   correction:   fixed by an admin through CreditAdd
+//   escrow:       reserved for or given back from the reward of an own ticket
+const (
+	ReasonAward      = "award"
+	ReasonGrant      = "grant"
+	ReasonTransfer   = "transfer"
+	ReasonRedemption = "redemption"
+	ReasonCorrection = "correction"
+	ReasonEscrow     = "escrow"
+)
+
+//Ticket ID CreditAdd used to take for credit added without a ticket
+const creditAddNoTicket = "creditADD"
+
+//JournalEntry information, one change of a credit
+//journal entries are only ever added, CreditJournal~<userID>~<timestamp>~<txID>~<seq>
+//EntryID:        <txID>-<seq>
+//UserID:         iXXXXXX
+//Amount:         change of the credit, negative for debits
+//Balance:        credit after the change
+//Reason:         one of the reasons above
+//TicketID:       ticket the change comes from, if any
+//RedemptionID:   redemption the change comes from, if any
+//Counterparty:   other participant of a transfer, if any
+//IssuedBy:       enrollment ID of the admin who granted or corrected the credit, if any
+//Timestamp:      transaction timestamp
+type JournalEntry struct {
+	EntryID string `json:"Journal_EntryID"`
+	UserID  string `json:"Journal_UserID"`
+
+	Amount       int    `json:"Journal_Amount"`
+	Balance      int    `json:"Journal_Balance"`
+	Reason       string `json:"Journal_Reason"`
+	TicketID     string `json:"Journal_TicketID,omitempty"`
+	RedemptionID string `json:"Journal_RedemptionID,omitempty"`
+	Counterparty string `json:"Journal_Counterparty,omitempty"`
+	IssuedBy     string `json:"Journal_IssuedBy,omitempty"`
+
+	TxID      string    `json:"Journal_TxID"`
+	Timestamp time.Time `json:"Journal_Timestamp"`
+}
+
+//CreditStatement - returned by CreditStatement
+//OpeningBalance is the credit at From, ClosingBalance the credit at To
+type CreditStatement struct {
+	UserID         string         `json:"userID"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	OpeningBalance int            `json:"openingBalance"`
+	ClosingBalance int            `json:"closingBalance"`
+	Entries        []JournalEntry `json:"entries"`
+}
+
+//Helper: enrollment ID of the caller, recorded as issuer of grants and corrections
+func callerEnrollmentID(stub shim.ChaincodeStubInterface) string {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return ""
+	}
+	return caller.EnrollmentID
+}
+
+//Helper: add an entry to the journal of a credit that has just been saved
+//entry.Balance is taken from the saved credit, the key and times from the transaction
+func journalCredit(stub shim.ChaincodeStubInterface, credit Credit, entry JournalEntry) error {
+	if entry.Amount == 0 {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	ctx, err := txState(stub)
+	if err != nil {
+		return err
+	}
+	txID := stub.GetTxID()
+
+	// a transaction may change the same credit several times, the sequence keeps its entries apart and in order
+	seq := ctx.journal
+	ctx.journal++
+
+	entry.EntryID = fmt.Sprintf("%s-%d", txID, seq)
+	entry.UserID = credit.UserID
+	entry.Balance = credit.Value
+	entry.TxID = txID
+	entry.Timestamp = txTime
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.New("journalCredit: Error marshalling journal entry of " + credit.UserID)
+	}
+	key, err := stub.CreateCompositeKey("CreditJournal", []string{credit.UserID, fmt.Sprintf("%020d", txTime.UnixNano()), txID, fmt.Sprintf("%04d", seq)})
+	if err != nil {
+		return wrapError("journalCredit", err)
+	}
+	err = stub.PutState(key, entryAsBytes)
+	if err != nil {
+		return errors.New("journalCredit: Error storing journal entry of " + credit.UserID)
+	}
+	return nil
+}
+
+//Helper: journal entries of a user with a timestamp in [from, to), oldest first
+//GetStateByRange rejects composite keys, so the journal of the user is scanned and the window checked on the key
+func listJournal(stub shim.ChaincodeStubInterface, userID string, from time.Time, to time.Time) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	start, end := fmt.Sprintf("%020d", from.UnixNano()), fmt.Sprintf("%020d", to.UnixNano())
+	journalIterator, err := stub.GetStateByPartialCompositeKey("CreditJournal", []string{userID})
+	if err != nil {
+		return nil, wrapError("listJournal", err)
+	}
+	defer journalIterator.Close()
+
+	for journalIterator.HasNext() {
+		var entry JournalEntry
+		queryResponse, err := journalIterator.Next()
+		if err != nil {
+			return nil, wrapError("listJournal", err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) < 2 {
+			return nil, errors.New("listJournal: Corrupt journal key " + queryResponse.Key)
+		}
+		// timestamps have a fixed width, keys come in time order
+		if attributes[1] < start {
+			continue
+		}
+		if attributes[1] >= end {
+			break
+		}
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, errors.New("listJournal: Corrupt journal entry " + string(queryResponse.Value))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+//Helper: start and end of a statement, both optional RFC3339 arguments
+//without them the statement covers the whole journal
+func statementWindow(args []string) (time.Time, time.Time, error) {
+	var errs ValidationErrors
+	from, to := time.Unix(0, 0).UTC(), time.Unix(0, 1<<62).UTC()
+	if len(args) > 1 && args[1] != "" {
+		errs.rfc3339("from", args[1])
+		from, _ = time.Parse(time.RFC3339, args[1])
+	}
+	if len(args) > 2 && args[2] != "" {
+		errs.rfc3339("to", args[2])
+		to, _ = time.Parse(time.RFC3339, args[2])
+	}
+	if errs.err() == nil && !from.Before(to) {
+		errs.add("to", "must be after from")
+	}
+	return from, to, errs.err()
+}
+
+//Query Route: CreditStatement
+//args: userID, from and to, the journal entries in [from, to) and the balances around them
+func (rdg *SmartContract) CreditStatement(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	from, to, err := statementWindow(args)
+	if err != nil {
+		return validationResponse("CreditStatement", err)
+	}
+	userID := args[0]
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return routeError(err)
+	}
+
+	statement := CreditStatement{UserID: userID, From: from, To: to}
+	statement.Entries, err = listJournal(stub, userID, from, to)
+	if err != nil {
+		return routeError(err)
+	}
+	if statement.Entries == nil {
+		statement.Entries = []JournalEntry{}
+	}
+
+	// ==== Opening balance: the last entry before the window, else the balance before the first entry after it ====
+	// credits older than the journal have no entries for their start, their balance before the first entry is used
+	before, err := listJournal(stub, userID, time.Unix(0, 0), from)
+	if err != nil {
+		return routeError(err)
+	}
+	switch {
+	case len(before) != 0:
+		statement.OpeningBalance = before[len(before)-1].Balance
+	case len(statement.Entries) != 0:
+		statement.OpeningBalance = statement.Entries[0].Balance - statement.Entries[0].Amount
+	default:
+		after, err := listJournal(stub, userID, to, time.Unix(0, 1<<62))
+		if err != nil {
+			return routeError(err)
+		}
+		if len(after) != 0 {
+			statement.OpeningBalance = after[0].Balance - after[0].Amount
+		} else {
+			statement.OpeningBalance = credit.Value
+		}
+	}
+
+	statement.ClosingBalance = statement.OpeningBalance
+	if len(statement.Entries) != 0 {
+		statement.ClosingBalance = statement.Entries[len(statement.Entries)-1].Balance
+	}
+	return successResponse("CreditStatement", statement)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+//Helper: reason and amount of each entry, as "grant:50"
+func journalReasons(entries []JournalEntry) []string {
+	reasons := []string{}
+	for _, entry := range entries {
+		reasons = append(reasons, entry.Reason+":"+strconv.Itoa(entry.Amount))
+	}
+	return reasons
+}
+
+func TestCreditStatement(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 50)
+	ticket := stub.createTicket("i000001", 20, nil)
+	stub.advance(31 * 24 * time.Hour)
+	stub.asUser("i000001")
+	stub.mustInvoke(nil, "CreditTransfer", `{"from":"i000001","to":"i000002","value":10}`)
+	stub.advance(time.Minute)
+	stub.completeTicket(ticket, "i000002")
+
+	stub.asUser("i000001")
+	var statement CreditStatement
+	stub.mustInvoke(&statement, "CreditStatement", "i000001")
+	if reasons := journalReasons(statement.Entries); !equalStrings(reasons, []string{"grant:50", "escrow:-20", "transfer:-10"}) {
+		t.Fatalf("unexpected entries %v", reasons)
+	}
+	if statement.OpeningBalance != 0 || statement.ClosingBalance != 20 || statement.Entries[1].Balance != 30 {
+		t.Errorf("unexpected balances %+v", statement)
+	}
+	if entry := statement.Entries[0]; entry.IssuedBy != testAdminID || entry.EntryID != entry.TxID+"-0" {
+		t.Errorf("unexpected grant %+v", entry)
+	}
+	if entry := statement.Entries[1]; entry.TicketID != ticket.TicketID {
+		t.Errorf("unexpected escrow entry %+v", entry)
+	}
+	if entry := statement.Entries[2]; entry.Counterparty != "i000002" {
+		t.Errorf("unexpected transfer entry %+v", entry)
+	}
+
+	cases := []struct {
+		from, to         string
+		reasons          []string
+		opening, closing int
+	}{
+		{"2024-04-01T00:00:00Z", "", []string{"transfer:-10"}, 30, 20},
+		{"2024-05-01T00:00:00Z", "", []string{}, 20, 20},
+		{"2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z", []string{}, 0, 0},
+	}
+	for _, c := range cases {
+		var statement CreditStatement
+		stub.mustInvoke(&statement, "CreditStatement", "i000001", c.from, c.to)
+		if reasons := journalReasons(statement.Entries); !equalStrings(reasons, c.reasons) || statement.OpeningBalance != c.opening || statement.ClosingBalance != c.closing {
+			t.Errorf("%s..%s: unexpected statement %v %d %d", c.from, c.to, reasons, statement.OpeningBalance, statement.ClosingBalance)
+		}
+	}
+
+	// the award pays the assignee
+	stub.asUser("i000002")
+	var assignee CreditStatement
+	stub.mustInvoke(&assignee, "CreditStatement", "i000002")
+	if reasons := journalReasons(assignee.Entries); !equalStrings(reasons, []string{"transfer:10", "award:20"}) || assignee.ClosingBalance != 30 {
+		t.Errorf("unexpected statement of the assignee %v %+v", reasons, assignee)
+	}
+}
+
+func TestCreditAddJournal(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.asAdmin()
+	stub.mustInvoke(nil, "CreditAdd", `{"userID":"i000001","value":10,"ticketID":"T1"}`)
+	stub.mustInvoke(nil, "CreditAdd", `{"userID":"i000001","value":-4,"reason":"correction"}`)
+
+	// a ticket is granted once
+	stub.expectError(ErrCodeConflict, "CreditAdd", `{"userID":"i000001","value":10,"ticketID":"T1"}`)
+	stub.expectError(ErrCodeBadRequest, "CreditAdd", `{"userID":"i000001","value":10,"reason":"award"}`)
+
+	var statement CreditStatement
+	stub.mustInvoke(&statement, "CreditStatement", "i000001")
+	if reasons := journalReasons(statement.Entries); !equalStrings(reasons, []string{"grant:10", "correction:-4"}) || statement.ClosingBalance != 6 {
+		t.Errorf("unexpected statement %v %+v", reasons, statement)
+	}
+	if statement.Entries[0].TicketID != "T1" {
+		t.Errorf("unexpected grant %+v", statement.Entries[0])
+	}
+}
+
+func TestCreditStatementRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeBadRequest, "CreditStatement", "i000001", "March")
+	stub.expectError(ErrCodeBadRequest, "CreditStatement", "i000001", "2024-04-01T00:00:00Z", "2024-03-01T00:00:00Z")
+	stub.expectError(ErrCodeForbidden, "CreditStatement", "i000002")
+
+	stub.asAdmin()
+	stub.expectError(ErrCodeNotFound, "CreditStatement", "i000009")
+	stub.expectError(ErrCodeNotFound, "CreditAdd", `{"userID":"i000009","value":10}`)
+}
+
+func TestCreditStatementWithoutJournal(t *testing.T) {
+	stub := newLegacyStub(t)
+	var statement CreditStatement
+	stub.mustInvoke(&statement, "CreditStatement", "i000001")
+	if len(statement.Entries) != 0 || statement.OpeningBalance != 7 || statement.ClosingBalance != 7 {
+		t.Errorf("unexpected statement %+v", statement)
+	}
+}
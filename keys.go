@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Object types of the namespaced keys, Participant~id, Ticket~id, LoB~id and Credit~id
+const (
+	ObjectParticipant = "Participant"
+	ObjectTicket      = "Ticket"
+	ObjectLoB         = "LoB"
+	ObjectCredit      = "Credit"
+)
+
+//Versions of the key scheme
+//   0:   bare keys: UserID, TicketID, LoB name and Credit_UerID_<UserID>
+//   1:   composite keys per object type
+const (
+	KeySchemaLegacy     = 0
+	KeySchemaNamespaced = 1
+)
+
+const keySchemaVersionKey = "KeySchemaVersion"
+
+//Field holding the ID in the JSON of each object type,
+//a record under a bare key is only taken for the object if it carries the same ID
+var objectIDField = map[string]string{
+	ObjectParticipant: "Participant_UserID",
+	ObjectTicket:      "Ticket_TicketID",
+	ObjectLoB:         "LoB_LoBID",
+	ObjectCredit:      "Credit_UserID",
+}
+
+//KeyMigrationResult - returned by migrateKeySchema
+type KeyMigrationResult struct {
+	Version      int `json:"version"`
+	Participants int `json:"participants"`
+	Credits      int `json:"credits"`
+	LoBs         int `json:"lobs"`
+	Tickets      int `json:"tickets"`
+}
+
+func entityKey(stub shim.ChaincodeStubInterface, objectType string, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return "", errors.New("entityKey: Invalid " + objectType + " ID " + id + ": " + err.Error())
+	}
+	return key, nil
+}
+
+//Helper: key an object had before the namespaced scheme
+func legacyEntityKey(objectType string, id string) string {
+	switch objectType {
+	case ObjectLoB:
+		lobID, err := strconv.Atoi(id)
+		if err != nil || lobID < 0 || lobID >= NumberOfLoBs {
+			return ""
+		}
+		return Lob_Name[lobID]
+	case ObjectCredit:
+		return "Credit_UerID_" + id
+	}
+	return id
+}
+
+//Helper: whether a record read under a bare key is the requested object
+func isLegacyEntity(objectType string, id string, value []byte) bool {
+	var raw map[string]interface{}
+	if json.Unmarshal(value, &raw) != nil {
+		return false
+	}
+	switch field := raw[objectIDField[objectType]].(type) {
+	case string:
+		return field == id
+	case float64:
+		return strconv.Itoa(int(field)) == id
+	}
+	return false
+}
+
+func getKeySchemaVersion(stub shim.ChaincodeStubInterface) (int, error) {
+	versionAsBytes, err := stub.GetState(keySchemaVersionKey)
+	if err != nil {
+		return 0, errors.New("getKeySchemaVersion: Error getting key schema version")
+	}
+	if versionAsBytes == nil {
+		return KeySchemaLegacy, nil
+	}
+	return strconv.Atoi(string(versionAsBytes))
+}
+
+//Helper: read the legacy record of an object, if the ledger has not been migrated yet
+func getLegacyEntityState(stub shim.ChaincodeStubInterface, objectType string, id string) ([]byte, error) {
+	version, err := getKeySchemaVersion(stub)
+	if err != nil || version >= KeySchemaNamespaced {
+		return nil, err
+	}
+	legacyKey := legacyEntityKey(objectType, id)
+	if legacyKey == "" {
+		return nil, nil
+	}
+	value, err := stub.GetState(legacyKey)
+	if err != nil {
+		return nil, errors.New("getLegacyEntityState: Error getting " + objectType + " " + id)
+	}
+	if value == nil || !isLegacyEntity(objectType, id, value) {
+		return nil, nil
+	}
+	return value, nil
+}
+
+//Helper: read an object, falling back to its bare key until the ledger is migrated
+func getEntityState(stub shim.ChaincodeStubInterface, objectType string, id string) ([]byte, error) {
+	key, err := entityKey(stub, objectType, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("getEntityState: Error getting " + objectType + " " + id)
+	}
+	if value != nil {
+		return value, nil
+	}
+	return getLegacyEntityState(stub, objectType, id)
+}
+
+//Helper: write an object under its namespaced key
+func putEntityState(stub shim.ChaincodeStubInterface, objectType string, id string, value []byte) error {
+	key, err := entityKey(stub, objectType, id)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return errors.New("putEntityState: Error storing " + objectType + " " + id)
+	}
+	return nil
+}
+
+//Helper: delete an object, including its legacy record so it cannot reappear through the fallback
+func delEntityState(stub shim.ChaincodeStubInterface, objectType string, id string) error {
+	key, err := entityKey(stub, objectType, id)
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errors.New("delEntityState: Error deleting " + objectType + " " + id)
+	}
+	legacy, err := getLegacyEntityState(stub, objectType, id)
+	if err != nil {
+		return err
+	}
+	if legacy != nil {
+		err = stub.DelState(legacyEntityKey(objectType, id))
+		if err != nil {
+			return errors.New("delEntityState: Error deleting legacy " + objectType + " " + id)
+		}
+	}
+	return nil
+}
+
+//Helper: move one object from its bare key to its namespaced key
+func migrateEntity(stub shim.ChaincodeStubInterface, objectType string, id string) (bool, error) {
+	legacy, err := getLegacyEntityState(stub, objectType, id)
+	if err != nil || legacy == nil {
+		return false, err
+	}
+	// a record already written under the namespaced key is newer than the legacy one
+	key, err := entityKey(stub, objectType, id)
+	if err != nil {
+		return false, err
+	}
+	current, err := stub.GetState(key)
+	if err != nil {
+		return false, errors.New("migrateEntity: Error getting " + objectType + " " + id)
+	}
+	if current == nil {
+		err = putEntityState(stub, objectType, id, legacy)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = stub.DelState(legacyEntityKey(objectType, id))
+	if err != nil {
+		return false, errors.New("migrateEntity: Error deleting legacy " + objectType + " " + id)
+	}
+	return true, nil
+}
+
+//Invoke Route: migrateKeySchema
+//rewrites participants, credits, LoBs and tickets from bare keys to namespaced keys
+func (rdg *SmartContract) migrateKeySchema(stub shim.ChaincodeStubInterface) peer.Response {
+	var readingIDs ReadingIDIndex
+	result := KeyMigrationResult{Version: KeySchemaNamespaced}
+
+	version, err := getKeySchemaVersion(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	if version >= KeySchemaNamespaced {
+		return errorResponse(ErrCodeConflict, "migrateKeySchema: Key schema is already at version "+strconv.Itoa(version))
+	}
+
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return shim.Error("migrateKeySchema: Error getting readingIDIndex array")
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return shim.Error("migrateKeySchema: Error unmarshalling readingIDIndex array JSON")
+	}
+
+	for _, participantID := range readingIDs.UserIDs {
+		moved, err := migrateEntity(stub, ObjectParticipant, participantID)
+		if err != nil {
+			return routeError(err)
+		}
+		if moved {
+			result.Participants++
+		}
+		moved, err = migrateEntity(stub, ObjectCredit, participantID)
+		if err != nil {
+			return routeError(err)
+		}
+		if moved {
+			result.Credits++
+		}
+	}
+
+	// only the default LoBs ever had bare keys
+	for lobID := 0; lobID < NumberOfLoBs; lobID++ {
+		moved, err := migrateEntity(stub, ObjectLoB, strconv.Itoa(lobID))
+		if err != nil {
+			return routeError(err)
+		}
+		if moved {
+			result.LoBs++
+		}
+	}
+
+	TICKETIDAsBytes, _ := stub.GetState("TICKETID")
+	lastTicketID, _ := strconv.Atoi(string(TICKETIDAsBytes))
+	for i := 1; i <= lastTicketID; i++ {
+		moved, err := migrateEntity(stub, ObjectTicket, strconv.Itoa(i))
+		if err != nil {
+			return routeError(err)
+		}
+		if moved {
+			result.Tickets++
+		}
+	}
+
+	err = stub.PutState(keySchemaVersionKey, []byte(strconv.Itoa(KeySchemaNamespaced)))
+	if err != nil {
+		return shim.Error("migrateKeySchema: Error storing key schema version")
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error("migrateKeySchema: Error marshalling result")
+	}
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+//Helper: ledger written before the namespaced key scheme, i000001 in HANA with ticket 1
+func newLegacyStub(t *testing.T) *testStub {
+	t.Helper()
+	return newTestStubWithState(t, map[string]string{
+		"readingIDIndex":       `{"UserIDs":["i000001"]}`,
+		"TICKETID":             "1",
+		"i000001":              `{"Participant_UserID":"i000001","Participant_UserName":"Legacy","Participant_IsAdmin":false,"Participant_LoBID":1}`,
+		"Credit_UerID_i000001": `{"Credit_UserID":"i000001","Credit_Value":7,"Credit_TicketIDs":[]}`,
+		"HANA":                 `{"LoB_LoBID":1,"LoB_Name":"HANA","LoB_TotalCredit":7,"LoB_UserIDs":["i000001"]}`,
+		"1":                    `{"Ticket_TicketID":"1","Ticket_Status":1,"Ticket_Title":"Legacy","Ticket_Type":0,"Ticket_Value":0,"Ticket_UserID":"i000001"}`,
+	})
+}
+
+func TestLegacyKeysReadable(t *testing.T) {
+	stub := newLegacyStub(t)
+
+	var participant Participant
+	stub.mustInvoke(&participant, "readParticipant", "i000001")
+	if participant.UserName != "Legacy" {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	if value := stub.credit("i000001").Value; value != 7 {
+		t.Errorf("credit: expected 7, got %d", value)
+	}
+	if lob := stub.lob(HANA); lob.TotalCredit != 7 || len(lob.Members) != 1 {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	if ticket := stub.ticket("1"); ticket.Title != "Legacy" {
+		t.Errorf("unexpected ticket %+v", ticket)
+	}
+
+	// a bare key holding another object is not taken for the one asked for
+	stub.expectError(ErrCodeNotFound, "readParticipant", "HANA")
+}
+
+func TestMigrateKeySchema(t *testing.T) {
+	stub := newLegacyStub(t)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "migrateKeySchema")
+
+	stub.asAdmin()
+	var result KeyMigrationResult
+	stub.mustInvoke(&result, "migrateKeySchema")
+	expected := KeyMigrationResult{Version: KeySchemaNamespaced, Participants: 1, Credits: 1, LoBs: 1, Tickets: 1}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	for _, key := range []string{"i000001", "Credit_UerID_i000001", "HANA", "1"} {
+		if stub.State[key] != nil {
+			t.Errorf("bare key %s kept", key)
+		}
+	}
+	if value := stub.credit("i000001").Value; value != 7 {
+		t.Errorf("credit after migration: expected 7, got %d", value)
+	}
+	if ticket := stub.ticket("1"); ticket.Title != "Legacy" {
+		t.Errorf("unexpected ticket after migration %+v", ticket)
+	}
+
+	stub.expectError(ErrCodeConflict, "migrateKeySchema")
+}
+
+func TestNewLedgerUsesNamespacedKeys(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	if stub.State["i000001"] != nil {
+		t.Error("participant stored under its bare key")
+	}
+	key, _ := stub.CreateCompositeKey(ObjectParticipant, []string{"i000001"})
+	if stub.State[key] == nil {
+		t.Error("participant not stored under its namespaced key")
+	}
+	stub.expectError(ErrCodeConflict, "migrateKeySchema")
+}
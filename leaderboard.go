@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Leaderboard scopes
+//   global:       every participant
+//   lob:          members of one LoB
+//   ticketType:   credit awarded for tickets of one type
+const (
+	ScopeGlobal     = "global"
+	ScopeLoB        = "lob"
+	ScopeTicketType = "ticketType"
+)
+
+//Leaderboard time windows, based on award timestamps in UTC
+//   all:       lifetime, global and lob rank by credit balance
+//   month:     the calendar month of the transaction
+//   quarter:   the calendar quarter of the transaction
+//   custom:    from .. to
+const (
+	WindowAll     = "all"
+	WindowMonth   = "month"
+	WindowQuarter = "quarter"
+	WindowCustom  = "custom"
+)
+
+//LoB leaderboard rankings
+const (
+	RankByTotal     = "total"
+	RankByPerMember = "perMember"
+)
+
+//Size of a leaderboard when none is given, and the largest allowed
+const (
+	DefaultLeaderboardSize = 10
+	MaxLeaderboardSize     = 100
+)
+
+//AwardRecord information, one per credit award
+//TicketID:     awarded ticket
+//TicketType:   type of that ticket
+//UserID:       awarded participant
+//Value:        credit paid
+//Timestamp:    transaction timestamp of the award
+type AwardRecord struct {
+	TicketID   string    `json:"Award_TicketID"`
+	TicketType int       `json:"Award_TicketType"`
+	UserID     string    `json:"Award_UserID"`
+	Value      int       `json:"Award_Value"`
+	Timestamp  time.Time `json:"Award_Timestamp"`
+	TxID       string    `json:"Award_TxID"`
+}
+
+//LeaderboardRequest - input of Leaderboard
+type LeaderboardRequest struct {
+	Size       int    `json:"size"`
+	Scope      string `json:"scope"`
+	LoBID      *int   `json:"lobId"`
+	TicketType *int   `json:"ticketType"`
+	Window     string `json:"window"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+}
+
+//LeaderboardEntry - one ranked participant
+//participants with the same score share a rank, the next rank skips accordingly (1, 2, 2, 4)
+type LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserID   string `json:"userID"`
+	UserName string `json:"userName"`
+	LoBID    int    `json:"lobId"`
+	Score    int    `json:"score"`
+}
+
+//LeaderboardResponse - returned by Leaderboard
+type LeaderboardResponse struct {
+	Scope   string             `json:"scope"`
+	Window  string             `json:"window"`
+	From    *time.Time         `json:"from,omitempty"`
+	To      *time.Time         `json:"to,omitempty"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+//LoBLeaderboardEntry - one ranked LoB
+type LoBLeaderboardEntry struct {
+	Rank            int     `json:"rank"`
+	LoBID           int     `json:"lobId"`
+	Name            string  `json:"name"`
+	TotalCredit     int     `json:"totalCredit"`
+	Members         int     `json:"members"`
+	CreditPerMember float64 `json:"creditPerMember"`
+}
+
+//Helper: award records are keyed by time so a window is a single range scan
+//Award~<timestamp>~<ticketID>~<userID>
+func awardKey(stub shim.ChaincodeStubInterface, record AwardRecord) (string, error) {
+	return stub.CreateCompositeKey("Award", []string{fmt.Sprintf("%020d", record.Timestamp.UnixNano()), record.TicketID, record.UserID})
+}
+
+//Helper: record an award for the leaderboards
+func saveAwardRecord(stub shim.ChaincodeStubInterface, ticketID string, userID string, value int) error {
+	var ticket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return err
+	}
+	if ticketAsBytes != nil {
+		json.Unmarshal(ticketAsBytes, &ticket)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	record := AwardRecord{
+		TicketID:   ticketID,
+		TicketType: ticket.Type,
+		UserID:     userID,
+		Value:      value,
+		Timestamp:  txTime,
+		TxID:       stub.GetTxID()}
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("saveAwardRecord: Error marshalling award")
+	}
+	key, err := awardKey(stub, record)
+	if err != nil {
+		return wrapError("saveAwardRecord", err)
+	}
+	err = stub.PutState(key, recordAsBytes)
+	if err != nil {
+		return errors.New("saveAwardRecord: Error storing award of " + userID)
+	}
+	return nil
+}
+
+//Helper: awards in [from, to)
+//GetStateByRange rejects composite keys, so the awards are scanned and the window checked on the key
+func listAwards(stub shim.ChaincodeStubInterface, from time.Time, to time.Time) ([]AwardRecord, error) {
+	var records []AwardRecord
+	start, end := fmt.Sprintf("%020d", from.UnixNano()), fmt.Sprintf("%020d", to.UnixNano())
+	awardIterator, err := stub.GetStateByPartialCompositeKey("Award", []string{})
+	if err != nil {
+		return nil, wrapError("listAwards", err)
+	}
+	defer awardIterator.Close()
+
+	for awardIterator.HasNext() {
+		var record AwardRecord
+		queryResponse, err := awardIterator.Next()
+		if err != nil {
+			return nil, wrapError("listAwards", err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(attributes) == 0 {
+			return nil, errors.New("listAwards: Corrupt award key " + queryResponse.Key)
+		}
+		// timestamps have a fixed width, keys come in time order
+		if attributes[0] < start {
+			continue
+		}
+		if attributes[0] >= end {
+			break
+		}
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, errors.New("listAwards: Corrupt award record " + string(queryResponse.Value))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+//Helper: start and end of a leaderboard window, relative to the transaction time
+func leaderboardWindow(txTime time.Time, request LeaderboardRequest) (time.Time, time.Time, error) {
+	txTime = txTime.UTC()
+	switch request.Window {
+	case WindowMonth:
+		from := time.Date(txTime.Year(), txTime.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0), nil
+	case WindowQuarter:
+		firstMonth := time.Month((int(txTime.Month())-1)/3*3 + 1)
+		from := time.Date(txTime.Year(), firstMonth, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 3, 0), nil
+	case WindowCustom:
+		from, err := time.Parse(time.RFC3339, request.From)
+		if err != nil {
+			return from, from, errors.New("leaderboardWindow: from must be RFC3339")
+		}
+		to, err := time.Parse(time.RFC3339, request.To)
+		if err != nil {
+			return from, to, errors.New("leaderboardWindow: to must be RFC3339")
+		}
+		if !from.Before(to) {
+			return from, to, errors.New("leaderboardWindow: from must be before to")
+		}
+		return from, to, nil
+	}
+	// lifetime: everything up to the end of time representable by the key
+	return time.Unix(0, 0), time.Unix(0, 1<<62), nil
+}
+
+//Helper: read a participant, ok is false for unknown or offboarded IDs
+func lookupParticipant(stub shim.ChaincodeStubInterface, userID string) (Participant, bool, error) {
+	var participant Participant
+	participantAsBytes, err := getEntityState(stub, ObjectParticipant, userID)
+	if err != nil || participantAsBytes == nil {
+		return participant, false, err
+	}
+	err = json.Unmarshal(participantAsBytes, &participant)
+	if err != nil {
+		return participant, false, errors.New("lookupParticipant: Corrupt participant record " + string(participantAsBytes))
+	}
+	return participant, true, nil
+}
+
+//Helper: order by score, ties by UserID, and number the ranks
+//equal scores share a rank
+func rankEntries(entries []LeaderboardEntry, size int) []LeaderboardEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Score == entries[i-1].Score {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	if len(entries) > size {
+		entries = entries[:size]
+	}
+	return entries
+}
+
+//Helper: scores of participants by credit balance
+func balanceScores(stub shim.ChaincodeStubInterface, userIDs []string) (map[string]int, error) {
+	scores := map[string]int{}
+	for _, userID := range userIDs {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return nil, err
+		}
+		scores[userID] = credit.Value
+	}
+	return scores, nil
+}
+
+//Helper: scores of participants by awards in the window
+func awardScores(stub shim.ChaincodeStubInterface, from time.Time, to time.Time, ticketType *int) (map[string]int, error) {
+	scores := map[string]int{}
+	records, err := listAwards(stub, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if ticketType != nil && record.TicketType != *ticketType {
+			continue
+		}
+		scores[record.UserID] += record.Value
+	}
+	return scores, nil
+}
+
+func validateLeaderboard(request LeaderboardRequest) error {
+	var errs ValidationErrors
+	if request.Size != 0 {
+		errs.intRange("size", request.Size, 1, MaxLeaderboardSize)
+	}
+	if request.Scope != "" {
+		errs.oneOf("scope", request.Scope, ScopeGlobal, ScopeLoB, ScopeTicketType)
+	}
+	if request.Scope == ScopeLoB && request.LoBID == nil {
+		errs.add("lobId", "is required for scope "+ScopeLoB)
+	}
+	if request.Scope == ScopeTicketType && request.TicketType == nil {
+		errs.add("ticketType", "is required for scope "+ScopeTicketType)
+	}
+	if request.Window != "" {
+		errs.oneOf("window", request.Window, WindowAll, WindowMonth, WindowQuarter, WindowCustom)
+	}
+	if request.Window == WindowCustom {
+		errs.required("from", request.From)
+		errs.required("to", request.To)
+	}
+	errs.rfc3339("from", request.From)
+	errs.rfc3339("to", request.To)
+	from, fromErr := time.Parse(time.RFC3339, request.From)
+	to, toErr := time.Parse(time.RFC3339, request.To)
+	if fromErr == nil && toErr == nil && !from.Before(to) {
+		errs.add("to", "must be after from")
+	}
+	return errs.err()
+}
+
+//Query Route: Leaderboard
+//top N participants of a scope and time window
+func (rdg *SmartContract) Leaderboard(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request LeaderboardRequest
+	var readingIDs ReadingIDIndex
+	var scores map[string]int
+
+	if len(args) > 0 && args[0] != "" {
+		err := decodeAndValidate(args[0], &request, func() error {
+			return validateLeaderboard(request)
+		})
+		if err != nil {
+			return validationResponse("Leaderboard", err)
+		}
+	}
+	if request.Size == 0 {
+		request.Size = DefaultLeaderboardSize
+	}
+	if request.Scope == "" {
+		request.Scope = ScopeGlobal
+	}
+	if request.Window == "" {
+		request.Window = WindowAll
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	from, to, err := leaderboardWindow(txTime, request)
+	if err != nil {
+		return routeError(err)
+	}
+	response := LeaderboardResponse{Scope: request.Scope, Window: request.Window, Entries: []LeaderboardEntry{}}
+	if request.Window != WindowAll {
+		response.From = &from
+		response.To = &to
+	}
+
+	// ==== Global lifetime: read the top of the credit ranking only ====
+	if request.Scope == ScopeGlobal && request.Window == WindowAll {
+		credits, err := topCredits(stub, request.Size)
+		if err != nil {
+			return routeError(err)
+		}
+		var entries []LeaderboardEntry
+		for _, credit := range credits {
+			participant, _, err := lookupParticipant(stub, credit.UserID)
+			if err != nil {
+				return routeError(err)
+			}
+			entries = append(entries, LeaderboardEntry{
+				UserID:   credit.UserID,
+				UserName: participant.UserName,
+				LoBID:    participant.LoBID,
+				Score:    credit.Value})
+		}
+		response.Entries = append(response.Entries, rankEntries(entries, request.Size)...)
+		return successResponse("Leaderboard", response)
+	}
+
+	// ==== Score the candidates ====
+	var candidates []string
+	if request.Scope == ScopeLoB {
+		candidates, err = lobMembers(stub, *request.LoBID)
+		if err != nil {
+			return routeError(err)
+		}
+	} else {
+		bytes, err := stub.GetState("readingIDIndex")
+		if err != nil {
+			return shim.Error("Leaderboard: Error getting readingIDIndex array")
+		}
+		err = json.Unmarshal(bytes, &readingIDs)
+		if err != nil {
+			return shim.Error("Leaderboard: Error unmarshalling readingIDIndex array JSON")
+		}
+		candidates = readingIDs.UserIDs
+	}
+	if request.Window == WindowAll && request.Scope != ScopeTicketType {
+		scores, err = balanceScores(stub, candidates)
+	} else {
+		scores, err = awardScores(stub, from, to, request.TicketType)
+	}
+	if err != nil {
+		return routeError(err)
+	}
+
+	// ==== Rank the registered candidates ====
+	var entries []LeaderboardEntry
+	for _, userID := range candidates {
+		participant, ok, err := lookupParticipant(stub, userID)
+		if err != nil {
+			return routeError(err)
+		}
+		if !ok {
+			continue
+		}
+		entries = append(entries, LeaderboardEntry{
+			UserID:   userID,
+			UserName: participant.UserName,
+			LoBID:    participant.LoBID,
+			Score:    scores[userID]})
+	}
+	response.Entries = append(response.Entries, rankEntries(entries, request.Size)...)
+	return successResponse("Leaderboard", response)
+}
+
+//Query Route: LoBLeaderboard
+//active LoBs ranked by total credit or by credit per member
+//args: optional ranking, total (default) or perMember
+func (rdg *SmartContract) LoBLeaderboard(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	rankBy := RankByTotal
+	if len(args) > 0 && args[0] != "" {
+		rankBy = args[0]
+	}
+	if rankBy != RankByTotal && rankBy != RankByPerMember {
+		return validationResponse("LoBLeaderboard", ValidationErrors{{Field: "args[0]", Message: "must be one of " + RankByTotal + ", " + RankByPerMember}})
+	}
+
+	lobs, err := listLoBs(stub, false)
+	if err != nil {
+		return routeError(err)
+	}
+	entries := []LoBLeaderboardEntry{}
+	for _, LoB_temp := range lobs {
+		entry := LoBLeaderboardEntry{
+			LoBID:       LoB_temp.LoBID,
+			Name:        LoB_temp.Name,
+			TotalCredit: LoB_temp.TotalCredit,
+			Members:     len(LoB_temp.UserIDs)}
+		if entry.Members != 0 {
+			entry.CreditPerMember = float64(entry.TotalCredit) / float64(entry.Members)
+		}
+		entries = append(entries, entry)
+	}
+
+	score := func(entry LoBLeaderboardEntry) float64 {
+		if rankBy == RankByPerMember {
+			return entry.CreditPerMember
+		}
+		return float64(entry.TotalCredit)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if score(entries[i]) != score(entries[j]) {
+			return score(entries[i]) > score(entries[j])
+		}
+		return entries[i].LoBID < entries[j].LoBID
+	})
+	for i := range entries {
+		if i > 0 && score(entries[i]) == score(entries[i-1]) {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return successResponse("LoBLeaderboard", entries)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+//Helper: i000001 earns 10 in March 2024, i000002 20 for a P0 and i000003 20 for a P1 ticket in April
+//i000004 of IoT pays for all three and keeps 50
+func newLeaderboardStub(t *testing.T) *testStub {
+	t.Helper()
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.addUser("i000004", IoT)
+	stub.grant("i000004", 100)
+
+	stub.completeTicket(stub.createTicket("i000004", 10, nil), "i000001")
+	stub.advance(31 * 24 * time.Hour)
+	stub.completeTicket(stub.createTicket("i000004", 20, map[string]interface{}{"Ticket_Type": P0}), "i000002")
+	stub.completeTicket(stub.createTicket("i000004", 20, nil), "i000003")
+	return stub
+}
+
+//Helper: UserID and rank of each entry, as "i000001:1"
+func leaderboardRanks(response LeaderboardResponse) []string {
+	ranks := []string{}
+	for _, entry := range response.Entries {
+		ranks = append(ranks, entry.UserID+":"+strconv.Itoa(entry.Rank))
+	}
+	return ranks
+}
+
+func TestLeaderboard(t *testing.T) {
+	stub := newLeaderboardStub(t)
+	cases := []struct {
+		request string
+		ranks   []string
+	}{
+		{``, []string{"i000004:1", "i000002:2", "i000003:2", "i000001:4"}},
+		{`{"size":2}`, []string{"i000004:1", "i000002:2"}},
+		{`{"scope":"lob","lobId":1}`, []string{"i000002:1", "i000001:2"}},
+		{`{"scope":"ticketType","ticketType":0}`, []string{"i000002:1", "i000001:2", "i000003:2", "i000004:2"}},
+		{`{"window":"month"}`, []string{"i000002:1", "i000003:1", "i000001:3", "i000004:3"}},
+		{`{"window":"quarter","scope":"lob","lobId":2}`, []string{"i000003:1"}},
+		{`{"window":"custom","from":"2024-03-01T00:00:00Z","to":"2024-04-01T00:00:00Z","size":1}`, []string{"i000001:1"}},
+	}
+	for _, c := range cases {
+		var response LeaderboardResponse
+		stub.mustInvoke(&response, "Leaderboard", c.request)
+		if ranks := leaderboardRanks(response); !equalStrings(ranks, c.ranks) {
+			t.Errorf("%s: expected %v, got %v", c.request, c.ranks, ranks)
+		}
+	}
+
+	var month LeaderboardResponse
+	stub.mustInvoke(&month, "Leaderboard", `{"window":"month"}`)
+	if month.From == nil || month.From.Format("2006-01-02") != "2024-04-01" || month.To.Format("2006-01-02") != "2024-05-01" {
+		t.Errorf("unexpected window %v %v", month.From, month.To)
+	}
+	if entry := month.Entries[0]; entry.Score != 20 || entry.LoBID != HANA || entry.UserName != "User i000002" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+func TestLeaderboardRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.expectError(ErrCodeBadRequest, "Leaderboard", `{"size":101}`)
+	stub.expectError(ErrCodeBadRequest, "Leaderboard", `{"scope":"lob"}`)
+	stub.expectError(ErrCodeBadRequest, "Leaderboard", `{"window":"year"}`)
+	stub.expectError(ErrCodeBadRequest, "Leaderboard", `{"window":"custom","from":"2024-03-01T00:00:00Z"}`)
+	stub.expectError(ErrCodeBadRequest, "Leaderboard", `{"window":"custom","from":"2024-04-01T00:00:00Z","to":"2024-03-01T00:00:00Z"}`)
+	stub.expectError(ErrCodeNotFound, "Leaderboard", `{"scope":"lob","lobId":99}`)
+	stub.expectError(ErrCodeBadRequest, "LoBLeaderboard", "average")
+}
+
+func TestLoBLeaderboard(t *testing.T) {
+	stub := newLeaderboardStub(t)
+
+	var byTotal, perMember []LoBLeaderboardEntry
+	stub.mustInvoke(&byTotal, "LoBLeaderboard")
+	stub.mustInvoke(&perMember, "LoBLeaderboard", RankByPerMember)
+	if len(byTotal) != NumberOfLoBs || len(perMember) != NumberOfLoBs {
+		t.Fatalf("expected %d LoBs, got %+v %+v", NumberOfLoBs, byTotal, perMember)
+	}
+	if byTotal[0].LoBID != HANA || byTotal[0].TotalCredit != 30 || byTotal[0].Members != 2 || byTotal[1].LoBID != SMB {
+		t.Errorf("unexpected ranking by total %+v", byTotal[:2])
+	}
+	if perMember[0].LoBID != SMB || perMember[1].LoBID != HANA || perMember[1].CreditPerMember != 15 {
+		t.Errorf("unexpected ranking per member %+v", perMember[:2])
+	}
+	// LoBs without credit share the last rank
+	if last := byTotal[NumberOfLoBs-1]; last.Rank != 3 || last.TotalCredit != 0 {
+		t.Errorf("unexpected last entry %+v", last)
+	}
+}
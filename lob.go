@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Key holding the highest LoBID handed out, LoBs get stable IDs 0..LoBLastID
+//ledgers without it only know the default LoBs 0..NumberOfLoBs-1
+const lobLastIDKey = "LoBLastID"
+
+//Maximum length of a LoB name
+const MaxLoBNameLength = 64
+
+//LoBCreateRequest - input of LoBCreate
+type LoBCreateRequest struct {
+	Name string `json:"LoB_Name"`
+}
+
+func getLoBLastID(stub shim.ChaincodeStubInterface) (int, error) {
+	lastIDAsBytes, err := stub.GetState(lobLastIDKey)
+	if err != nil {
+		return 0, errors.New("getLoBLastID: Error getting last LoB ID")
+	}
+	if lastIDAsBytes == nil {
+		return NumberOfLoBs - 1, nil
+	}
+	return strconv.Atoi(string(lastIDAsBytes))
+}
+
+//Helper: read a LoB, default LoBs written before names were stored get their default name
+func retrieveLoB(stub shim.ChaincodeStubInterface, lobID int) (LoB, error) {
+	var LoB_temp LoB
+	bytes, err := getEntityState(stub, ObjectLoB, strconv.Itoa(lobID))
+	if err != nil {
+		return LoB_temp, errors.New("retrieveLoB: Error getting LoB info from state")
+	}
+	if bytes == nil {
+		return LoB_temp, notFoundError("retrieveLoB: LoB " + strconv.Itoa(lobID) + " does not exist")
+	}
+	err = json.Unmarshal(bytes, &LoB_temp)
+	if err != nil {
+		return LoB_temp, errors.New("retrieveLoB: Error unmarshalling LoB JSON")
+	}
+	LoB_temp.LoBID = lobID
+	if LoB_temp.Name == "" && lobID >= 0 && lobID < NumberOfLoBs {
+		LoB_temp.Name = Lob_Name[lobID]
+	}
+	return LoB_temp, nil
+}
+
+func saveLoB(stub shim.ChaincodeStubInterface, LoB_temp LoB) ([]byte, error) {
+	bytes, err := json.Marshal(LoB_temp)
+	if err != nil {
+		return nil, errors.New("saveLoB: Error marshalling LoB info")
+	}
+	err = putEntityState(stub, ObjectLoB, strconv.Itoa(LoB_temp.LoBID), bytes)
+	if err != nil {
+		return nil, errors.New("saveLoB: Error storing LoB info")
+	}
+	return bytes, nil
+}
+
+//Helper: every LoB, ordered by ID
+func listLoBs(stub shim.ChaincodeStubInterface, includeArchived bool) ([]LoB, error) {
+	lobs := []LoB{}
+	lastID, err := getLoBLastID(stub)
+	if err != nil {
+		return nil, err
+	}
+	for lobID := 0; lobID <= lastID; lobID++ {
+		LoB_temp, err := retrieveLoB(stub, lobID)
+		if err != nil {
+			return nil, err
+		}
+		if LoB_temp.Archived && !includeArchived {
+			continue
+		}
+		lobs = append(lobs, LoB_temp)
+	}
+	return lobs, nil
+}
+
+//Helper: check a LoB name is not used by another LoB, its format is checked by validateLoBName
+func checkLoBName(stub shim.ChaincodeStubInterface, name string, lobID int) error {
+	name = strings.TrimSpace(name)
+	lobs, err := listLoBs(stub, true)
+	if err != nil {
+		return err
+	}
+	for _, LoB_temp := range lobs {
+		if LoB_temp.LoBID != lobID && LoB_temp.Name == name {
+			return conflictError("checkLoBName: LoB name " + name + " is used by LoB " + strconv.Itoa(LoB_temp.LoBID))
+		}
+	}
+	return nil
+}
+
+//Helper: a LoB participants can be assigned to
+func retrieveActiveLoB(stub shim.ChaincodeStubInterface, lobID int) (LoB, error) {
+	LoB_temp, err := retrieveLoB(stub, lobID)
+	if err != nil {
+		return LoB_temp, err
+	}
+	if LoB_temp.Archived {
+		return LoB_temp, conflictError("retrieveActiveLoB: LoB " + LoB_temp.Name + " is archived")
+	}
+	return LoB_temp, nil
+}
+
+//Invoke Route: LoBCreate
+func (rdg *SmartContract) LoBCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request LoBCreateRequest
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateLoBName("LoB_Name", request.Name)
+	}, "LoB_Name")
+	if err != nil {
+		return validationResponse("LoBCreate", err)
+	}
+
+	lastID, err := getLoBLastID(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	newLoB := LoB{LoBID: lastID + 1, Name: strings.TrimSpace(request.Name)}
+	err = checkLoBName(stub, newLoB.Name, newLoB.LoBID)
+	if err != nil {
+		return routeError(err)
+	}
+
+	lobAsBytes, err := saveLoB(stub, newLoB)
+	if err != nil {
+		return routeError(err)
+	}
+	err = stub.PutState(lobLastIDKey, []byte(strconv.Itoa(newLoB.LoBID)))
+	if err != nil {
+		return shim.Error("LoBCreate: Error storing last LoB ID")
+	}
+	return shim.Success(lobAsBytes)
+}
+
+//Invoke Route: LoBRename
+//args: LoBID, new name
+func (rdg *SmartContract) LoBRename(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) < 2 {
+		return shim.Error("LoBRename: Incorrect number of arguments, expecting LoBID and name")
+	}
+	lobID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return shim.Error("LoBRename: Input LoBID is invalid")
+	}
+	err = validateLoBName("args[1]", args[1])
+	if err != nil {
+		return validationResponse("LoBRename", err)
+	}
+	LoB_temp, err := retrieveLoB(stub, lobID)
+	if err != nil {
+		return routeError(err)
+	}
+	name := strings.TrimSpace(args[1])
+	err = checkLoBName(stub, name, lobID)
+	if err != nil {
+		return routeError(err)
+	}
+
+	LoB_temp.Name = name
+	lobAsBytes, err := saveLoB(stub, LoB_temp)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(lobAsBytes)
+}
+
+//Invoke Route: LoBArchive
+//archived LoBs keep their members and credit but accept no new participants
+func (rdg *SmartContract) LoBArchive(stub shim.ChaincodeStubInterface, lobid string) peer.Response {
+	lobID, err := strconv.Atoi(lobid)
+	if err != nil {
+		return shim.Error("LoBArchive: Input LoBID is invalid")
+	}
+	LoB_temp, err := retrieveLoB(stub, lobID)
+	if err != nil {
+		return routeError(err)
+	}
+	if LoB_temp.Archived {
+		return errorResponse(ErrCodeConflict, "LoBArchive: LoB "+LoB_temp.Name+" is already archived")
+	}
+
+	LoB_temp.Archived = true
+	lobAsBytes, err := saveLoB(stub, LoB_temp)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(lobAsBytes)
+}
+
+//Query Route: LoBList
+//args: optional "true" to include archived LoBs
+func (rdg *SmartContract) LoBList(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	includeArchived := len(args) > 0 && args[0] == "true"
+	lobs, err := listLoBs(stub, includeArchived)
+	if err != nil {
+		return routeError(err)
+	}
+	// definitions only, members are read with LoBRead
+	for i := range lobs {
+		lobs[i].UserIDs = nil
+	}
+	lobsAsBytes, err := json.Marshal(lobs)
+	if err != nil {
+		return shim.Error("LoBList: Error marshalling LoBs")
+	}
+	return shim.Success(lobsAsBytes)
+}
+
+//LoBMove information, one record per move of a participant to another LoB
+//MoveID:          transaction ID of the move
+//UserID:          iXXXXXX
+//FromLoBID:       LoB the participant left
+//ToLoBID:         LoB the participant joined
+//Credit:          credit contribution moved between the LoB totals
+//EffectiveDate:   date the move takes effect, defaults to the transaction time
+//Timestamp:       transaction timestamp
+type LoBMove struct {
+	MoveID    string `json:"LoBMove_MoveID"`
+	UserID    string `json:"LoBMove_UserID"`
+	FromLoBID int    `json:"LoBMove_FromLoBID"`
+	ToLoBID   int    `json:"LoBMove_ToLoBID"`
+
+	Credit        int       `json:"LoBMove_Credit"`
+	EffectiveDate time.Time `json:"LoBMove_EffectiveDate"`
+	Timestamp     time.Time `json:"LoBMove_Timestamp"`
+}
+
+//LoBChangeRequest - input of ParticipantChangeLoB
+//EffectiveDate is RFC3339 and optional
+type LoBChangeRequest struct {
+	UserID        string `json:"userID"`
+	LoBID         int    `json:"lobId"`
+	EffectiveDate string `json:"effectiveDate"`
+}
+
+//Helper: move a participant and its credit contribution from one LoB to another and log the move
+//only the contribution counts, grants and escrows never reached the LoB totals,
+//credit awarded before contributions were recorded stays with the former LoB
+//the participant record itself is saved by the caller
+func moveParticipantLoB(stub shim.ChaincodeStubInterface, userID string, fromLoBID int, toLoBID int, effectiveDate time.Time) (LoBMove, error) {
+	var move LoBMove
+	if fromLoBID == toLoBID {
+		return move, conflictError("moveParticipantLoB: " + userID + " is already in LoB " + strconv.Itoa(toLoBID))
+	}
+	fromLoB, err := retrieveLoB(stub, fromLoBID)
+	if err != nil {
+		return move, err
+	}
+	toLoB, err := retrieveActiveLoB(stub, toLoBID)
+	if err != nil {
+		return move, err
+	}
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return move, err
+	}
+
+	// the old LoB may not list the user if it was moved by the former updateParticipant
+	fromLoB.UserIDs, _ = deleteKeyFromStringArray(fromLoB.UserIDs, userID)
+	fromLoB.TotalCredit -= credit.Contributed
+	if !Is_Inarray(toLoB.UserIDs, userID) {
+		toLoB.UserIDs = append(toLoB.UserIDs, userID)
+	}
+	toLoB.TotalCredit += credit.Contributed
+
+	_, err = saveLoB(stub, fromLoB)
+	if err != nil {
+		return move, err
+	}
+	_, err = saveLoB(stub, toLoB)
+	if err != nil {
+		return move, err
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return move, err
+	}
+	if effectiveDate.IsZero() {
+		effectiveDate = txTime
+	}
+	move = LoBMove{
+		MoveID:        stub.GetTxID(),
+		UserID:        userID,
+		FromLoBID:     fromLoBID,
+		ToLoBID:       toLoBID,
+		Credit:        credit.Contributed,
+		EffectiveDate: effectiveDate,
+		Timestamp:     txTime}
+	moveAsBytes, err := json.Marshal(move)
+	if err != nil {
+		return move, errors.New("moveParticipantLoB: Error marshalling LoB move")
+	}
+	key, err := stub.CreateCompositeKey("LoBMove", []string{userID, move.MoveID})
+	if err != nil {
+		return move, wrapError("moveParticipantLoB", err)
+	}
+	err = stub.PutState(key, moveAsBytes)
+	if err != nil {
+		return move, errors.New("moveParticipantLoB: Error storing LoB move of " + userID)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventParticipantLoBChanged, UserID: userID, Value: credit.Contributed})
+	if err != nil {
+		return move, err
+	}
+	return move, nil
+}
+
+//Invoke Route: ParticipantChangeLoB
+//moves a participant to another LoB, its contribution counts for the new LoB from then on
+func (rdg *SmartContract) ParticipantChangeLoB(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request LoBChangeRequest
+	var participant Participant
+	var effectiveDate time.Time
+
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateLoBChange(request)
+	}, "userID", "lobId")
+	if err != nil {
+		return validationResponse("ParticipantChangeLoB", err)
+	}
+	if request.EffectiveDate != "" {
+		effectiveDate, _ = time.Parse(time.RFC3339, request.EffectiveDate)
+	}
+
+	participantAsByteArray, err := rdg.retrieveParticipant(stub, request.UserID)
+	if err != nil {
+		return routeError(err)
+	}
+	err = json.Unmarshal(participantAsByteArray, &participant)
+	if err != nil {
+		return shim.Error("ParticipantChangeLoB: Error unmarshalling participant JSON")
+	}
+
+	move, err := moveParticipantLoB(stub, participant.UserID, participant.LoBID, request.LoBID, effectiveDate)
+	if err != nil {
+		return routeError(err)
+	}
+	participant.LoBID = request.LoBID
+	_, err = rdg.saveParticipant(stub, participant)
+	if err != nil {
+		return routeError(err)
+	}
+
+	moveAsBytes, err := json.Marshal(move)
+	if err != nil {
+		return shim.Error("ParticipantChangeLoB: Error marshalling LoB move")
+	}
+	return shim.Success(moveAsBytes)
+}
+
+//Query Route: ParticipantLoBMoves
+//lists the LoB moves of a participant
+func (rdg *SmartContract) ParticipantLoBMoves(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	moveIterator, err := stub.GetStateByPartialCompositeKey("LoBMove", []string{userID})
+	if err != nil {
+		return routeError(wrapError("ParticipantLoBMoves", err))
+	}
+	defer moveIterator.Close()
+
+	moves := []LoBMove{}
+	for moveIterator.HasNext() {
+		var move LoBMove
+		queryResponse, err := moveIterator.Next()
+		if err != nil {
+			return routeError(err)
+		}
+		err = json.Unmarshal(queryResponse.Value, &move)
+		if err != nil {
+			return shim.Error("ParticipantLoBMoves: Corrupt LoB move record " + queryResponse.Key)
+		}
+		moves = append(moves, move)
+	}
+	return successResponse("ParticipantLoBMoves", moves)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestInitSeedsLoBs(t *testing.T) {
+	stub := newTestStub(t)
+	var lobs []LoB
+	stub.mustInvoke(&lobs, "LoBList")
+	if len(lobs) != NumberOfLoBs {
+		t.Fatalf("expected %d LoBs, got %+v", NumberOfLoBs, lobs)
+	}
+	for lobID, lob := range lobs {
+		if lob.LoBID != lobID || lob.Name != Lob_Name[lobID] {
+			t.Errorf("LoB %d: unexpected %+v", lobID, lob)
+		}
+	}
+}
+
+func TestLoBRegistry(t *testing.T) {
+	stub := newTestStub(t)
+
+	var created LoB
+	stub.mustInvoke(&created, "LoBCreate", `{"LoB_Name":" Cloud "}`)
+	if created.LoBID != NumberOfLoBs || created.Name != "Cloud" {
+		t.Fatalf("unexpected LoB %+v", created)
+	}
+	stub.addUser("i000001", created.LoBID)
+
+	var renamed LoB
+	stub.mustInvoke(&renamed, "LoBRename", strconv.Itoa(created.LoBID), "Cloud Platform")
+	if renamed.Name != "Cloud Platform" || len(renamed.UserIDs) != 1 {
+		t.Errorf("unexpected LoB %+v", renamed)
+	}
+
+	stub.mustInvoke(nil, "LoBArchive", strconv.Itoa(created.LoBID))
+	var active, all []LoB
+	stub.mustInvoke(&active, "LoBList")
+	stub.mustInvoke(&all, "LoBList", "true")
+	if len(active) != NumberOfLoBs || len(all) != NumberOfLoBs+1 || !all[NumberOfLoBs].Archived {
+		t.Errorf("unexpected lists %+v %+v", active, all)
+	}
+	// archived LoBs keep their members
+	if lob := stub.lob(created.LoBID); len(lob.Members) != 1 || lob.Members[0].UserID != "i000001" {
+		t.Errorf("unexpected members %+v", lob.Members)
+	}
+}
+
+func TestLoBRegistryRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.mustInvoke(nil, "LoBCreate", `{"LoB_Name":"Cloud"}`)
+	stub.mustInvoke(nil, "LoBArchive", strconv.Itoa(NumberOfLoBs))
+
+	stub.expectError(ErrCodeConflict, "LoBCreate", `{"LoB_Name":"HANA"}`)
+	stub.expectError(ErrCodeConflict, "LoBRename", "0", "Cloud")
+	stub.expectError(ErrCodeConflict, "LoBArchive", strconv.Itoa(NumberOfLoBs))
+	stub.expectError(ErrCodeBadRequest, "LoBCreate", `{"LoB_Name":"  "}`)
+	stub.expectError(ErrCodeBadRequest, "LoBRename", "0", " ")
+	stub.expectError(ErrCodeNotFound, "LoBRename", "99", "Other")
+	stub.expectError(ErrCodeNotFound, "LoBArchive", "99")
+
+	// archived LoBs take no new participants
+	stub.expectError(ErrCodeConflict, "addParticipant", `{"Participant_UserID":"i000001","Participant_UserName":"x","Participant_IsAdmin":false,"Participant_LoBID":8}`)
+
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "LoBCreate", `{"LoB_Name":"Mine"}`)
+}
+
+func TestParticipantChangeLoB(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 30)
+	stub.completeTicket(stub.createTicket("i000001", 30, nil), "i000002")
+	hana, smb := stub.lob(HANA).TotalCredit, stub.lob(SMB).TotalCredit
+
+	stub.asAdmin()
+	var move LoBMove
+	stub.mustInvoke(&move, "ParticipantChangeLoB", `{"userID":"i000002","lobId":2,"effectiveDate":"2024-04-01T00:00:00Z"}`)
+	if move.FromLoBID != HANA || move.ToLoBID != SMB || move.Credit != 30 || move.MoveID != stub.LastTxID || move.EffectiveDate.Format("2006-01-02") != "2024-04-01" {
+		t.Errorf("unexpected move %+v", move)
+	}
+	if events := stub.events(); len(events) != 1 || events[0].Type != EventParticipantLoBChanged || events[0].Value != 30 {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	// the contribution moves with the participant, its credit stays
+	if total := stub.lob(HANA).TotalCredit; total != hana-30 {
+		t.Errorf("former LoB: expected %d, got %d", hana-30, total)
+	}
+	if total := stub.lob(SMB).TotalCredit; total != smb+30 {
+		t.Errorf("new LoB: expected %d, got %d", smb+30, total)
+	}
+	if value := stub.credit("i000002").Value; value != 30 {
+		t.Errorf("credit: expected 30, got %d", value)
+	}
+	var participant Participant
+	stub.mustInvoke(&participant, "readParticipant", "i000002")
+	if participant.LoBID != SMB {
+		t.Errorf("participant not moved %+v", participant)
+	}
+	for _, member := range stub.lob(HANA).Members {
+		if member.UserID == "i000002" {
+			t.Error("participant still listed in the former LoB")
+		}
+	}
+
+	stub.asUser("i000002")
+	var moves []LoBMove
+	stub.mustInvoke(&moves, "ParticipantLoBMoves", "i000002")
+	if len(moves) != 1 || moves[0].MoveID != move.MoveID {
+		t.Errorf("unexpected moves %+v", moves)
+	}
+}
+
+func TestParticipantChangeLoBRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.mustInvoke(nil, "LoBCreate", `{"LoB_Name":"Cloud"}`)
+	stub.mustInvoke(nil, "LoBArchive", strconv.Itoa(NumberOfLoBs))
+
+	stub.expectError(ErrCodeConflict, "ParticipantChangeLoB", `{"userID":"i000001","lobId":1}`)
+	stub.expectError(ErrCodeConflict, "ParticipantChangeLoB", `{"userID":"i000001","lobId":8}`)
+	stub.expectError(ErrCodeNotFound, "ParticipantChangeLoB", `{"userID":"i000001","lobId":99}`)
+	stub.expectError(ErrCodeNotFound, "ParticipantChangeLoB", `{"userID":"i000009","lobId":2}`)
+	stub.expectError(ErrCodeBadRequest, "ParticipantChangeLoB", `{"userID":"i000001","lobId":2,"effectiveDate":"April"}`)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "ParticipantChangeLoB", `{"userID":"i000001","lobId":2}`)
+	if lob := stub.lob(HANA); len(lob.Members) != 1 {
+		t.Errorf("rejected moves changed the LoB %+v", lob)
+	}
+}
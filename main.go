@@ -1,31 +1,32 @@
 package main
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	// "reflect"
 
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
 
 var logger = shim.NewLogger("ExchainChaincode")
 
-var TICKETID = 0
-
-// declare Lob_Name array to translate LoBID(int) into corresponding string name
+// default LoBs seeded by Init, further LoBs are created with LoBCreate
+// Lob_Name gives the default names, also the keys LoBs had before the namespaced key scheme
 const NumberOfLoBs = 8
 
 var Lob_Name = [NumberOfLoBs]string{"MD_office", "HANA", "SMB", "IBS", "S4_HANA", "GS", "SF", "IoT"}
 
-//Enum of LOBs
+//Enum of default LOBs
 const (
 	MD_office = iota
 	HANA
@@ -38,6 +39,7 @@ const (
 	//numberOfLoBs
 )
 
+//Default ticket types, further types are created with TicketTypeCreate, see tickettype.go
 const (
 	P0 = iota
 	P1
@@ -55,28 +57,34 @@ const (
 //Participant information
 //   UserID:        iXXXXXX
 //   UserName:      Bill Xu
-//   Password:      *********
 //   IsAdmin:       True or False
 //   LoB:           0. MD_office  1. HANA  2. SMB...
+//   MSPID:         MSP of the enrolled identity the participant is bound to
+//   EnrollmentID:  enrollment ID (certificate subject CN) of that identity, defaults to UserID
 type Participant struct {
 	UserID   string `json:"Participant_UserID"`
 	UserName string `json:"Participant_UserName"`
-	Password string `json:"Participant_Password"`
 
 	IsAdmin bool `json:"Participant_IsAdmin"`
 	LoBID   int  `json:"Participant_LoBID"`
+
+	MSPID        string `json:"Participant_MSPID"`
+	EnrollmentID string `json:"Participant_EnrollmentID"`
 }
 
 //Credit infomation
-//UserID:     iXXXXXX
-//Value:      123
-//TicketIDs:  1. TicketNumber
-//            2. Ticket array
+//UserID:       iXXXXXX
+//Value:        123
+//TicketIDs:    1. TicketNumber
+//              2. Ticket array
+//Contributed:  part of the TotalCredit of the participant's LoB, awards plus transfers received minus transfers sent
 type Credit struct {
 	UserID string `json:"Credit_UserID"`
 	Value  int    `json:"Credit_Value"`
 
 	TicketIDs []string `json:"Credit_TicketIDs"`
+
+	Contributed int `json:"Credit_Contributed"`
 }
 
 // add methods to compare struct Credit based on Credit.Value
@@ -92,13 +100,46 @@ func (s Credits) Less(i, j int) bool { // 重写 Less() 方法
 	return s[i].Value > s[j].Value
 }
 
+//LoB information
+//LoBID:         stable ID, never reused
+//Name:          unique display name
+//Archived:      archived LoBs accept no new participants
+//TotalCredit:   credit awarded to its members
+//UserIDs:       members
 type LoB struct {
-	LoBID       int `json:"LoB_LoBID"`
-	TotalCredit int `json:"LoB_TotalCredit"`
+	LoBID       int    `json:"LoB_LoBID"`
+	Name        string `json:"LoB_Name"`
+	Archived    bool   `json:"LoB_Archived"`
+	TotalCredit int    `json:"LoB_TotalCredit"`
 
 	UserIDs []string `json:"LoB_UserIDs"`
 }
 
+//LoBSummary - a LoB without its members, returned by LoBReadAll
+type LoBSummary struct {
+	LoBID       int    `json:"LoB_LoBID"`
+	Name        string `json:"LoB_Name"`
+	TotalCredit int    `json:"LoB_TotalCredit"`
+}
+
+//LoBDetail - a LoB with the credit of each member, returned by LoBRead
+type LoBDetail struct {
+	LoBID       int    `json:"LoB_LoBID"`
+	Name        string `json:"LoB_Name"`
+	Archived    bool   `json:"LoB_Archived"`
+	TotalCredit int    `json:"LoB_TotalCredit"`
+
+	Members []ParticipantCredit `json:"LoB_Members"`
+}
+
+//ParticipantCredit - a participant with its credit, returned by LoBRead and TopTenCredit
+type ParticipantCredit struct {
+	UserID   string `json:"participant_UserID"`
+	UserName string `json:"participant_UserName"`
+	Credit   int    `json:"participant_credit"`
+	LoBID    int    `json:"participant_LoB"`
+}
+
 // Ticket information
 //TicketID:
 //Status:
@@ -109,9 +150,15 @@ type LoB struct {
 //Value:
 //UserID:
 
-//DeadLine:
+//DeadLine:   optional, after it no applications are taken and TicketExpireOverdue expires the ticket
 //Comment:
-//Policy:
+//Policy:     JSON rules for applicants and payout, see TicketPolicy in policy.go
+
+//MaxAssignees:        confirmed assignees at most, 0 for no limit, further confirmations are waitlisted
+//ApplicationOpens:    optional, first time OrderCreate is accepted
+//ApplicationCloses:   optional, OrderCreate is refused from then on
+
+//CreatedAt:  transaction time of TicketCreate
 
 type Ticket struct {
 	TicketID string `json:"Ticket_TicketID"`
@@ -126,16 +173,25 @@ type Ticket struct {
 	DeadLine time.Time `json:"Ticket_Deadline"`
 	Comment  string    `json:"Ticket_Comment"`
 	Policy   string    `json:"Ticket_Policy"`
+
+	MaxAssignees      int       `json:"Ticket_MaxAssignees"`
+	ApplicationOpens  time.Time `json:"Ticket_ApplicationOpens"`
+	ApplicationCloses time.Time `json:"Ticket_ApplicationCloses"`
+
+	CreatedAt time.Time `json:"Ticket_CreatedAt"`
 }
 
 // Order information
 // TicketID:
 // UserID:           iXXXXXX
-// Status:           Created   ->  Applied  -> Ongoing  ->   Done
+// Status:           Created -> Applied -> Confirmed -> Done -> Awarded, see orderstate.go
+// WaitlistedAt:     when the order joined the waitlist of a full ticket, only set while Waitlisted
 type Order struct {
 	TicketID string `json:"TicketID"`
 	UserID   string `json:"UserID"`
 	Status   int    `json:"Status"`
+
+	WaitlistedAt *time.Time `json:"WaitlistedAt,omitempty"`
 }
 
 //SmartContract - Chaincode for asset Reading
@@ -159,10 +215,16 @@ func main() {
 //Init - The chaincode Init function: No arguments, only initializes a ID array as Index for retrieval of all Readings
 func (rdg *SmartContract) Init(stub shim.ChaincodeStubInterface) peer.Response {
 
-	//UseIDs, different LoB info and TICKETID are persistent
+	//UseIDs and different LoB info are persistent
+	//TICKETID is only kept as the last ID of tickets created before IDs were derived from transactions
 
 	var readingIDs ReadingIDIndex
 	bytes, _ := stub.GetState("readingIDIndex")
+	indexbytes, _ := stub.GetState("TICKETID")
+	if len(bytes) == 0 && len(indexbytes) == 0 {
+		// a new ledger starts with namespaced keys
+		stub.PutState(keySchemaVersionKey, []byte(strconv.Itoa(KeySchemaNamespaced)))
+	}
 	if len(bytes) == 0 {
 		bytes, _ := json.Marshal(readingIDs)
 		stub.PutState("readingIDIndex", bytes)
@@ -174,26 +236,18 @@ func (rdg *SmartContract) Init(stub shim.ChaincodeStubInterface) peer.Response {
 	var LobTemp LoB
 	iter := 0
 	for iter < NumberOfLoBs {
-		bytes, _ := stub.GetState(Lob_Name[iter])
+		bytes, _ := getEntityState(stub, ObjectLoB, strconv.Itoa(iter))
 		if len(bytes) == 0 {
 			LobTemp.LoBID = iter
+			LobTemp.Name = Lob_Name[iter]
 			LobTemp.TotalCredit = 0
 			bytes, _ := json.Marshal(LobTemp)
-			stub.PutState(Lob_Name[iter], bytes)
-		} else {
-			stub.PutState(Lob_Name[iter], bytes)
+			putEntityState(stub, ObjectLoB, strconv.Itoa(iter), bytes)
 		}
 		logger.Info("Func------Init----Get LoB info" + string(bytes))
 		iter = iter + 1
 	}
 
-	indexbytes, _ := stub.GetState("TICKETID")
-	if len(indexbytes) == 0 {
-		indexbytes, _ := json.Marshal(TICKETID)
-		stub.PutState("TICKETID", indexbytes)
-	} else {
-		stub.PutState("TICKETID", indexbytes)
-	}
 	logger.Info("Func------Init----Get TICKETID" + string(indexbytes))
 
 	return shim.Success(nil)
@@ -204,89 +258,30 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 	function, args := stub.GetFunctionAndParameters()
 	logger.Info(" ****** Invoke: function: ", function)
 
-	switch function {
-	//Participant Read Delete Update Add
-	case "addParticipant":
-		return rdg.addParticipant(stub, args)
-	case "readParticipant":
-		return rdg.readParticipant(stub, args[0])
-	case "readAllParticipant":
-		return rdg.readAllParticipant(stub)
-	case "updateParticipant":
-		return rdg.updateParticipant(stub, args)
-	case "deleteParticipant":
-		return rdg.deleteParticipant(stub, args[0])
-
-	//Credit Read Delete Update Add
-	case "CreditCreate":
-		return rdg.CreditCreate(stub, args)
-	case "CreditRead":
-		return rdg.CreditRead(stub, args[0])
-	case "CreditAdd":
-		return rdg.CreditAdd(stub, args)
-	case "CreditDelete":
-		return rdg.CreditDelete(stub, args[0])
-	case "TopTenCredit":
-		return rdg.TopTenCredit(stub)
-
-	// Lob Read
-	case "LoBReadAll":
-		return rdg.LoBReadAll(stub)
-	case "LoBRead":
-		return rdg.LoBRead(stub, args[0])
-
-	//Ticket Read Delete Update Add
-	case "TicketCreate":
-		return rdg.TicketCreate(stub, args)
-	case "TicketRead":
-		return rdg.TicketRead(stub, args[0])
-	case "TicketRead2":
-		return rdg.TicketRead2(stub)
-	case "TicketUpdate":
-		return rdg.TicketUpdate(stub, args)
-	case "AutoUpdateTicketStatus":
-		return rdg.AutoUpdateTicketStatus(stub, args[0])
-	case "TicketDelete":
-		return rdg.TicketDelete(stub, args[0])
-
-	//Order Read Delete Update Add
-	case "OrderCreate":
-		return rdg.OrderCreate(stub, args)
-	//Read Single  It must be changed
-	case "OrderRead":
-		return rdg.OrderRead(stub, args)
-	//Read All  It must be changed
-	case "OrderRead2":
-		return rdg.OrderRead2(stub, args)
-	case "OrderUpdate":
-		return rdg.OrderUpdate(stub, args)
-
-		//Get HistoryTicket
-	case "history":
-		return rdg.TestGetHistoryTicket(stub, args)
-	default:
-		logger.Error("Received unknown function invocation: ", function)
-	}
-	return shim.Error("Received unknown function invocation")
+	// ==== Check the arguments and the caller, then run the route, see dispatch.go ====
+	// the route gets a fresh context, the state of the transaction goes away with it
+	return rdg.dispatch(newTxContext(stub), function, args)
 }
 
 //getReadingFromArgs - construct a reading structure from string array of arguments
-func getParticipantFromArgs(args []string) (participant Participant, err error) {
+func getParticipantFromArgs(stub shim.ChaincodeStubInterface, args []string) (participant Participant, err error) {
 	//check inputs!
 	//  json:"Participant_UserID"
 	//  json:"Participant_UserName"
-	//  json:"Participant_Password"
 	//  json:"Participant_IsAdmin"
 	//  json:"Participant_LoB"
-	if strings.Contains(args[0], "\"Participant_UserName\"") == false ||
-		strings.Contains(args[0], "\"Participant_UserID\"") == false ||
-		strings.Contains(args[0], "\"Participant_Password\"") == false ||
-		strings.Contains(args[0], "\"Participant_IsAdmin\"") == false ||
-		strings.Contains(args[0], "\"Participant_LoBID\"") == false {
-		return participant, errors.New("Unknown field: Input JSON does not comply to schema")
+	//  json:"Participant_MSPID"          (optional)
+	//  json:"Participant_EnrollmentID"   (optional)
+	// passwords are never accepted as arguments, they would be written to the block
+	if strings.Contains(args[0], "\"Participant_Password\"") {
+		var errs ValidationErrors
+		errs.add("Participant_Password", "is not accepted, pass the password in the transient map under \""+TransientPassword+"\"")
+		return participant, errs
 	}
 
-	err = json.Unmarshal([]byte(args[0]), &participant)
+	err = decodeAndValidate(args[0], &participant, func() error {
+		return validateParticipant(stub, participant)
+	}, "Participant_UserID", "Participant_UserName", "Participant_IsAdmin", "Participant_LoBID")
 	if err != nil {
 		return participant, err
 	}
@@ -296,39 +291,76 @@ func getParticipantFromArgs(args []string) (participant Participant, err error)
 //Invoke Route: addNewReading
 func (rdg *SmartContract) addParticipant(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	//get Participant
-	participant, err := getParticipantFromArgs(args)
-	logger.Info("Func------addParticipant----Participant.LoBID" + string(participant.LoBID))
-
+	participant, err := getParticipantFromArgs(stub, args)
 	if err != nil {
-		return shim.Error("Reading participant is Corrupted")
+		return validationResponse("addParticipant", err)
 	}
+	logger.Info("Func------addParticipant----Participant.LoBID" + strconv.Itoa(participant.LoBID))
+
 	//check Participant exists or not
-	record, err := stub.GetState(participant.UserID)
+	record, err := getEntityState(stub, ObjectParticipant, participant.UserID)
+	if err != nil {
+		return routeError(err)
+	}
 	if record != nil {
-		return shim.Error("This participant already exists: " + participant.UserID)
+		return errorResponse(ErrCodeConflict, "This participant already exists: "+participant.UserID)
+	}
+	// archived IDs keep their credit history and are not handed out again
+	record, err = retrieveTombstone(stub, participant.UserID)
+	if err != nil {
+		return routeError(err)
+	}
+	if record != nil {
+		return errorResponse(ErrCodeConflict, "This participant has been archived: "+participant.UserID)
+	}
+
+	// bind the participant to its enrolled identity
+	if participant.EnrollmentID == "" {
+		participant.EnrollmentID = participant.UserID
+	}
+	if participant.MSPID == "" {
+		participant.MSPID, err = cid.GetMSPID(stub)
+		if err != nil {
+			return shim.Error("addParticipant: Error getting MSP ID of creator: " + err.Error())
+		}
+	}
+	err = saveIdentityIndex(stub, participant)
+	if err != nil {
+		return routeError(err)
 	}
 
 	//if not exists, save
 	participantAsBytes, err := rdg.saveParticipant(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
+	}
+
+	// optional password, only its salted hash is stored
+	err = setCredentialFromTransient(stub, participant.UserID)
+	if err != nil {
+		return routeError(err)
 	}
 
 	err = CreditInit(stub, participant.UserID, 0)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 
 	// updata LoB UserIDs array
 	_, err = rdg.updateLoBUsers(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 
 	// update the ID index of
 	_, err = rdg.updateReadingIDIndex(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventParticipantAdded, UserID: participant.UserID})
+	if err != nil {
+		return routeError(err)
 	}
 	return shim.Success(participantAsBytes)
 }
@@ -339,7 +371,7 @@ func (rdg *SmartContract) saveParticipant(stub shim.ChaincodeStubInterface, part
 	if err != nil {
 		return bytes, errors.New("Error converting reading record JSON")
 	}
-	err = stub.PutState(participant.UserID, bytes)
+	err = putEntityState(stub, ObjectParticipant, participant.UserID, bytes)
 	if err != nil {
 		return bytes, errors.New("Error storing Reading record")
 	}
@@ -349,29 +381,15 @@ func (rdg *SmartContract) saveParticipant(stub shim.ChaincodeStubInterface, part
 //Helper: add user to its LoB's UserIDs array
 func (rdg *SmartContract) updateLoBUsers(stub shim.ChaincodeStubInterface, participant Participant) (bool, error) {
 
-	var LoB_temp LoB
-
-	LobName := Lob_Name[participant.LoBID]
-	bytes, err := stub.GetState(LobName)
-	if err != nil {
-		return false, errors.New("updateLoBUsers: Error getting LoB info from state")
-	}
-
-	err = json.Unmarshal(bytes, &LoB_temp)
+	LoB_temp, err := retrieveActiveLoB(stub, participant.LoBID)
 	if err != nil {
-		return false, errors.New("updateLoBUsers: Error unmarshalling LoB JSON")
+		return false, wrapError("updateLoBUsers", err)
 	}
 
 	// To do: participant credit
-	LoB_temp.LoBID = participant.LoBID
 	LoB_temp.UserIDs = append(LoB_temp.UserIDs, participant.UserID)
 
-	bytes, err = json.Marshal(LoB_temp)
-	if err != nil {
-		return false, errors.New("updateLoBUsers: Error marshalling new LoB info")
-	}
-
-	err = stub.PutState(LobName, bytes)
+	_, err = saveLoB(stub, LoB_temp)
 	if err != nil {
 		return false, errors.New("updateLoBUsers: Error storing new LoB info")
 	}
@@ -411,7 +429,7 @@ func (rdg *SmartContract) updateReadingIDIndex(stub shim.ChaincodeStubInterface,
 func (rdg *SmartContract) readParticipant(stub shim.ChaincodeStubInterface, participantID string) peer.Response {
 	participantAsByteArray, err := rdg.retrieveParticipant(stub, participantID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 	return shim.Success(participantAsByteArray)
 }
@@ -421,11 +439,14 @@ func (rdg *SmartContract) readParticipant(stub shim.ChaincodeStubInterface, part
 func (rdg *SmartContract) retrieveParticipant(stub shim.ChaincodeStubInterface, participantID string) ([]byte, error) {
 	var participant Participant
 	var participantAsByteArray []byte
-	bytes, err := stub.GetState(participantID)
+	bytes, err := getEntityState(stub, ObjectParticipant, participantID)
 
 	if err != nil {
 		return participantAsByteArray, errors.New("retrieveParticipant: Error retrieving participant with ID: " + participantID)
 	}
+	if bytes == nil {
+		return participantAsByteArray, notFoundError("retrieveParticipant: The participant does not exist: " + participantID)
+	}
 	err = json.Unmarshal(bytes, &participant)
 	if err != nil {
 		return participantAsByteArray, errors.New("retrieveParticipant: Corrupt reading record " + string(bytes))
@@ -449,40 +470,35 @@ func (rdg *SmartContract) readAllParticipant(stub shim.ChaincodeStubInterface) p
 	if err != nil {
 		return shim.Error("readAllReadings: Error unmarshalling readingIDIndex array JSON")
 	}
-	result := "["
-
-	var readingAsByteArray []byte
+	participants := []Participant{}
 
 	for _, participantID := range readingIDs.UserIDs {
-		readingAsByteArray, err = rdg.retrieveParticipant(stub, participantID)
+		var participant Participant
+		readingAsByteArray, err := rdg.retrieveParticipant(stub, participantID)
 		if err != nil {
 			return shim.Error("Failed to retrieve participant with ID: " + participantID)
 		}
-		result += string(readingAsByteArray) + ","
-	}
-	if len(result) == 1 {
-		result = "[]"
-	} else {
-		result = result[:len(result)-1] + "]"
+		err = json.Unmarshal(readingAsByteArray, &participant)
+		if err != nil {
+			return shim.Error("readAllReadings: Error unmarshalling participant JSON")
+		}
+		participants = append(participants, participant)
 	}
-	return shim.Success([]byte(result))
+	return successResponse("readAllParticipant", participants)
 }
 
-//Helper: Reading readingStruct //change template
+//Invoke Route: deleteParticipant
+//purges the participant with all dependent records, its open tickets are cancelled
 func (rdg *SmartContract) deleteParticipant(stub shim.ChaincodeStubInterface, participantID string) peer.Response {
-	_, err := rdg.retrieveParticipant(stub, participantID)
+	report, err := rdg.offboardParticipant(stub, OffboardRequest{UserID: participantID, Mode: OffboardPurge})
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(wrapError("deleteParticipant", err))
 	}
-	err = stub.DelState(participantID)
+	reportAsBytes, err := json.Marshal(report)
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("deleteParticipant: Error marshalling report")
 	}
-	_, err = rdg.deleteReadingIDIndex(stub, participantID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	return shim.Success(nil)
+	return shim.Success(reportAsBytes)
 }
 
 //Helper: delete ID from readingStruct Holder
@@ -528,10 +544,13 @@ func deleteKeyFromStringArray(array []string, key string) (newArray []string, er
 func (rdg *SmartContract) updateParticipant(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	var currParticipant Participant
-	newParticipant, err := getParticipantFromArgs(args)
+	newParticipant, err := getParticipantFromArgs(stub, args)
+	if err != nil {
+		return validationResponse("updateParticipant", err)
+	}
 	participantAsByteArray, err := rdg.retrieveParticipant(stub, newParticipant.UserID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 
 	err = json.Unmarshal(participantAsByteArray, &currParticipant)
@@ -539,30 +558,71 @@ func (rdg *SmartContract) updateParticipant(stub shim.ChaincodeStubInterface, ar
 		return shim.Error("updateReading: Error unmarshalling readingStruct array JSON")
 	}
 
+	// keep the identity binding unless a new one is given
+	if newParticipant.MSPID == "" {
+		newParticipant.MSPID = currParticipant.MSPID
+	}
+	if newParticipant.EnrollmentID == "" {
+		newParticipant.EnrollmentID = currParticipant.EnrollmentID
+	}
+	if newParticipant.MSPID != currParticipant.MSPID || newParticipant.EnrollmentID != currParticipant.EnrollmentID {
+		err = deleteIdentityIndex(stub, currParticipant)
+		if err != nil {
+			return routeError(err)
+		}
+		err = saveIdentityIndex(stub, newParticipant)
+		if err != nil {
+			return routeError(err)
+		}
+	}
+
+	// a new LoBID is a move, membership and credit follow the participant
+	if newParticipant.LoBID != currParticipant.LoBID {
+		_, err = moveParticipantLoB(stub, newParticipant.UserID, currParticipant.LoBID, newParticipant.LoBID, time.Time{})
+		if err != nil {
+			return routeError(err)
+		}
+	}
+
 	_, err = rdg.saveParticipant(stub, newParticipant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
+	}
+
+	err = setCredentialFromTransient(stub, newParticipant.UserID)
+	if err != nil {
+		return routeError(err)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventParticipantUpdated, UserID: newParticipant.UserID})
+	if err != nil {
+		return routeError(err)
 	}
 	return shim.Success(nil)
 }
 
 func (rdg *SmartContract) CreditCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	// ==== Check whether the participant already exsites. ====
-	// todo
-	// checke wether credit already exsites.
-	record, err := stub.GetState("Credit_UerID_" + args[0])
+	userID := args[0]
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		var errs ValidationErrors
+		errs.add("args[1]", "must be "+argTypeName[ArgInt])
+		return validationResponse("CreditCreate", errs)
+	}
 
+	// ==== Check whether the credit already exists ====
+	record, err := getEntityState(stub, ObjectCredit, userID)
+	if err != nil {
+		return routeError(wrapError("CreditCreate", err))
+	}
 	if record != nil {
-		return shim.Error("This Credit" + args[0] + " credit has already existed.")
+		return errorResponse(ErrCodeConflict, "This Credit"+userID+" credit has already existed.")
 	}
 
-	userID := args[0]
-	value, err := strconv.Atoi(args[1])
-
 	err = CreditInit(stub, userID, value)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 
 	return shim.Success(nil)
@@ -574,74 +634,70 @@ func CreditInit(stub shim.ChaincodeStubInterface, userID string, value int) erro
 	// ==== Create Credit object and Credit to JSON ====
 	credit = Credit{UserID: userID, Value: value}
 
-	creditAsByteArray, err := json.Marshal(credit)
-	if err != nil {
-		return errors.New(err.Error())
-	}
-
 	// ==== Save Credit to state ====
-	err = stub.PutState("Credit_UerID_"+userID, creditAsByteArray)
+	_, err := saveCredit(stub, credit)
 	if err != nil {
 		return errors.New(err.Error())
 	}
 
-	return nil
+	// ==== A starting value is a grant of the admin creating the credit ====
+	return journalCredit(stub, credit, JournalEntry{Amount: value, Reason: ReasonGrant, IssuedBy: callerEnrollmentID(stub)})
 }
 
 func (rdg *SmartContract) CreditRead(stub shim.ChaincodeStubInterface, UserID string) peer.Response {
 	//to do
-	creditAsByteArray, err := retrieveSingleCreditAsByteArray(stub, "Credit_UerID_"+UserID)
+	creditAsByteArray, err := retrieveSingleCreditAsByteArray(stub, UserID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 	return shim.Success(creditAsByteArray)
 }
 
-func retrieveSingleCredit(stub shim.ChaincodeStubInterface, creditID string) (Credit, error) {
+func retrieveSingleCredit(stub shim.ChaincodeStubInterface, userID string) (Credit, error) {
 	var credit Credit
 	var creditAsByteArray []byte
 	var err error
 
-	creditAsByteArray, err = stub.GetState(creditID)
+	creditAsByteArray, err = getEntityState(stub, ObjectCredit, userID)
 
 	if err != nil {
-		return credit, errors.New("CreditRead: Error credit read participant with ID: " + creditID)
+		return credit, errors.New("CreditRead: Error credit read participant with ID: " + userID)
 	}
 	// else if creditAsBytes == nil {
 	//  logger.Error("CreditRead:  Corrupt reading record ", err.Error())
-	//  return nil, errors.New("CreditRead: Credit does not exist " + creditID)
+	//  return nil, errors.New("CreditRead: Credit does not exist " + userID)
 	// }
 
 	// For log printing credit Information & check whether the credit does exist
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return credit, errors.New("CreditRead: Credit does not exist " + string(creditAsByteArray))
+		return credit, notFoundError("CreditRead: Credit does not exist " + string(creditAsByteArray))
 	}
 	// For log printing credit Information
 
 	return credit, nil
 }
 
-func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, creditID string) ([]byte, error) {
+func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, userID string) ([]byte, error) {
 	var credit Credit
 	var creditAsByteArray []byte
 	var err error
 
-	logger.Info("-----retrieveSingleCreditAsByteArray :creditID---------", creditID)
-	creditAsByteArray, err = stub.GetState(creditID)
+	logger.Info("-----retrieveSingleCreditAsByteArray :userID---------", userID)
+	creditAsByteArray, err = getEntityState(stub, ObjectCredit, userID)
 
 	if err != nil {
-		return nil, errors.New("CreditRead: Error credit read participant with ID: " + creditID)
+		return nil, errors.New("CreditRead: Error credit read participant with ID: " + userID)
 	}
 	// else if creditAsBytes == nil {
 	//  logger.Error("CreditRead:  Corrupt reading record ", err.Error())
-	//  return nil, errors.New("CreditRead: Credit does not exist " + creditID)
+	//  return nil, errors.New("CreditRead: Credit does not exist " + userID)
 	// }
 
 	// For log printing credit Information & check whether the credit does exist
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return nil, errors.New("CreditRead: Credit does not exist " + string(creditAsByteArray))
+		return nil, notFoundError("CreditRead: Credit does not exist " + string(creditAsByteArray))
 	}
 	// For log printing credit Information
 
@@ -650,57 +706,101 @@ func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, creditID
 	return creditAsByteArray, nil
 }
 
+//Helper: store a credit record and keep the credit ranking in line
+func saveCredit(stub shim.ChaincodeStubInterface, credit Credit) ([]byte, error) {
+	creditAsByteArray, err := json.Marshal(credit)
+	if err != nil {
+		return nil, errors.New("saveCredit: Error marshalling credit " + credit.UserID)
+	}
+	err = updateCreditRank(stub, credit)
+	if err != nil {
+		return nil, err
+	}
+	err = putEntityState(stub, ObjectCredit, credit.UserID, creditAsByteArray)
+	if err != nil {
+		return nil, errors.New("saveCredit: Error storing credit " + credit.UserID)
+	}
+	return creditAsByteArray, nil
+}
+
+//CreditAddRequest - input of CreditAdd
+//TicketID is optional, the former "creditADD" is still read as no ticket
+//Reason is grant (default) or correction, a ticket can only be granted once
+type CreditAddRequest struct {
+	UserID   string `json:"userID"`
+	Value    int    `json:"value"`
+	TicketID string `json:"ticketID"`
+	Reason   string `json:"reason"`
+}
+
 func (rdg *SmartContract) CreditAdd(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	var credit Credit
-	var raw map[string]interface{}
+	var request CreditAddRequest
 
-	err := json.Unmarshal([]byte(args[0]), &raw)
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateCreditAdd(request)
+	}, "userID", "value")
 	if err != nil {
-		return shim.Error(err.Error())
+		return validationResponse("CreditAdd", err)
 	}
 
 	// ==== Assign value to variable ====
-	userID := raw["userID"].(string)
+	userID := request.UserID
 	logger.Info("*****CreditUpdate*******", userID)
 
-	value := int(raw["value"].(float64))
+	value := request.Value
 	logger.Info("*****CreditUpdate*******", value)
 
-	ticketID := raw["ticketID"].(string)
+	ticketID := request.TicketID
+	if ticketID == creditAddNoTicket {
+		ticketID = ""
+	}
 	logger.Info("*****CreditUpdate*******", ticketID)
 
+	reason := request.Reason
+	if reason == "" {
+		reason = ReasonGrant
+	}
+
 	// === Check whether the credit already exist. ====
-	creditAsByteArray, err := stub.GetState("Credit_UerID_" + userID)
+	creditAsByteArray, err := getEntityState(stub, ObjectCredit, userID)
 	if err != nil {
 		return shim.Error("CreditUpdate: Failed to get credit :" + err.Error())
 	} else if creditAsByteArray == nil {
-		errs := fmt.Sprintf("CreditUpdate: Credit_UerID_%s does not exist.", userID)
+		errs := fmt.Sprintf("CreditUpdate: Credit of %s does not exist.", userID)
 		logger.Info(" ****** " + errs)
-		return shim.Error(errs)
+		return errorResponse(ErrCodeNotFound, errs)
 	}
 
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 
-	// === if ticket is a constan string which only represent add constant credit ===
-	if ticketID != "creditADD" {
+	// === a ticket is granted once and listed in TicketIDs, grants without a ticket and corrections are only journaled ===
+	if reason == ReasonGrant && ticketID != "" {
 		// === check whether the ticket has been add ===
 		if ok := Is_Inarray(credit.TicketIDs, ticketID); ok {
-			return shim.Error("CreditUpdate: This ticket has been existed.")
+			return errorResponse(ErrCodeConflict, "CreditUpdate: This ticket has been existed.")
 		}
+		credit.TicketIDs = append(credit.TicketIDs, ticketID)
 	}
 
 	credit.Value += value
-	credit.TicketIDs = append(credit.TicketIDs, ticketID)
 
-	creditAsByteArray, err = json.Marshal(credit)
+	creditAsByteArray, err = saveCredit(stub, credit)
+	if err != nil {
+		return routeError(wrapError("CreditUpdate", err))
+	}
+	err = journalCredit(stub, credit, JournalEntry{Amount: value, Reason: reason, TicketID: ticketID, IssuedBy: callerEnrollmentID(stub)})
 	if err != nil {
-		return shim.Error("CreditUpdate: " + err.Error())
+		return routeError(err)
 	}
 
-	err = stub.PutState("Credit_UerID_"+credit.UserID, creditAsByteArray)
+	err = emitEvent(stub, ChaincodeEvent{Type: EventCreditAdded, UserID: userID, TicketID: ticketID, Value: value})
+	if err != nil {
+		return routeError(err)
+	}
 	return shim.Success(creditAsByteArray)
 }
 
@@ -715,76 +815,52 @@ func Is_Inarray(target []string, now string) bool {
 
 func (rdg *SmartContract) CreditDelete(stub shim.ChaincodeStubInterface, userID string) peer.Response {
 	logger.Info(" ****** CreditDelete start ****** userID:" + userID)
-	err := stub.DelState("Credit_UerID_" + userID)
+	err := deleteCredit(stub, userID)
 	if err != nil {
 		return shim.Error("CreditDelete: Failed to delete Credit state: " + err.Error())
 	}
 
 	//Log process for debug
-	credit, err := stub.GetState("Credit_UerID_" + userID)
+	credit, err := getEntityState(stub, ObjectCredit, userID)
 	logger.Info(" ****** CreditDelete ****** " + string(credit))
 
 	return shim.Success(nil)
 }
 
 func (rdg *SmartContract) LoBReadAll(stub shim.ChaincodeStubInterface) peer.Response {
-	var LoB_temp LoB
-	var result string
-
-	result += "["
-
-	iter := 0
-	for iter < NumberOfLoBs {
-		bytes, err := stub.GetState(Lob_Name[iter])
-		if err != nil {
-			return shim.Error("LoBReadAll: Error getting LoB info from state")
-		}
-
-		err = json.Unmarshal(bytes, &LoB_temp)
-		if err != nil {
-			return shim.Error("LoBReadAll: Error unmarshalling LoB JSON")
-		}
-
-		LobAssest := LoB{LoBID: LoB_temp.LoBID, TotalCredit: LoB_temp.TotalCredit}
-		LobAssestAsByteArray, err := json.Marshal(LobAssest)
-		if err != nil {
-			return shim.Error("LoBReadAll: Fail to Marshall LobAssest")
-		}
-		result += string(LobAssestAsByteArray) + ","
-		iter = iter + 1
+	lobs, err := listLoBs(stub, false)
+	if err != nil {
+		return routeError(wrapError("LoBReadAll", err))
+	}
+	LobAssests := []LoBSummary{}
+	for _, LoB_temp := range lobs {
+		LobAssests = append(LobAssests, LoBSummary{LoBID: LoB_temp.LoBID, Name: LoB_temp.Name, TotalCredit: LoB_temp.TotalCredit})
 	}
 
-	result = result[:len(result)-1] + "]"
-
-	return shim.Success([]byte(result))
+	return successResponse("LoBReadAll", LobAssests)
 }
 
 func (rdg *SmartContract) LoBRead(stub shim.ChaincodeStubInterface, LoBid string) peer.Response {
-	var LoB_temp LoB
 	var participant_temp Participant
 	var participantAsByteArray []byte
 	var credit_temp Credit
-	var result string
 
-	LoBID, _ := strconv.Atoi(LoBid)
-	if LoBID < 0 || LoBID >= NumberOfLoBs {
-		return shim.Error("Input LoBID is invalid, LoBID")
-	}
-
-	LobName := Lob_Name[LoBID]
-	bytes, err := stub.GetState(LobName)
+	LoBID, err := strconv.Atoi(LoBid)
 	if err != nil {
-		return shim.Error("LoBRead: Error getting LoB info from state")
+		return shim.Error("Input LoBID is invalid, LoBID")
 	}
 
-	err = json.Unmarshal(bytes, &LoB_temp)
+	LoB_temp, err := retrieveLoB(stub, LoBID)
 	if err != nil {
-		return shim.Error("LoBRead: Error unmarshalling LoB JSON")
+		return routeError(wrapError("LoBRead", err))
 	}
 
-	result += "["
-	credit := strconv.Itoa(LoB_temp.TotalCredit)
-	result += "TotalCredit: " + credit + ","
+	result := LoBDetail{
+		LoBID:       LoB_temp.LoBID,
+		Name:        LoB_temp.Name,
+		Archived:    LoB_temp.Archived,
+		TotalCredit: LoB_temp.TotalCredit,
+		Members:     []ParticipantCredit{}}
 
 	for _, participantID := range LoB_temp.UserIDs {
 		participantAsByteArray, err = rdg.retrieveParticipant(stub, participantID)
@@ -797,66 +873,44 @@ func (rdg *SmartContract) LoBRead(stub shim.ChaincodeStubInterface, LoBid string
 			return shim.Error("LoBRead: Error unmarshalling Participant JSON")
 		}
 
-		credit_temp, _ = retrieveSingleCredit(stub, "Credit_UerID_"+participant_temp.UserID)
+		credit_temp, _ = retrieveSingleCredit(stub, participant_temp.UserID)
 
-		Participant_UserID := "{\"participant_UserID\": " + participant_temp.UserID + ","
-		Participant_UserName := "\"participant_UserName\": " + participant_temp.UserName + ","
-		Participant_credit := "\"participant_credit\": " + strconv.Itoa(credit_temp.Value) + ","
-		Participant_LoB := "\"participant_LoB\": " + strconv.Itoa(participant_temp.LoBID) + "},"
-		result += Participant_UserID + Participant_UserName + Participant_credit + Participant_LoB
+		result.Members = append(result.Members, ParticipantCredit{
+			UserID:   participant_temp.UserID,
+			UserName: participant_temp.UserName,
+			Credit:   credit_temp.Value,
+			LoBID:    participant_temp.LoBID})
 	}
-	if len(result) == 1 {
-		result = "[]"
-	} else {
-		result = result[:len(result)-1] + "\n]"
-	}
-	return shim.Success([]byte(result))
+	return successResponse("LoBRead", result)
 }
 
+//Deprecated: use Leaderboard
+//TopTenCredit returns the ten participants with the highest credit balance
 func (rdg *SmartContract) TopTenCredit(stub shim.ChaincodeStubInterface) peer.Response {
-	var readingIDs ReadingIDIndex
 	var participant_temp Participant
-	var credits Credits
-	var credit_temp Credit
-	var participantAsBytes []byte
-	var err error
-	var result string
 
-	result += "["
+	result := []ParticipantCredit{}
 
-	bytes, _ := stub.GetState("readingIDIndex")
-	err = json.Unmarshal(bytes, &readingIDs)
+	// ==== Read the ten highest entries of the credit ranking ====
+	credits, err := topCredits(stub, 10)
 	if err != nil {
-		return shim.Error("TopTenCredit: Error unmarshalling readingIDIndex array JSON")
-	}
-
-	for _, participantID := range readingIDs.UserIDs {
-		credit_temp, _ = retrieveSingleCredit(stub, "Credit_UerID_"+participantID)
-		credits = append(credits, credit_temp)
+		return routeError(wrapError("TopTenCredit", err))
 	}
 
-	sort.Sort(credits)
-
-	for i := 0; i < Min(10, len(credits)); i++ {
-		participantAsBytes, _ = rdg.retrieveParticipant(stub, credits[i].UserID)
+	for _, credit := range credits {
+		participantAsBytes, _ := rdg.retrieveParticipant(stub, credit.UserID)
 		err = json.Unmarshal(participantAsBytes, &participant_temp)
 		if err != nil {
 			return shim.Error("TopTenParticipant: Error unmarshalling Participant JSON")
 		}
 
-		Participant_UserID := "{\"participant_UserID\": \"" + participant_temp.UserID + "\","
-		Participant_UserName := "\"participant_UserName\": \"" + participant_temp.UserName + "\","
-		Participant_credit := "\"participant_credit\": " + strconv.Itoa(credits[i].Value) + ","
-		Participant_LoB := "\"participant_LoB\": " + strconv.Itoa(participant_temp.LoBID) + "},"
-		result += Participant_UserID + Participant_UserName + Participant_credit + Participant_LoB
-	}
-
-	if len(result) == 1 {
-		result = "[]"
-	} else {
-		result = result[:len(result)-1] + "\n]"
+		result = append(result, ParticipantCredit{
+			UserID:   participant_temp.UserID,
+			UserName: participant_temp.UserName,
+			Credit:   credit.Value,
+			LoBID:    participant_temp.LoBID})
 	}
-	return shim.Success([]byte(result))
+	return successResponse("TopTenCredit", result)
 }
 
 // go lib doesn't have Min/Max(int, int) funct
@@ -867,35 +921,97 @@ func Min(x, y int) int {
 	return y
 }
 
-func getTicketFromArgs(args string) (ticket Ticket, err error) {
-	if strings.Contains(args, "\"Ticket_Title\"") == false ||
-		strings.Contains(args, "\"Ticket_Value\"") == false ||
-		strings.Contains(args, "\"Ticket_UserID\"") == false ||
-		strings.Contains(args, "\"Ticket_Type\"") == false {
-		return ticket, errors.New("Unknown field: Input JSON does not comly to schema")
+//getTicketFromArgs - decode and validate a ticket, updates must name the ticket
+//new tickets may leave out the value, they get the default value of their type
+//updates may leave out the creator, it is kept anyway
+func getTicketFromArgs(args string, update bool) (ticket Ticket, err error) {
+	required := []string{"Ticket_Title", "Ticket_Type"}
+	if update {
+		required = append(required, "Ticket_TicketID", "Ticket_Value")
+	} else {
+		required = append(required, "Ticket_UserID")
 	}
-
-	err = json.Unmarshal([]byte(args), &ticket)
+	err = decodeAndValidate(args, &ticket, func() error {
+		return validateTicket(ticket, update)
+	}, required...)
 	if err != nil {
 		return ticket, err
 	}
+	ticket.DeadLine = normalizeDeadline(ticket.DeadLine)
+
+	return ticket, nil
+}
 
+func retrieveTicket(stub shim.ChaincodeStubInterface, ticketID string) (Ticket, error) {
+	var ticket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return ticket, wrapError("retrieveTicket", err)
+	}
+	if ticketAsBytes == nil {
+		return ticket, notFoundError("retrieveTicket: The ticket " + ticketID + " does not exist")
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return ticket, errors.New("retrieveTicket: Corrupt ticket record " + string(ticketAsBytes))
+	}
 	return ticket, nil
 }
 
 func saveTicket(stub shim.ChaincodeStubInterface, ticket Ticket) ([]byte, error) {
 	var ticketAsBytes []byte
+	ticket.DeadLine = normalizeDeadline(ticket.DeadLine)
 	ticketAsBytes, err := json.Marshal(ticket)
 	if err != nil {
-		return ticketAsBytes, errors.New("saveTicket: " + err.Error())
+		return ticketAsBytes, wrapError("saveTicket", err)
 	}
-	err = stub.PutState(ticket.TicketID, ticketAsBytes)
+	err = putEntityState(stub, ObjectTicket, ticket.TicketID, ticketAsBytes)
 	if err != nil {
 		return ticketAsBytes, err
 	}
 	return ticketAsBytes, nil
 }
 
+//Helper: ticket ID derived from the transaction ID
+//every endorser derives the same ID and no shared counter is read or written
+func newTicketID(stub shim.ChaincodeStubInterface) string {
+	sum := sha256.Sum256([]byte(stub.GetTxID()))
+	return hex.EncodeToString(sum[:8])
+}
+
+//Helper: ordered index of tickets, TicketIndex~<creation time>~<ticketID>
+func ticketIndexKey(stub shim.ChaincodeStubInterface, ticket Ticket) (string, error) {
+	createdAt := fmt.Sprintf("%020d", ticket.CreatedAt.UnixNano())
+	return stub.CreateCompositeKey("TicketIndex", []string{createdAt, ticket.TicketID})
+}
+
+func saveTicketIndex(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	key, err := ticketIndexKey(stub, ticket)
+	if err != nil {
+		return wrapError("saveTicketIndex", err)
+	}
+	err = stub.PutState(key, []byte(ticket.TicketID))
+	if err != nil {
+		return errors.New("saveTicketIndex: Error storing ticket index")
+	}
+	return nil
+}
+
+func deleteTicketIndex(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	if ticket.CreatedAt.IsZero() {
+		return nil
+	}
+	key, err := ticketIndexKey(stub, ticket)
+	if err != nil {
+		return wrapError("deleteTicketIndex", err)
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errors.New("deleteTicketIndex: Error deleting ticket index")
+	}
+	return nil
+}
+
 func (sc *SmartContract) TicketCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	// ==== Get ticket from args ====
 	// todo
@@ -907,51 +1023,116 @@ func (sc *SmartContract) TicketCreate(stub shim.ChaincodeStubInterface, args []s
 	// 	UserID: "1",
 	// 	DeadLine: time.Now()}
 
-	ticket, err := getTicketFromArgs(args[0])
+	ticket, err := getTicketFromArgs(args[0], false)
+	if err != nil {
+		return validationResponse("TicketCreate", err)
+	}
 
-	TICKETIDAsBytes, _ := stub.GetState("TICKETID")
-	TICKETID, _ = strconv.Atoi(string(TICKETIDAsBytes))
-	TICKETID++
-	ticket.TicketID = strconv.Itoa(TICKETID)
+	// ==== Derive the ticket ID from the transaction ====
+	ticket.TicketID = newTicketID(stub)
 	ticket.Status = 1
+	ticket.CreatedAt, err = getTxTime(stub)
 	if err != nil {
-		return shim.Error("TicketCreate: " + err.Error())
+		return routeError(wrapError("TicketCreate", err))
+	}
+	err = applyTicketType(stub, &ticket, nil, hasField(args[0], "Ticket_Value"))
+	if err != nil {
+		return validationResponse("TicketCreate", err)
+	}
+	err = checkDeadline(stub, ticket, time.Time{})
+	if err != nil {
+		return validationResponse("TicketCreate", err)
+	}
+	err = checkTicketPolicy(stub, ticket)
+	if err != nil {
+		return validationResponse("TicketCreate", err)
 	}
 
 	// ==== Judge if the ticket already exists ====
-	// ticketAsBytes, err := stub.GetState(ticket.TicketID)
-	// if ticketAsBytes != nil {
-	// 	return shim.Error("TicketCreate: The ticket already exists." + string(ticketAsBytes))
-	// }
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticket.TicketID)
+	if err != nil {
+		return routeError(wrapError("TicketCreate", err))
+	}
+	if ticketAsBytes != nil {
+		return errorResponse(ErrCodeConflict, "TicketCreate: The ticket already exists."+ticket.TicketID)
+	}
 	// todo
 	// check if userid is valid
 
 	// ==== Put the ticket into ledger ====
-	ticketAsBytes, err := saveTicket(stub, ticket)
+	ticketAsBytes, err = saveTicket(stub, ticket)
+	if err != nil {
+		return routeError(err)
+	}
+	err = saveTicketIndex(stub, ticket)
+	if err != nil {
+		return routeError(err)
+	}
+
+	// ==== Reserve the reward from the creator's credit ====
+	err = lockEscrow(stub, ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(wrapError("TicketCreate", err))
 	}
 
-	TICKETIDAsBytes, _ = json.Marshal(TICKETID)
-	stub.PutState("TICKETID", TICKETIDAsBytes)
+	err = emitEvent(stub, ChaincodeEvent{
+		Type:      EventTicketCreated,
+		TicketID:  ticket.TicketID,
+		UserID:    ticket.UserID,
+		NewStatus: eventStatus(ticket.Status),
+		Value:     ticket.Value})
+	if err != nil {
+		return routeError(err)
+	}
 	return shim.Success(ticketAsBytes)
 }
 
 func (sc *SmartContract) TicketDelete(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
 	// ==== Judge if the ticket already exists ====
-	var ticket Ticket
-	ticketAsBytes, err := stub.GetState(ticketID)
+	ticket, err := retrieveTicket(stub, ticketID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(wrapError("TicketDelete", err))
 	}
+	logger.Info(" ****** TicketDelete:", ticket)
 
-	err = json.Unmarshal(ticketAsBytes, &ticket)
+	// ==== Give the reserved reward back to the creator, tickets from before escrows have none ====
+	escrowAsBytes, err := stub.GetState(escrowKey(ticketID))
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("TicketDelete: Error getting escrow of ticket " + ticketID)
+	}
+	if escrowAsBytes != nil {
+		escrow, err := retrieveEscrow(stub, ticketID)
+		if err != nil {
+			return routeError(wrapError("TicketDelete", err))
+		}
+		if escrow.Status == EscrowLocked {
+			err = refundEscrow(stub, &escrow, EscrowRefunded)
+			if err != nil {
+				return routeError(wrapError("TicketDelete", err))
+			}
+		}
+	}
+
+	// ==== Orders and their approvals go with the ticket ====
+	orders, err := listOrders(stub, []string{ticketID})
+	if err != nil {
+		return routeError(wrapError("TicketDelete", err))
+	}
+	for _, order := range orders {
+		err = deleteOrder(stub, order)
+		if err != nil {
+			return routeError(wrapError("TicketDelete", err))
+		}
 	}
-	logger.Info(" ****** TicketDelete:", ticket)
 
-	err = stub.DelState(ticketID)
+	err = delEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return routeError(err)
+	}
+	err = deleteTicketIndex(stub, ticket)
+	if err != nil {
+		return routeError(err)
+	}
 	return shim.Success(nil)
 }
 
@@ -967,24 +1148,78 @@ func (sc *SmartContract) TicketUpdate(stub shim.ChaincodeStubInterface, args []s
 	// 	DeadLine: time.Now()}
 
 	// participantID := args[0]
-	ticket, err := getTicketFromArgs(args[0])
+	ticket, err := getTicketFromArgs(args[0], true)
 	if err != nil {
-		return shim.Error("TicketUpdate: " + err.Error())
+		return validationResponse("TicketUpdate", err)
 	}
 	// ==== Judge if the ticket already exists ====
-	ticketAsBytes, err := stub.GetState(ticket.TicketID)
+	var oldTicket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticket.TicketID)
 	if ticketAsBytes == nil {
-		return shim.Error("TicketCreate: The ticket does not exist.")
+		return errorResponse(ErrCodeNotFound, "TicketCreate: The ticket does not exist.")
+	}
+	err = json.Unmarshal(ticketAsBytes, &oldTicket)
+	if err != nil {
+		return shim.Error("TicketUpdate: Corrupt ticket record " + string(ticketAsBytes))
+	}
+	// the creation time is set by TicketCreate and keys the ticket index
+	ticket.CreatedAt = oldTicket.CreatedAt
+
+	// ==== The owner edits the content, the status follows the orders and the owner is fixed ====
+	// ParticipantOffboard hands tickets over, the escrow and the access checks follow the stored owner
+	if ticket.UserID != "" && ticket.UserID != oldTicket.UserID {
+		var errs ValidationErrors
+		errs.add("Ticket_UserID", "cannot be changed")
+		return validationResponse("TicketUpdate", errs)
+	}
+	ticket.UserID = oldTicket.UserID
+	ticket.Status = oldTicket.Status
+
+	// ==== Expired tickets are final, a changed deadline has to lie ahead ====
+	if oldTicket.Status == OrderExpired {
+		return errorResponse(ErrCodeConflict, "TicketUpdate: The ticket has expired.")
+	}
+	err = applyTicketType(stub, &ticket, &oldTicket, true)
+	if err != nil {
+		return validationResponse("TicketUpdate", err)
+	}
+	err = checkDeadline(stub, ticket, oldTicket.DeadLine)
+	if err != nil {
+		return validationResponse("TicketUpdate", err)
+	}
+	// free text policies of older tickets stay readable until the policy is changed
+	if ticket.Policy != oldTicket.Policy {
+		err = checkTicketPolicy(stub, ticket)
+		if err != nil {
+			return validationResponse("TicketUpdate", err)
+		}
 	}
 
 	// if participantID != ticket.UserID {
 	// 	return shim.Error("TicketUpdate: You have no rights to update the ticket")
 	// }
 
+	// ==== Keep the reserved reward in line with the value ====
+	err = adjustEscrow(stub, ticket)
+	if err != nil {
+		return routeError(wrapError("TicketUpdate", err))
+	}
+
 	// ==== Update the ledger ====
 	ticketAsBytes, err = saveTicket(stub, ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{
+		Type:      EventTicketUpdated,
+		TicketID:  ticket.TicketID,
+		UserID:    ticket.UserID,
+		OldStatus: eventStatus(oldTicket.Status),
+		NewStatus: eventStatus(ticket.Status),
+		Value:     ticket.Value})
+	if err != nil {
+		return routeError(err)
 	}
 	return shim.Success(ticketAsBytes)
 }
@@ -992,68 +1227,69 @@ func (sc *SmartContract) TicketUpdate(stub shim.ChaincodeStubInterface, args []s
 func (sc *SmartContract) TicketRead(stub shim.ChaincodeStubInterface, args string) peer.Response {
 	// ==== Read ticket from ledger ====
 	var ticket Ticket
-	ticketAsBytes, err := stub.GetState(args)
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, args)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
+	}
+	if ticketAsBytes == nil {
+		return errorResponse(ErrCodeNotFound, "TicketRead: The ticket does not exist: "+args)
 	}
 
 	err = json.Unmarshal(ticketAsBytes, &ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
 	logger.Info(" ****** TicketRead:", ticket)
 	return shim.Success(ticketAsBytes)
 }
 
+//Deprecated: use TicketList
+//TicketRead2 returns the tickets numbered before IDs were derived from transactions, then the indexed ones
 func (sc *SmartContract) TicketRead2(stub shim.ChaincodeStubInterface) peer.Response {
 	TICKETIDAsBytes, _ := stub.GetState("TICKETID")
-	TICKETID, _ = strconv.Atoi(string(TICKETIDAsBytes))
+	lastTicketID, _ := strconv.Atoi(string(TICKETIDAsBytes))
 
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	bArrayMemberAlreadyWritten := false
+	tickets := []Ticket{}
 
 	i := 1
-	//var ticket Ticket
-
-	for i <= TICKETID {
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		//TicketArray = append(TicketArray, strconv.Itoa(i))
-		//TicketIDs = strconv.Itoa(i)
-		ticketAsBytes, _ := stub.GetState(strconv.Itoa(i))
-		//item, _ := json.Marshal(sc.TicketRead(stub, strconv.Itoa(i)))
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(ticketAsBytes))
-		bArrayMemberAlreadyWritten = true
+	for i <= lastTicketID {
+		var ticket Ticket
+		ticketAsBytes, _ := getEntityState(stub, ObjectTicket, strconv.Itoa(i))
 		i = i + 1
+		// deleted tickets leave gaps
+		if ticketAsBytes == nil {
+			continue
+		}
+		err := json.Unmarshal(ticketAsBytes, &ticket)
+		if err != nil {
+			return shim.Error("TicketRead2: Corrupt ticket record " + string(ticketAsBytes))
+		}
+		tickets = append(tickets, ticket)
 	}
-	buffer.WriteString("]")
-	return shim.Success(buffer.Bytes())
-}
 
-func (sc *SmartContract) TestGetHistoryTicket(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	ticketInterator, err := stub.GetHistoryForKey("xxx1")
+	indexIterator, err := stub.GetStateByPartialCompositeKey("TicketIndex", []string{})
 	if err != nil {
-		return shim.Error(err.Error())
+		return routeError(err)
 	}
+	defer indexIterator.Close()
 
-	// defer ticketInterator.Close()
-
-	for ticketInterator.HasNext() {
-		queryResponse, err := ticketInterator.Next()
+	for indexIterator.HasNext() {
+		queryResponse, err := indexIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return routeError(err)
 		}
-
-		logger.Info("------Test----" + queryResponse.String())
-		item, _ := json.Marshal(queryResponse)
-		logger.Info("------Test 1----" + string(item))
-
+		var ticket Ticket
+		ticketAsBytes, _ := getEntityState(stub, ObjectTicket, string(queryResponse.Value))
+		if ticketAsBytes == nil {
+			continue
+		}
+		err = json.Unmarshal(ticketAsBytes, &ticket)
+		if err != nil {
+			return shim.Error("TicketRead2: Corrupt ticket record " + string(ticketAsBytes))
+		}
+		tickets = append(tickets, ticket)
 	}
-
-	return shim.Success(nil)
+	return successResponse("TicketRead2", tickets)
 }
 
 func (sc *SmartContract) OrderCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
@@ -1061,30 +1297,65 @@ func (sc *SmartContract) OrderCreate(stub shim.ChaincodeStubInterface, args []st
 	//
 
 	var order Order
-	if strings.Contains(args[0], "\"TicketID\"") == false ||
-		strings.Contains(args[0], "\"UserID\"") == false {
-		return shim.Error("OrderCreate:Unknown field: Input JSON does not comly to schema")
-	}
-
-	err := json.Unmarshal([]byte(args[0]), &order)
+	err := decodeAndValidate(args[0], &order, func() error {
+		return validateOrder(order)
+	}, "TicketID", "UserID")
 	if err != nil {
-		return shim.Error("OrderCreate:")
+		return validationResponse("OrderCreate", err)
 	}
 	ticketID := order.TicketID
 	userID := order.UserID
 
-	key, _ := stub.CreateCompositeKey("Order", []string{ticketID, userID})
-	logger.Info("------OrderCreate:" + key)
+	logger.Info("------OrderCreate:", ticketID, userID)
+
+	// ==== Only open tickets take applications ====
+	var ticket Ticket
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+	if err != nil {
+		return routeError(wrapError("OrderCreate", err))
+	}
+	if ticketAsBytes == nil {
+		return errorResponse(ErrCodeNotFound, "OrderCreate: The ticket does not exist.")
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return shim.Error("OrderCreate: Corrupt ticket record " + string(ticketAsBytes))
+	}
+	err = checkTicketOpenForOrders(stub, ticket)
+	if err != nil {
+		return routeError(wrapError("OrderCreate", err))
+	}
+	err = checkEligibility(stub, ticket, userID)
+	if err != nil {
+		return routeError(wrapError("OrderCreate", err))
+	}
 
 	// ==== check whether the order already exsit ====
-	orderAsByte, _ := stub.GetState(key)
-	if orderAsByte != nil {
-		return shim.Error("OrderCreate: You have applied this Ticket")
+	current, exists, err := retrieveOrder(stub, ticketID, userID)
+	if err != nil {
+		return routeError(wrapError("OrderCreate", err))
+	}
+	if exists {
+		err = checkOrderTransition(current.Status, OrderApplied, RoleAssignee)
+		if err != nil {
+			return errorResponse(ErrCodeConflict, "OrderCreate: You have applied this Ticket")
+		}
 	}
 
-	order.Status = 1
-	orderAsByte, _ = json.Marshal(order)
-	stub.PutState(key, orderAsByte)
+	order.Status = OrderApplied
+	orderAsByte, err := OrderSaving(stub, order)
+	if err != nil {
+		return routeError(wrapError("OrderCreate", err))
+	}
+
+	event := ChaincodeEvent{Type: EventOrderCreated, TicketID: ticketID, UserID: userID, NewStatus: eventStatus(OrderApplied)}
+	if exists {
+		event.OldStatus = eventStatus(current.Status)
+	}
+	err = emitEvent(stub, event)
+	if err != nil {
+		return routeError(err)
+	}
 
 	return shim.Success(orderAsByte)
 }
@@ -1109,37 +1380,32 @@ func (sc *SmartContract) OrderRead(stub shim.ChaincodeStubInterface, args []stri
 func (sc *SmartContract) OrderRead2(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	ticketID := args[0]
 
-	orderInterator, _ :=
+	orderInterator, err :=
 		stub.GetStateByPartialCompositeKey("Order", []string{ticketID})
+	if err != nil {
+		return routeError(wrapError("OrderRead2", err))
+	}
+	defer orderInterator.Close()
 
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	bArrayMemberAlreadyWritten := false
+	orders := []Order{}
 
 	logger.Info("OrderRead2 Interator start:")
 	for orderInterator.HasNext() {
+		var order Order
 		queryResponse, err := orderInterator.Next()
 		logger.Info("OrderRead2 Interator :", queryResponse)
 		if err != nil {
-			return shim.Error(err.Error())
+			return routeError(err)
 		}
 
-		logger.Info("------Test----" + queryResponse.String())
-		item, _ := json.Marshal(queryResponse)
-		logger.Info("------Test x----" + string(item))
-
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return shim.Error("OrderRead2: Corrupt order record " + string(queryResponse.Value))
 		}
-
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		bArrayMemberAlreadyWritten = true
-
+		orders = append(orders, order)
 	}
-	buffer.WriteString("]")
 
-	return shim.Success(buffer.Bytes())
+	return successResponse("OrderRead2", orders)
 }
 
 func OrderSaving(stub shim.ChaincodeStubInterface, order Order) ([]byte, error) {
@@ -1158,73 +1424,102 @@ func OrderSaving(stub shim.ChaincodeStubInterface, order Order) ([]byte, error)
 	return bytes, nil
 }
 
-func OrderBlukUpdate(stub shim.ChaincodeStubInterface, ticketID interface{}, userID_array []interface{}, status int) (bool, error) {
+//Helper: pay awarded users out of the ticket escrow
+//userID_array holds the users whose orders this transaction moved to Awarded,
+//the split rule of the ticket policy decides what each gets, see split.go
+//capacity follows the assignees through the transaction, once all of them are awarded the rest of the escrow is released
+func award(stub shim.ChaincodeStubInterface, ticket Ticket, capacity *ticketCapacity, userID_array []string) (bool, error) {
+	ticketID := ticket.TicketID
+
+	// a user is paid at most once per call, whatever it is passed
+	var unique []string
 	for _, userID := range userID_array {
-		order := Order{
-			TicketID: ticketID.(string),
-			UserID:   userID.(string),
-			Status:   status}
-		if status != 0 {
-			key, _ := stub.CreateCompositeKey("Order", []string{ticketID.(string), userID.(string)})
-			orderAsByte, _ := stub.GetState(key)
-
-			var order Order
-			_ = json.Unmarshal(orderAsByte, &order)
-
-			if order.Status == status-1 {
-				order.Status = status
-				OrderSaving(stub, order)
-			}
-		} else {
-			order.Status = status
-			OrderSaving(stub, order)
+		if !Is_Inarray(unique, userID) {
+			unique = append(unique, userID)
 		}
 	}
-	return true, nil
-}
-
-func orderStatueEqual(stub shim.ChaincodeStubInterface, ticketID string, userID string, status int) bool {
-	key, _ := stub.CreateCompositeKey("Order", []string{ticketID, userID})
-	orderAsByte, _ := stub.GetState(key)
+	userID_array = unique
 
-	var order Order
-	_ = json.Unmarshal(orderAsByte, &order)
-
-	if order.Status == status {
-		return true
-	} else {
-		return false
+	// nothing to pay and assignees still open, the escrow stays as it is
+	if len(userID_array) == 0 && !capacity.settled() {
+		return true, nil
 	}
-}
+	escrow, err := awardEscrow(stub, ticket)
+	if err != nil {
+		return false, err
+	}
+	payouts := planPayouts(ticketPolicy(ticket), escrow, capacity.holders(), userID_array)
 
-func award(stub shim.ChaincodeStubInterface, ticketID string, userID_array []interface{}, value int) (bool, error) {
 	for _, userID := range userID_array {
-		logger.Info("-----xxx---------", "Credit_UerID_"+userID.(string))
-		credit, _ := retrieveSingleCredit(stub, "Credit_UerID_"+userID.(string))
-		// if order is done and ticketID not in credit.TicketIDs
-		if orderStatueEqual(stub, ticketID, userID.(string), 4) &&
-			!Is_Inarray(credit.TicketIDs, ticketID) {
-			credit.Value += value
-			credit.TicketIDs = append(credit.TicketIDs, ticketID)
-			logger.Info("-----xxx---------", credit)
-			creditAsByteArray, _ := json.Marshal(credit)
-			stub.PutState("Credit_UerID_"+credit.UserID, creditAsByteArray)
-
-			// update user's LoB total credit
-			_, err := updateLoBCredit(stub, userID.(string), value)
+		logger.Info("-----xxx---------", userID)
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return false, err
+		}
+		// a ticket is paid once to each user
+		if Is_Inarray(credit.TicketIDs, ticketID) {
+			continue
+		}
+		// shares planned before more assignees joined may exceed what is left, a closed escrow has nothing left
+		value := Min(payouts[userID], escrow.Remaining)
+		if value > 0 {
+			err = payFromEscrow(&escrow, userID, value)
 			if err != nil {
 				return false, err
 			}
 		}
+		credit.Value += value
+		credit.Contributed += value
+		credit.TicketIDs = append(credit.TicketIDs, ticketID)
+		logger.Info("-----xxx---------", credit)
+		_, err = saveCredit(stub, credit)
+		if err != nil {
+			return false, err
+		}
+		if value == 0 {
+			continue
+		}
+		err = journalCredit(stub, credit, JournalEntry{Amount: value, Reason: ReasonAward, TicketID: ticketID})
+		if err != nil {
+			return false, err
+		}
+
+		// update user's LoB total credit
+		_, err = updateLoBCredit(stub, userID, value)
+		if err != nil {
+			return false, err
+		}
+
+		err = saveAwardRecord(stub, ticketID, userID, value)
+		if err != nil {
+			return false, err
+		}
+
+		err = emitEvent(stub, ChaincodeEvent{Type: EventCreditAwarded, TicketID: ticketID, UserID: userID, Value: value})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// every assignee is awarded, what the split left goes back to the creator
+	if escrow.Status != EscrowLocked {
+		return true, nil
+	}
+	if capacity.settled() {
+		err = refundEscrow(stub, &escrow, EscrowReleased)
+	} else {
+		_, err = saveEscrow(stub, escrow)
+	}
+	if err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
 func updateLoBCredit(stub shim.ChaincodeStubInterface, userID string, value int) (bool, error) {
 	var participant Participant
-	var LoB_temp LoB
 
-	bytes, err := stub.GetState(userID)
+	bytes, err := getEntityState(stub, ObjectParticipant, userID)
 	if err != nil {
 		return false, errors.New("updateLoBCredit: Error get participant with ID: " + userID)
 	}
@@ -1233,167 +1528,196 @@ func updateLoBCredit(stub shim.ChaincodeStubInterface, userID string, value int)
 		return false, errors.New("updateLoBCredit: Corrupt reading record " + string(bytes))
 	}
 
-	LobName := Lob_Name[participant.LoBID]
-	bytes, err = stub.GetState(LobName)
+	// archived LoBs still collect the credit of their members
+	LoB_temp, err := retrieveLoB(stub, participant.LoBID)
 	if err != nil {
-		return false, errors.New("updateLoBCredit: Error getting LoB info from state")
-	}
-
-	err = json.Unmarshal(bytes, &LoB_temp)
-	if err != nil {
-		return false, errors.New("updateLoBCredit: Error unmarshalling LoB JSON")
+		return false, wrapError("updateLoBCredit", err)
 	}
 	LoB_temp.TotalCredit += value
-	bytes, err = json.Marshal(LoB_temp)
-	if err != nil {
-		return false, errors.New("updateLoBCredit: Error marshalling new LoB info")
-	}
 
-	err = stub.PutState(LobName, bytes)
+	_, err = saveLoB(stub, LoB_temp)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+//OrderUpdateRequest - input of OrderUpdate, every list holds the UserIDs to move
+type OrderUpdateRequest struct {
+	TicketID string   `json:"TicketID"`
+	Close    []string `json:"Close"`
+	Reject   []string `json:"Reject"`
+	Confirm  []string `json:"Confirm"`
+	Done     []string `json:"Done"`
+	Award    []string `json:"Award"`
+}
+
+//OrderUpdateResult - returned by OrderUpdate
+type OrderUpdateResult struct {
+	TicketID string                  `json:"TicketID"`
+	Results  []OrderTransitionResult `json:"Results"`
+}
+
 func (sc *SmartContract) OrderUpdate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var raw map[string]interface{}
+	var request OrderUpdateRequest
+	var ticket Ticket
 
-	err := json.Unmarshal([]byte(args[0]), &raw)
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateOrderUpdate(request)
+	}, "TicketID")
 	if err != nil {
-		return shim.Error(err.Error())
+		return validationResponse("OrderUpdate", err)
 	}
+	logger.Info("[OrderUpdate]--------------", request)
 
-	var data []interface{}
-	var status = 0
-	ticketID := raw["TicketID"]
-	confirm := raw["Confirm"]
-	close := raw["Close"]
-	done := raw["Done"]
-	awarded := raw["Award"]
-
-	if ticketID == nil {
-		return shim.Error("OrderUpdate: TicketID is needed")
+	ticketAsBytes, err := getEntityState(stub, ObjectTicket, request.TicketID)
+	if err != nil {
+		return routeError(err)
 	}
-	if close != nil {
-		_, err = OrderBlukUpdate(stub, ticketID, close.([]interface{}), status)
-		if err != nil {
-			return shim.Error("OrderUpdate:" + err.Error())
-		}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return errorResponse(ErrCodeNotFound, "OrderUpdate: The ticket does not exist.")
 	}
 
-	logger.Info("[OrderUpdate]--------------", raw)
-	logger.Info("[OrderUpdate]-----if start---------")
-	if confirm != nil {
-		data = confirm.([]interface{})
-		status = 2
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-	} else if done != nil {
-		data = done.([]interface{})
-		status = 3
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-	} else if awarded != nil {
-		data = awarded.([]interface{})
-		status = 4
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-
-		logger.Info("-----1---------")
-		// Award user
-		var ticket Ticket
-		ticketAsBytes, err := stub.GetState(ticketID.(string))
-		if err != nil {
-			return shim.Error(err.Error())
-		}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(ErrCodeUnauthenticated, err.Error())
+	}
 
-		err = json.Unmarshal(ticketAsBytes, &ticket)
+	// ==== Apply the transitions, withdrawals and rejections first ====
+	result := OrderUpdateResult{TicketID: request.TicketID}
+	steps := []struct {
+		userIDs []string
+		status  int
+	}{
+		{request.Close, OrderClosed},
+		{request.Reject, OrderRejected},
+		{request.Confirm, OrderConfirmed},
+		{request.Done, OrderDone},
+		{request.Award, OrderAwarded},
+	}
+	capacity, err := loadCapacity(stub, ticket)
+	if err != nil {
+		return routeError(wrapError("OrderUpdate", err))
+	}
+	var awarded []string
+	for _, step := range steps {
+		results, err := transitionOrders(stub, caller, ticket, capacity, step.userIDs, step.status)
 		if err != nil {
-			return shim.Error(err.Error())
+			return routeError(wrapError("OrderUpdate", err))
+		}
+		for _, r := range results {
+			if r.OK && step.status == OrderAwarded {
+				awarded = append(awarded, r.UserID)
+			}
 		}
-		award(stub, ticket.TicketID, data, ticket.Value)
+		result.Results = append(result.Results, results...)
 	}
-	logger.Info("[OrderUpdate]-----if end---------")
 
+	// ==== Slots freed by withdrawals go to the waitlist ====
+	promoted, err := promoteWaitlist(stub, ticket, capacity)
 	if err != nil {
-		return shim.Error("OrderUpdate:" + err.Error())
+		return routeError(wrapError("OrderUpdate", err))
 	}
+	result.Results = append(result.Results, promoted...)
 
-	logger.Info("[OrderUpdate]-----order read2 start---------")
-	sc.OrderRead2(stub, []string{ticketID.(string)})
-	logger.Info("[OrderUpdate]-----order read2 start---------")
+	// ==== Award user, also after the last open assignee left ====
+	_, err = award(stub, ticket, capacity, awarded)
+	if err != nil {
+		return routeError(wrapError("OrderUpdate", err))
+	}
 
-	logger.Info("[OrderUpdate]-----time sleep start---------")
-	//time.Sleep(time.Second*30)
-	logger.Info("[OrderUpdate]-----time sleep end---------")
 	// update ticket status
+	_, err = updateTicketStatus(stub, request.TicketID, capacity)
+	if err != nil {
+		return routeError(wrapError("OrderUpdate", err))
+	}
 
-	logger.Info("[OrderUpdate]-----AutoUpdateTicketStatus start---------")
-	sc.AutoUpdateTicketStatus(stub, ticketID.(string))
-	logger.Info("[OrderUpdate]-----AutoUpdateTicketStatus end---------")
-	// ticket id & ticket object list
-
-	logger.Info("[OrderUpdate]-----order read2 2 start---------")
-	sc.OrderRead2(stub, []string{ticketID.(string)})
-	logger.Info("[OrderUpdate]-----order read2 2 start---------")
-
-	// to do
-	return shim.Success(nil)
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error("OrderUpdate: Error marshalling result")
+	}
+	return shim.Success(resultAsBytes)
 }
 
+//Invoke Route: AutoUpdateTicketStatus
 func (sc *SmartContract) AutoUpdateTicketStatus(stub shim.ChaincodeStubInterface, args string) peer.Response {
-	var maxStatus = 0
 	var ticketID = args
-	var order Order
-	var ticket Ticket
 	logger.Info("AutoUpdateTicketStatus ticketID:", ticketID)
-	// Get all order to get max status
-	orderInterator, err :=
-		stub.GetStateByPartialCompositeKey("Order", []string{ticketID})
 
+	ticket, err := retrieveTicket(stub, ticketID)
 	if err != nil {
-		logger.Info("AutoUpdateTicketStatus Error:", err)
+		return routeError(wrapError("AutoUpdateTicketStatus", err))
 	}
+	// Get all order to get max status
+	capacity, err := loadCapacity(stub, ticket)
+	if err != nil {
+		return routeError(wrapError("AutoUpdateTicketStatus", err))
+	}
+	ticketAsBytes, err := updateTicketStatus(stub, ticketID, capacity)
+	if err != nil {
+		return routeError(wrapError("AutoUpdateTicketStatus", err))
+	}
+	return shim.Success(ticketAsBytes)
+}
 
-	var lognum = 1
-	logger.Info("AutoUpdateTicketStatus orderInterator 1 :", orderInterator)
-
-	queryResponse, _ := orderInterator.Next()
-	json.Unmarshal(queryResponse.Value, &order)
+//Helper: set the ticket status from the orders followed by capacity, which include the writes of the transaction
+func updateTicketStatus(stub shim.ChaincodeStubInterface, ticketID string, capacity *ticketCapacity) ([]byte, error) {
+	ticket, err := retrieveTicket(stub, ticketID)
+	if err != nil {
+		return nil, err
+	}
 
-	if maxStatus < order.Status {
-		maxStatus = order.Status
+	// an expired ticket stays expired while its done orders are awarded
+	if ticket.Status == OrderExpired {
+		return json.Marshal(ticket)
 	}
-	logger.Info("AutoUpdateTicketStatus order  : ", lognum, order)
 
-	logger.Info("AutoUpdateTicketStatus Interator for start...")
-	for orderInterator.HasNext() {
-		queryResponse, _ := orderInterator.Next()
+	oldStatus := ticket.Status
+	ticket.Status = capacity.ticketStatus()
+	logger.Info("AutoUpdateTicketStatus:", ticket.Status)
 
-		json.Unmarshal(queryResponse.Value, &order)
+	ticketAsBytes, err := saveTicket(stub, ticket)
+	if err != nil {
+		return nil, err
+	}
 
-		lognum = lognum + 1
-		logger.Info("AutoUpdateTicketStatus order :", lognum, order)
-		if maxStatus < order.Status {
-			maxStatus = order.Status
+	if oldStatus != ticket.Status {
+		err = emitEvent(stub, ChaincodeEvent{
+			Type:      EventTicketStatusChanged,
+			TicketID:  ticket.TicketID,
+			OldStatus: eventStatus(oldStatus),
+			NewStatus: eventStatus(ticket.Status)})
+		if err != nil {
+			return nil, err
 		}
 	}
-
-	ticketAsBytes, _ := stub.GetState(ticketID)
-	json.Unmarshal(ticketAsBytes, &ticket)
-
-	ticket.Status = maxStatus
-	logger.Info("AutoUpdateTicketStatus:", maxStatus)
-
-	ticketAsBytes, _ = json.Marshal(ticket)
-	stub.PutState(ticket.TicketID, ticketAsBytes)
-	return shim.Success(ticketAsBytes)
+	return ticketAsBytes, nil
 }
 
+//Helper: parse a time given as RFC3339 or as "2006-01-02 15:04:05" in UTC
+//the local timezone of a peer is never used, endorsers have to agree on the result
 func string2time(st string) (theTime time.Time, err error) {
-	timeFormated := "2018-11-26 18:05:00"
-	loc, _ := time.LoadLocation("Local")
-	theTime, err = time.ParseInLocation(st, timeFormated, loc)
+	theTime, err = time.Parse(time.RFC3339, st)
+	if err == nil {
+		return theTime, nil
+	}
+	theTime, err = time.ParseInLocation("2006-01-02 15:04:05", st, time.UTC)
 	if err != nil {
 		return theTime, err
 	}
 	return theTime, nil
 }
+
+//Helper: transaction timestamp, identical on every endorser unlike time.Now()
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("getTxTime: Error getting transaction timestamp")
+	}
+	txTime, err := ptypes.Timestamp(txTimestamp)
+	if err != nil {
+		return time.Time{}, errors.New("getTxTime: Invalid transaction timestamp")
+	}
+	return txTime, nil
+}
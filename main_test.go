@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+//MSP of the test identities
+const testMSPID = "Org1MSP"
+
+//Enrollment ID of the identity carrying exchain.admin=true, it is not registered as participant
+const testAdminID = "admin"
+
+//testStub - a MockStub with what it leaves out for the routes: creator, transient map, arguments and all events
+//every invoke runs in its own transaction, the transaction time is now and only moves with advance
+//like on a peer, reads and range scans see the committed state only and a failed transaction writes nothing
+//Creator:     serialized identity the next invoke is signed with
+//Transient:   transient map of the next invoke, cleared after it
+//Event:       chaincode event of the last invoke, nil if it set none
+//LastTxID:    transaction of the last invoke
+//History:     every write of every key, oldest first, MockStub has no history database
+type testStub struct {
+	*shim.MockStub
+	t  *testing.T
+	cc *SmartContract
+
+	args      [][]byte
+	Creator   []byte
+	Transient map[string][]byte
+	Event     []byte
+	LastTxID  string
+	History   map[string][]*queryresult.KeyModification
+
+	writes     map[string][]byte
+	writeOrder []string
+
+	now time.Time
+	tx  int
+}
+
+var testKey *ecdsa.PrivateKey
+
+//Helper: new ledger with Init run and the admin identity as creator
+func newTestStub(t *testing.T) *testStub {
+	t.Helper()
+	return newTestStubWithState(t, nil)
+}
+
+//Helper: new ledger holding the given records before Init runs, as a ledger of an earlier version
+func newTestStubWithState(t *testing.T, state map[string]string) *testStub {
+	t.Helper()
+	cc := new(SmartContract)
+	stub := &testStub{MockStub: shim.NewMockStub("exchain", cc), t: t, cc: cc, History: map[string][]*queryresult.KeyModification{}}
+	stub.now = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	stub.asAdmin()
+
+	stub.startTx()
+	for key, value := range state {
+		stub.PutState(key, []byte(value))
+	}
+	stub.endTx(true)
+
+	stub.startTx()
+	response := cc.Init(stub)
+	stub.endTx(response.Status == shim.OK)
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	return stub
+}
+
+func (stub *testStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *testStub) GetStringArgs() []string {
+	args := make([]string, len(stub.args))
+	for i, arg := range stub.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (stub *testStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.Creator, nil
+}
+
+func (stub *testStub) GetTransient() (map[string][]byte, error) {
+	return stub.Transient, nil
+}
+
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	if name != EventName {
+		stub.t.Fatalf("unexpected event name %s", name)
+	}
+	stub.Event = payload
+	return nil
+}
+
+//PutState - kept until the transaction commits
+func (stub *testStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("PutState: key must not be an empty string")
+	}
+	if _, written := stub.writes[key]; !written {
+		stub.writeOrder = append(stub.writeOrder, key)
+	}
+	stub.writes[key] = append([]byte{}, value...)
+	return nil
+}
+
+//DelState - kept until the transaction commits
+func (stub *testStub) DelState(key string) error {
+	if _, written := stub.writes[key]; !written {
+		stub.writeOrder = append(stub.writeOrder, key)
+	}
+	stub.writes[key] = nil
+	return nil
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &testHistoryIterator{modifications: stub.History[key]}, nil
+}
+
+//testHistoryIterator - the recorded writes of a key
+type testHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (iterator *testHistoryIterator) HasNext() bool {
+	return len(iterator.modifications) != 0
+}
+
+func (iterator *testHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if len(iterator.modifications) == 0 {
+		return nil, errors.New("testHistoryIterator: No more modifications")
+	}
+	modification := iterator.modifications[0]
+	iterator.modifications = iterator.modifications[1:]
+	return modification, nil
+}
+
+func (iterator *testHistoryIterator) Close() error {
+	return nil
+}
+
+//Helper: start the next transaction at the current time
+func (stub *testStub) startTx() {
+	stub.tx++
+	stub.MockTransactionStart("tx" + strconv.Itoa(stub.tx))
+	timestamp, err := ptypes.TimestampProto(stub.now)
+	if err != nil {
+		stub.t.Fatal(err)
+	}
+	stub.TxTimestamp = timestamp
+	stub.LastTxID = stub.TxID
+	stub.Event = nil
+	stub.writes = map[string][]byte{}
+	stub.writeOrder = nil
+}
+
+//Helper: end the transaction, its writes and its event only count if it succeeded
+func (stub *testStub) endTx(ok bool) {
+	if ok {
+		for _, key := range stub.writeOrder {
+			value := stub.writes[key]
+			modification := &queryresult.KeyModification{TxId: stub.TxID, Value: value, Timestamp: stub.TxTimestamp}
+			if value == nil {
+				stub.MockStub.DelState(key)
+				modification.IsDelete = true
+			} else {
+				stub.MockStub.PutState(key, value)
+			}
+			stub.History[key] = append(stub.History[key], modification)
+		}
+	} else {
+		stub.Event = nil
+	}
+	stub.writes = nil
+	stub.writeOrder = nil
+	stub.MockTransactionEnd(stub.TxID)
+}
+
+//Helper: move the transaction time
+func (stub *testStub) advance(d time.Duration) {
+	stub.now = stub.now.Add(d)
+}
+
+//Helper: sign the next invokes with a certificate for the enrollment ID carrying the given attributes
+func (stub *testStub) as(enrollmentID string, attrs map[string]string) {
+	stub.t.Helper()
+	var err error
+	if testKey == nil {
+		testKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			stub.t.Fatal(err)
+		}
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(int64(stub.tx + 1)),
+		Subject:      pkix.Name{CommonName: enrollmentID},
+		NotBefore:    stub.now.Add(-time.Hour),
+		NotAfter:     stub.now.Add(24 * 365 * time.Hour),
+	}
+	if attrs != nil {
+		value, err := json.Marshal(attrmgr.Attributes{Attrs: attrs})
+		if err != nil {
+			stub.t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrmgr.AttrOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &testKey.PublicKey, testKey)
+	if err != nil {
+		stub.t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	stub.Creator, err = proto.Marshal(&msp.SerializedIdentity{Mspid: testMSPID, IdBytes: certPEM})
+	if err != nil {
+		stub.t.Fatal(err)
+	}
+}
+
+//Helper: sign the next invokes as the admin identity
+func (stub *testStub) asAdmin() {
+	stub.as(testAdminID, map[string]string{AttrAdmin: "true"})
+}
+
+//Helper: sign the next invokes as the participant enrolled with its UserID
+func (stub *testStub) asUser(userID string) {
+	stub.as(userID, nil)
+}
+
+//Helper: run a route in a transaction of its own and decode the envelope
+func (stub *testStub) invoke(function string, args ...string) ResponseEnvelope {
+	stub.t.Helper()
+	stub.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+
+	stub.startTx()
+	response := stub.cc.Invoke(stub)
+	stub.endTx(response.Status == shim.OK)
+	stub.Transient = nil
+
+	var result ResponseEnvelope
+	err := json.Unmarshal(response.Payload, &result)
+	if err != nil {
+		stub.t.Fatalf("%s: response is not an envelope: %s %s", function, response.Message, response.Payload)
+	}
+	if result.OK != (response.Status == shim.OK) {
+		stub.t.Fatalf("%s: envelope does not match status %d", function, response.Status)
+	}
+	return result
+}
+
+//Helper: run a route that has to succeed and decode its data into result, result may be nil
+func (stub *testStub) mustInvoke(result interface{}, function string, args ...string) {
+	stub.t.Helper()
+	response := stub.invoke(function, args...)
+	if !response.OK {
+		stub.t.Fatalf("%s failed: %s %s", function, response.Error.Code, response.Error.Message)
+	}
+	if result != nil {
+		err := json.Unmarshal(response.Data, result)
+		if err != nil {
+			stub.t.Fatalf("%s: unexpected data %s", function, response.Data)
+		}
+	}
+}
+
+//Helper: run a route that has to fail with the error code
+func (stub *testStub) expectError(code string, function string, args ...string) *ErrorResponse {
+	stub.t.Helper()
+	response := stub.invoke(function, args...)
+	if response.OK {
+		stub.t.Fatalf("%s: expected %s, got data %s", function, code, response.Data)
+	}
+	if response.Error.Code != code {
+		stub.t.Fatalf("%s: expected %s, got %s %s", function, code, response.Error.Code, response.Error.Message)
+	}
+	return response.Error
+}
+
+//Helper: events of the last invoke
+func (stub *testStub) events() []ChaincodeEvent {
+	stub.t.Helper()
+	if stub.Event == nil {
+		return nil
+	}
+	var batch EventBatch
+	err := json.Unmarshal(stub.Event, &batch)
+	if err != nil {
+		stub.t.Fatalf("corrupt event batch %s", stub.Event)
+	}
+	if batch.TxID != stub.LastTxID {
+		stub.t.Fatalf("event batch of %s in %s", batch.TxID, stub.LastTxID)
+	}
+	return batch.Events
+}
+
+//Helper: encode an input object as argument
+func toJSON(t *testing.T, input interface{}) string {
+	t.Helper()
+	bytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+//Helper: register a participant as admin, the creator stays the admin
+func (stub *testStub) addUser(userID string, lobID int) {
+	stub.t.Helper()
+	stub.asAdmin()
+	stub.mustInvoke(nil, "addParticipant", toJSON(stub.t, map[string]interface{}{
+		"Participant_UserID":   userID,
+		"Participant_UserName": "User " + userID,
+		"Participant_IsAdmin":  false,
+		"Participant_LoBID":    lobID,
+	}))
+}
+
+//Helper: grant credit to a participant as admin
+func (stub *testStub) grant(userID string, value int) {
+	stub.t.Helper()
+	stub.asAdmin()
+	stub.mustInvoke(nil, "CreditAdd", toJSON(stub.t, CreditAddRequest{UserID: userID, Value: value}))
+}
+
+//Helper: credit value of a participant
+func (stub *testStub) credit(userID string) Credit {
+	stub.t.Helper()
+	var credit Credit
+	stub.mustInvoke(&credit, "CreditRead", userID)
+	return credit
+}
+
+//Helper: LoB record
+func (stub *testStub) lob(lobID int) LoBDetail {
+	stub.t.Helper()
+	var lob LoBDetail
+	stub.mustInvoke(&lob, "LoBRead", strconv.Itoa(lobID))
+	return lob
+}
+
+//Helper: create a P1 ticket worth value as its creator, fields are added to the input
+func (stub *testStub) createTicket(userID string, value int, fields map[string]interface{}) Ticket {
+	stub.t.Helper()
+	input := map[string]interface{}{
+		"Ticket_Title":  "Ticket of " + userID,
+		"Ticket_Type":   P1,
+		"Ticket_Value":  value,
+		"Ticket_UserID": userID,
+	}
+	for field, value := range fields {
+		input[field] = value
+	}
+	var ticket Ticket
+	stub.asUser(userID)
+	stub.mustInvoke(&ticket, "TicketCreate", toJSON(stub.t, input))
+	return ticket
+}
+
+//Helper: stored ticket
+func (stub *testStub) ticket(ticketID string) Ticket {
+	stub.t.Helper()
+	var ticket Ticket
+	stub.mustInvoke(&ticket, "TicketRead", ticketID)
+	return ticket
+}
+
+//Helper: apply for a ticket as the user
+func (stub *testStub) apply(ticketID string, userID string) {
+	stub.t.Helper()
+	stub.asUser(userID)
+	stub.mustInvoke(nil, "OrderCreate", toJSON(stub.t, Order{TicketID: ticketID, UserID: userID}))
+}
+
+//Helper: move orders as the current creator, every transition has to succeed
+func (stub *testStub) updateOrders(request OrderUpdateRequest) OrderUpdateResult {
+	stub.t.Helper()
+	var result OrderUpdateResult
+	stub.mustInvoke(&result, "OrderUpdate", toJSON(stub.t, request))
+	for _, r := range result.Results {
+		if !r.OK {
+			stub.t.Fatalf("OrderUpdate: %s %s -> %s failed: %s", r.UserID, r.From, r.To, r.Reason)
+		}
+	}
+	return result
+}
+
+//Helper: stored order, Status is -1 if there is none
+func (stub *testStub) order(ticketID string, userID string) Order {
+	stub.t.Helper()
+	response := stub.invoke("OrderRead", ticketID, userID)
+	if !response.OK {
+		stub.t.Fatalf("OrderRead failed: %s", response.Error.Message)
+	}
+	order := Order{Status: -1}
+	if string(response.Data) != "null" {
+		err := json.Unmarshal(response.Data, &order)
+		if err != nil {
+			stub.t.Fatalf("OrderRead: unexpected data %s", response.Data)
+		}
+	}
+	return order
+}
+
+//Helper: run a ticket from application to award for the assignees, as creator and assignees
+func (stub *testStub) completeTicket(ticket Ticket, userIDs ...string) {
+	stub.t.Helper()
+	for _, userID := range userIDs {
+		stub.apply(ticket.TicketID, userID)
+	}
+	stub.asUser(ticket.UserID)
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: userIDs})
+	for _, userID := range userIDs {
+		stub.asUser(userID)
+		stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{userID}})
+	}
+	stub.asUser(ticket.UserID)
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Award: userIDs})
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Offboarding modes
+//   archive:   the participant is replaced by a tombstone, its credit and transfers are kept
+//   purge:     every record of the participant is deleted
+const (
+	OffboardArchive = "archive"
+	OffboardPurge   = "purge"
+)
+
+//OffboardRequest - input of ParticipantOffboard
+//ReassignTo is optional, without it the open tickets of the participant are cancelled
+type OffboardRequest struct {
+	UserID     string `json:"userID"`
+	Mode       string `json:"mode"`
+	ReassignTo string `json:"reassignTo"`
+}
+
+//Tombstone information, left in place of an archived participant
+//UserID:        iXXXXXX
+//Participant:   the participant record at the time it was archived
+//Timestamp:     transaction timestamp
+type Tombstone struct {
+	UserID      string      `json:"Tombstone_UserID"`
+	Participant Participant `json:"Tombstone_Participant"`
+	TxID        string      `json:"Tombstone_TxID"`
+	Timestamp   time.Time   `json:"Tombstone_Timestamp"`
+}
+
+//OffboardReport - every record touched by an offboarding, returned by ParticipantOffboard
+type OffboardReport struct {
+	UserID string `json:"userID"`
+	Mode   string `json:"mode"`
+	TxID   string `json:"txId"`
+
+	LoBID        int  `json:"lobId"`
+	Credit       int  `json:"credit"`
+	CreditKept   bool `json:"creditKept"`
+	Tombstone    bool `json:"tombstone"`
+	Credential   bool `json:"credentialDeleted"`
+	IdentityBind bool `json:"identityIndexDeleted"`
+
+	OrdersClosed       []string `json:"ordersClosed"`
+	OrdersDeleted      []string `json:"ordersDeleted"`
+	TicketsReassigned  []string `json:"ticketsReassigned"`
+	TicketsCancelled   []string `json:"ticketsCancelled"`
+	TicketsDeleted     []string `json:"ticketsDeleted"`
+	TransfersDeleted   int      `json:"transfersDeleted"`
+	LoBMovesDeleted    int      `json:"lobMovesDeleted"`
+	JournalDeleted     int      `json:"journalEntriesDeleted"`
+	RedemptionsDeleted int      `json:"redemptionsDeleted"`
+}
+
+func tombstoneKey(stub shim.ChaincodeStubInterface, userID string) (string, error) {
+	return stub.CreateCompositeKey("Tombstone", []string{userID})
+}
+
+//Helper: tombstone of an archived participant, nil if there is none
+func retrieveTombstone(stub shim.ChaincodeStubInterface, userID string) ([]byte, error) {
+	key, err := tombstoneKey(stub, userID)
+	if err != nil {
+		return nil, wrapError("retrieveTombstone", err)
+	}
+	tombstoneAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("retrieveTombstone: Error getting tombstone of " + userID)
+	}
+	return tombstoneAsBytes, nil
+}
+
+//Helper: every ticket, the legacy numbered ones first, then the indexed ones
+func listAllTickets(stub shim.ChaincodeStubInterface) ([]Ticket, error) {
+	var tickets []Ticket
+
+	// ledgers created after ticket IDs were derived from transactions have no TICKETID counter
+	lastTicketID := 0
+	TICKETIDAsBytes, err := stub.GetState("TICKETID")
+	if err != nil {
+		return nil, errors.New("listAllTickets: Error getting TICKETID")
+	}
+	if TICKETIDAsBytes != nil {
+		lastTicketID, err = strconv.Atoi(string(TICKETIDAsBytes))
+		if err != nil {
+			return nil, errors.New("listAllTickets: Corrupt TICKETID " + string(TICKETIDAsBytes))
+		}
+	}
+	ticketIDs := []string{}
+	for i := 1; i <= lastTicketID; i++ {
+		ticketIDs = append(ticketIDs, strconv.Itoa(i))
+	}
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey("TicketIndex", []string{})
+	if err != nil {
+		return nil, wrapError("listAllTickets", err)
+	}
+	defer indexIterator.Close()
+	for indexIterator.HasNext() {
+		queryResponse, err := indexIterator.Next()
+		if err != nil {
+			return nil, wrapError("listAllTickets", err)
+		}
+		ticketIDs = append(ticketIDs, string(queryResponse.Value))
+	}
+
+	for _, ticketID := range ticketIDs {
+		var ticket Ticket
+		ticketAsBytes, err := getEntityState(stub, ObjectTicket, ticketID)
+		if err != nil {
+			return nil, err
+		}
+		// deleted tickets leave gaps
+		if ticketAsBytes == nil {
+			continue
+		}
+		err = json.Unmarshal(ticketAsBytes, &ticket)
+		if err != nil {
+			return nil, errors.New("listAllTickets: Corrupt ticket record " + string(ticketAsBytes))
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, nil
+}
+
+//Helper: orders matching a partial key, Order~<ticketID>~<userID>
+func listOrders(stub shim.ChaincodeStubInterface, attributes []string) ([]Order, error) {
+	var orders []Order
+	orderIterator, err := stub.GetStateByPartialCompositeKey("Order", attributes)
+	if err != nil {
+		return nil, wrapError("listOrders", err)
+	}
+	defer orderIterator.Close()
+
+	for orderIterator.HasNext() {
+		var order Order
+		queryResponse, err := orderIterator.Next()
+		if err != nil {
+			return nil, wrapError("listOrders", err)
+		}
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return nil, errors.New("listOrders: Corrupt order record " + string(queryResponse.Value))
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func deleteOrder(stub shim.ChaincodeStubInterface, order Order) error {
+	key, err := stub.CreateCompositeKey("Order", []string{order.TicketID, order.UserID})
+	if err != nil {
+		return wrapError("deleteOrder", err)
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errors.New("deleteOrder: Error deleting order " + order.TicketID + "/" + order.UserID)
+	}
+	_, err = deleteByPartialKey(stub, "OrderApproval", []string{order.TicketID, order.UserID})
+	return err
+}
+
+//Helper: close an order without the transition table, used when its user or ticket goes away
+//returns false for orders that are already finished
+func forceCloseOrder(stub shim.ChaincodeStubInterface, order Order) (bool, error) {
+	if order.Status == OrderAwarded || order.Status == OrderClosed || order.Status == OrderRejected || order.Status == OrderExpired {
+		return false, nil
+	}
+	from := order.Status
+	order.Status = OrderClosed
+	order.WaitlistedAt = nil
+	_, err := OrderSaving(stub, order)
+	if err != nil {
+		return false, err
+	}
+	err = emitEvent(stub, ChaincodeEvent{
+		Type:      EventOrderStatusChanged,
+		TicketID:  order.TicketID,
+		UserID:    order.UserID,
+		OldStatus: eventStatus(from),
+		NewStatus: eventStatus(OrderClosed)})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//Helper: delete all records under a partial composite key, returns how many were deleted
+func deleteByPartialKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string) (int, error) {
+	var keys []string
+	iterator, err := stub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, wrapError("deleteByPartialKey", err)
+	}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return 0, wrapError("deleteByPartialKey", err)
+		}
+		keys = append(keys, queryResponse.Key)
+	}
+	iterator.Close()
+
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return 0, errors.New("deleteByPartialKey: Error deleting " + objectType + " record")
+		}
+	}
+	return len(keys), nil
+}
+
+//Helper: whether a ticket can still be worked on or paid out
+func isTicketOpen(stub shim.ChaincodeStubInterface, ticket Ticket) bool {
+	escrow, err := retrieveEscrow(stub, ticket.TicketID)
+	if err == nil {
+		return escrow.Status == EscrowLocked
+	}
+	return ticket.Status < OrderAwarded
+}
+
+//Helper: hand an open ticket and its escrow over to another participant
+func reassignTicket(stub shim.ChaincodeStubInterface, ticket Ticket, userID string) error {
+	ticket.UserID = userID
+	_, err := saveTicket(stub, ticket)
+	if err != nil {
+		return err
+	}
+	// refunds go to the new owner, the former one is leaving
+	escrow, err := retrieveEscrow(stub, ticket.TicketID)
+	if err == nil {
+		escrow.UserID = userID
+		_, err = saveEscrow(stub, escrow)
+		if err != nil {
+			return err
+		}
+	}
+	return emitEvent(stub, ChaincodeEvent{Type: EventTicketUpdated, TicketID: ticket.TicketID, UserID: userID})
+}
+
+//Helper: close the open orders of a ticket, close its escrow and mark it closed
+//the refund of the escrow is returned, the caller gives it back to the creator
+//tickets with done orders waiting for their award cannot be cancelled
+func cancelTicket(stub shim.ChaincodeStubInterface, ticket Ticket) (escrowRefund, error) {
+	var refund escrowRefund
+	_, waiting, err := countCompletedOrders(stub, ticket.TicketID)
+	if err != nil {
+		return refund, err
+	}
+	if waiting != 0 {
+		return refund, conflictError("cancelTicket: Ticket " + ticket.TicketID + " has done orders waiting for their award, award them or reassign the ticket")
+	}
+	orders, err := listOrders(stub, []string{ticket.TicketID})
+	if err != nil {
+		return refund, err
+	}
+	for _, order := range orders {
+		_, err = forceCloseOrder(stub, order)
+		if err != nil {
+			return refund, err
+		}
+	}
+
+	escrow, err := retrieveEscrow(stub, ticket.TicketID)
+	if err == nil && escrow.Status == EscrowLocked {
+		refund, err = closeEscrow(stub, &escrow, EscrowRefunded)
+		if err != nil {
+			return refund, err
+		}
+	}
+
+	oldStatus := ticket.Status
+	ticket.Status = OrderClosed
+	_, err = saveTicket(stub, ticket)
+	if err != nil {
+		return refund, err
+	}
+	return refund, emitEvent(stub, ChaincodeEvent{
+		Type:      EventTicketStatusChanged,
+		TicketID:  ticket.TicketID,
+		OldStatus: eventStatus(oldStatus),
+		NewStatus: eventStatus(OrderClosed)})
+}
+
+//Helper: delete a ticket with its index entry, escrow and orders
+func purgeTicket(stub shim.ChaincodeStubInterface, ticket Ticket, report *OffboardReport) error {
+	orders, err := listOrders(stub, []string{ticket.TicketID})
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		err = deleteOrder(stub, order)
+		if err != nil {
+			return err
+		}
+		report.OrdersDeleted = append(report.OrdersDeleted, order.TicketID+"/"+order.UserID)
+	}
+	err = stub.DelState(escrowKey(ticket.TicketID))
+	if err != nil {
+		return errors.New("purgeTicket: Error deleting escrow of ticket " + ticket.TicketID)
+	}
+	err = deleteTicketIndex(stub, ticket)
+	if err != nil {
+		return err
+	}
+	err = delEntityState(stub, ObjectTicket, ticket.TicketID)
+	if err != nil {
+		return err
+	}
+	report.TicketsDeleted = append(report.TicketsDeleted, ticket.TicketID)
+	return nil
+}
+
+//Helper: offboard a participant and everything depending on it
+//the credit is read once, refunds of cancelled tickets are added to it and it is written once at the end
+func (rdg *SmartContract) offboardParticipant(stub shim.ChaincodeStubInterface, request OffboardRequest) (OffboardReport, error) {
+	var participant Participant
+	var refunds []escrowRefund
+	report := OffboardReport{
+		UserID:            request.UserID,
+		Mode:              request.Mode,
+		TxID:              stub.GetTxID(),
+		OrdersClosed:      []string{},
+		OrdersDeleted:     []string{},
+		TicketsReassigned: []string{},
+		TicketsCancelled:  []string{},
+		TicketsDeleted:    []string{}}
+
+	if request.Mode != OffboardArchive && request.Mode != OffboardPurge {
+		return report, errors.New("offboardParticipant: mode must be " + OffboardArchive + " or " + OffboardPurge)
+	}
+	participantAsByteArray, err := rdg.retrieveParticipant(stub, request.UserID)
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(participantAsByteArray, &participant)
+	if err != nil {
+		return report, errors.New("offboardParticipant: Error unmarshalling participant JSON")
+	}
+	report.LoBID = participant.LoBID
+
+	if request.ReassignTo != "" {
+		if request.ReassignTo == request.UserID {
+			return report, errors.New("offboardParticipant: Cannot reassign tickets to the participant being offboarded")
+		}
+		_, err = rdg.retrieveParticipant(stub, request.ReassignTo)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	credit, err := retrieveSingleCredit(stub, request.UserID)
+	if err != nil {
+		return report, err
+	}
+
+	// ==== Tickets created by the participant ====
+	tickets, err := listAllTickets(stub)
+	if err != nil {
+		return report, err
+	}
+	for _, ticket := range tickets {
+		if ticket.UserID != request.UserID {
+			continue
+		}
+		if isTicketOpen(stub, ticket) {
+			if request.ReassignTo != "" {
+				err = reassignTicket(stub, ticket, request.ReassignTo)
+				if err != nil {
+					return report, err
+				}
+				report.TicketsReassigned = append(report.TicketsReassigned, ticket.TicketID)
+				continue
+			}
+			refund, err := cancelTicket(stub, ticket)
+			if err != nil {
+				return report, err
+			}
+			refunds = append(refunds, refund)
+			report.TicketsCancelled = append(report.TicketsCancelled, ticket.TicketID)
+		}
+		if request.Mode == OffboardPurge {
+			err = purgeTicket(stub, ticket, &report)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// ==== Orders of the participant on other tickets ====
+	orders, err := listOrders(stub, []string{})
+	if err != nil {
+		return report, err
+	}
+	for _, order := range orders {
+		if order.UserID != request.UserID || Is_Inarray(report.TicketsDeleted, order.TicketID) {
+			continue
+		}
+		if request.Mode == OffboardPurge {
+			err = deleteOrder(stub, order)
+			if err != nil {
+				return report, err
+			}
+			report.OrdersDeleted = append(report.OrdersDeleted, order.TicketID+"/"+order.UserID)
+			// the scan in refillTicket still sees the deleted order, it is followed as closed
+			previousStatus := order.Status
+			order.Status = OrderClosed
+			err = refillTicket(stub, order, previousStatus)
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		closed, err := forceCloseOrder(stub, order)
+		if err != nil {
+			return report, err
+		}
+		if closed {
+			report.OrdersClosed = append(report.OrdersClosed, order.TicketID+"/"+order.UserID)
+			// the slot of a leaving assignee goes to the waitlist
+			previousStatus := order.Status
+			order.Status = OrderClosed
+			err = refillTicket(stub, order, previousStatus)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// ==== Credit and LoB membership ====
+	// escrows of the participant's tickets go back to it, a purged credit keeps no journal
+	var others []escrowRefund
+	for _, refund := range refunds {
+		if refund.UserID != credit.UserID {
+			others = append(others, refund)
+		} else if request.Mode == OffboardPurge {
+			credit.Value += refund.Value
+		} else {
+			err = applyRefunds(stub, &credit, []escrowRefund{refund})
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	err = creditRefunds(stub, others)
+	if err != nil {
+		return report, err
+	}
+	report.Credit = credit.Value
+
+	// a purged participant takes its contribution out of the LoB total, an archived one leaves it there
+	LoB_temp, err := retrieveLoB(stub, participant.LoBID)
+	if err != nil {
+		return report, err
+	}
+	LoB_temp.UserIDs, _ = deleteKeyFromStringArray(LoB_temp.UserIDs, request.UserID)
+	if request.Mode == OffboardPurge {
+		LoB_temp.TotalCredit -= credit.Contributed
+	}
+	_, err = saveLoB(stub, LoB_temp)
+	if err != nil {
+		return report, err
+	}
+
+	if request.Mode == OffboardArchive {
+		// written under the namespaced key so it outlives the legacy key fallback
+		_, err = saveCredit(stub, credit)
+		if err != nil {
+			return report, err
+		}
+		// archived participants are not ranked
+		err = deleteCreditRank(stub, credit)
+		if err != nil {
+			return report, err
+		}
+		report.CreditKept = true
+	} else {
+		err = deleteCredit(stub, request.UserID)
+		if err != nil {
+			return report, err
+		}
+		report.TransfersDeleted, err = deleteByPartialKey(stub, "Transfer", []string{request.UserID})
+		if err != nil {
+			return report, err
+		}
+		report.LoBMovesDeleted, err = deleteByPartialKey(stub, "LoBMove", []string{request.UserID})
+		if err != nil {
+			return report, err
+		}
+		report.JournalDeleted, err = deleteByPartialKey(stub, "CreditJournal", []string{request.UserID})
+		if err != nil {
+			return report, err
+		}
+		report.RedemptionsDeleted, err = deleteRedemptions(stub, request.UserID)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// ==== Participant, identity and credential ====
+	err = deleteIdentityIndex(stub, participant)
+	if err != nil {
+		return report, err
+	}
+	report.IdentityBind = participant.MSPID != "" && participant.EnrollmentID != ""
+	credentialAsBytes, err := stub.GetState(credentialKey(request.UserID))
+	if err != nil {
+		return report, errors.New("offboardParticipant: Error getting credential")
+	}
+	if credentialAsBytes != nil {
+		err = stub.DelState(credentialKey(request.UserID))
+		if err != nil {
+			return report, errors.New("offboardParticipant: Error deleting credential")
+		}
+		report.Credential = true
+	}
+	_, err = rdg.deleteReadingIDIndex(stub, request.UserID)
+	if err != nil {
+		return report, err
+	}
+	err = delEntityState(stub, ObjectParticipant, request.UserID)
+	if err != nil {
+		return report, err
+	}
+
+	if request.Mode == OffboardArchive {
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return report, err
+		}
+		tombstone := Tombstone{UserID: request.UserID, Participant: participant, TxID: stub.GetTxID(), Timestamp: txTime}
+		tombstoneAsBytes, err := json.Marshal(tombstone)
+		if err != nil {
+			return report, errors.New("offboardParticipant: Error marshalling tombstone")
+		}
+		key, err := tombstoneKey(stub, request.UserID)
+		if err != nil {
+			return report, wrapError("offboardParticipant", err)
+		}
+		err = stub.PutState(key, tombstoneAsBytes)
+		if err != nil {
+			return report, errors.New("offboardParticipant: Error storing tombstone")
+		}
+		report.Tombstone = true
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventParticipantDeleted, UserID: request.UserID, Value: credit.Value})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+//Invoke Route: ParticipantOffboard
+//archives or purges a participant, its open tickets are reassigned or cancelled
+func (rdg *SmartContract) ParticipantOffboard(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request OffboardRequest
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateOffboard(request)
+	}, "userID", "mode")
+	if err != nil {
+		return validationResponse("ParticipantOffboard", err)
+	}
+	report, err := rdg.offboardParticipant(stub, request)
+	if err != nil {
+		return routeError(wrapError("ParticipantOffboard", err))
+	}
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error("ParticipantOffboard: Error marshalling report")
+	}
+	return shim.Success(reportAsBytes)
+}
+
+//Query Route: TombstoneRead
+func (rdg *SmartContract) TombstoneRead(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	tombstoneAsBytes, err := retrieveTombstone(stub, userID)
+	if err != nil {
+		return routeError(err)
+	}
+	if tombstoneAsBytes == nil {
+		return errorResponse(ErrCodeNotFound, "TombstoneRead: "+userID+" has not been archived")
+	}
+	return shim.Success(tombstoneAsBytes)
+}
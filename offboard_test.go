@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+)
+
+//Helper: ticket of i000003 with one slot, i000001 holds it and i000002 waits for it
+func (stub *testStub) fullTicket() Ticket {
+	stub.t.Helper()
+	ticket := stub.createTicket("i000003", 0, map[string]interface{}{"Ticket_MaxAssignees": 1})
+	stub.apply(ticket.TicketID, "i000001")
+	stub.apply(ticket.TicketID, "i000002")
+	stub.asUser("i000003")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000001"}})
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}})
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != OrderWaitlisted {
+		stub.t.Fatalf("expected Waitlisted, got %+v", order)
+	}
+	return ticket
+}
+
+func TestParticipantOffboardArchive(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.grant("i000001", 50)
+	own := stub.createTicket("i000001", 20, nil)
+	other := stub.fullTicket()
+
+	stub.asAdmin()
+	var report OffboardReport
+	stub.mustInvoke(&report, "ParticipantOffboard", `{"userID":"i000001","mode":"archive"}`)
+	if !report.Tombstone || !report.CreditKept || report.Credit != 50 || report.LoBID != HANA {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.TicketsCancelled) != 1 || report.TicketsCancelled[0] != own.TicketID {
+		t.Errorf("unexpected cancelled tickets %+v", report.TicketsCancelled)
+	}
+	if len(report.OrdersClosed) != 1 || report.OrdersClosed[0] != other.TicketID+"/i000001" {
+		t.Errorf("unexpected closed orders %+v", report.OrdersClosed)
+	}
+
+	// the escrow goes back to the credit, which is kept
+	if escrow := stub.escrow(own.TicketID); escrow.Status != EscrowRefunded {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if value := stub.credit("i000001").Value; value != 50 {
+		t.Errorf("credit: expected 50, got %d", value)
+	}
+	if ticket := stub.ticket(own.TicketID); ticket.Status != OrderClosed {
+		t.Errorf("ticket not closed %+v", ticket)
+	}
+	// the freed slot goes to the waitlist
+	if order := stub.order(other.TicketID, "i000001"); order.Status != OrderClosed {
+		t.Errorf("order not closed %+v", order)
+	}
+	if order := stub.order(other.TicketID, "i000002"); order.Status != OrderConfirmed {
+		t.Errorf("waitlist not promoted %+v", order)
+	}
+
+	var tombstone Tombstone
+	stub.mustInvoke(&tombstone, "TombstoneRead", "i000001")
+	if tombstone.TxID != report.TxID || tombstone.Participant.UserName != "User i000001" {
+		t.Errorf("unexpected tombstone %+v", tombstone)
+	}
+	stub.expectError(ErrCodeNotFound, "readParticipant", "i000001")
+	for _, member := range stub.lob(HANA).Members {
+		if member.UserID == "i000001" {
+			t.Error("archived participant still listed in its LoB")
+		}
+	}
+
+	// an archived ID is not given out again
+	stub.expectError(ErrCodeConflict, "addParticipant", `{"Participant_UserID":"i000001","Participant_UserName":"x","Participant_IsAdmin":false,"Participant_LoBID":1}`)
+}
+
+func TestParticipantOffboardPurge(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.grant("i000001", 50)
+	own := stub.createTicket("i000001", 20, nil)
+	other := stub.fullTicket()
+	stub.grant("i000003", 10)
+	stub.completeTicket(stub.createTicket("i000003", 10, nil), "i000001")
+	hana := stub.lob(HANA).TotalCredit
+
+	stub.asAdmin()
+	var report OffboardReport
+	stub.mustInvoke(&report, "ParticipantOffboard", `{"userID":"i000001","mode":"purge","reassignTo":"i000002"}`)
+	if report.Tombstone || report.CreditKept || len(report.TicketsCancelled) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.TicketsReassigned) != 1 || report.TicketsReassigned[0] != own.TicketID {
+		t.Errorf("unexpected reassigned tickets %+v", report.TicketsReassigned)
+	}
+	if len(report.OrdersDeleted) != 2 || report.JournalDeleted == 0 {
+		t.Errorf("unexpected deletions %+v", report)
+	}
+
+	// the open ticket and its escrow change hands
+	if ticket := stub.ticket(own.TicketID); ticket.UserID != "i000002" {
+		t.Errorf("ticket not reassigned %+v", ticket)
+	}
+	if escrow := stub.escrow(own.TicketID); escrow.UserID != "i000002" || escrow.Status != EscrowLocked {
+		t.Errorf("escrow not reassigned %+v", escrow)
+	}
+	if order := stub.order(other.TicketID, "i000001"); order.Status != -1 {
+		t.Errorf("order not deleted %+v", order)
+	}
+	if order := stub.order(other.TicketID, "i000002"); order.Status != OrderConfirmed {
+		t.Errorf("waitlist not promoted %+v", order)
+	}
+
+	stub.expectError(ErrCodeNotFound, "CreditRead", "i000001")
+	stub.expectError(ErrCodeNotFound, "TombstoneRead", "i000001")
+	// the earned credit leaves the LoB total with the participant
+	if total := stub.lob(HANA).TotalCredit; total != hana-10 {
+		t.Errorf("LoB total: expected %d, got %d", hana-10, total)
+	}
+}
+
+func TestParticipantOffboardRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	ticket := stub.createTicket("i000001", 0, nil)
+	stub.apply(ticket.TicketID, "i000002")
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}})
+	stub.asUser("i000002")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{"i000002"}})
+
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "ParticipantOffboard", `{"userID":"i000001","mode":"archive"}`)
+
+	stub.asAdmin()
+	// done work is awarded or handed over before its ticket is cancelled
+	stub.expectError(ErrCodeConflict, "ParticipantOffboard", `{"userID":"i000001","mode":"archive"}`)
+	stub.expectError(ErrCodeNotFound, "ParticipantOffboard", `{"userID":"i000009","mode":"archive"}`)
+	stub.expectError(ErrCodeNotFound, "ParticipantOffboard", `{"userID":"i000001","mode":"archive","reassignTo":"i000009"}`)
+	stub.expectError(ErrCodeBadRequest, "ParticipantOffboard", `{"userID":"i000001","mode":"delete"}`)
+	stub.expectError(ErrCodeBadRequest, "ParticipantOffboard", `{"userID":"i000001","mode":"archive","reassignTo":"i000001"}`)
+	stub.expectError(ErrCodeNotFound, "TombstoneRead", "i000001")
+
+	// a rejected offboarding leaves the participant in place
+	var participant Participant
+	stub.mustInvoke(&participant, "readParticipant", "i000001")
+	if participant.LoBID != HANA {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+}
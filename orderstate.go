@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//Order status
+//Created  ->  Applied  ->  Confirmed  ->  Done  ->  Awarded
+//                |             |            |
+//                +-> Rejected  +-> Closed   +-> Rejected
+//Created is also what the former Close branch wrote, such orders can be applied for again
+//Created, Applied, Confirmed and Waitlisted orders move to Expired when the deadline of their ticket passes
+//Applied orders confirmed while their ticket has all its assignees are Waitlisted, see capacity.go
+//Waitlisted  ->  Confirmed, first come first served once a slot is free
+const (
+	OrderCreated = iota
+	OrderApplied
+	OrderConfirmed
+	OrderDone
+	OrderAwarded
+	OrderClosed
+	OrderRejected
+	OrderExpired
+	OrderWaitlisted
+)
+
+var OrderStatusName = map[int]string{
+	OrderCreated:   "Created",
+	OrderApplied:   "Applied",
+	OrderConfirmed: "Confirmed",
+	OrderDone:      "Done",
+	OrderAwarded:   "Awarded",
+	OrderClosed:    "Closed",
+	OrderRejected:  "Rejected",
+	OrderExpired:   "Expired",
+
+	OrderWaitlisted: "Waitlisted",
+}
+
+//Roles allowed to perform an order transition
+const (
+	RoleTicketOwner = 1 << iota
+	RoleAssignee
+)
+
+//Transition table: current status -> new status -> roles allowed to perform it
+var orderTransitions = map[int]map[int]int{
+	OrderCreated: {
+		OrderApplied: RoleAssignee,
+	},
+	OrderApplied: {
+		OrderConfirmed: RoleTicketOwner,
+		OrderRejected:  RoleTicketOwner,
+		OrderClosed:    RoleTicketOwner | RoleAssignee,
+	},
+	OrderWaitlisted: {
+		OrderConfirmed: RoleTicketOwner,
+		OrderRejected:  RoleTicketOwner,
+		OrderClosed:    RoleTicketOwner | RoleAssignee,
+	},
+	OrderConfirmed: {
+		OrderDone:   RoleAssignee,
+		OrderClosed: RoleTicketOwner | RoleAssignee,
+	},
+	OrderDone: {
+		OrderAwarded:  RoleTicketOwner,
+		OrderRejected: RoleTicketOwner,
+	},
+}
+
+//OrderTransitionResult - outcome of one requested transition, returned by OrderUpdate
+type OrderTransitionResult struct {
+	UserID string `json:"UserID"`
+	From   string `json:"From"`
+	To     string `json:"To"`
+	OK     bool   `json:"OK"`
+	Reason string `json:"Reason,omitempty"`
+}
+
+//Helper: check a transition against the table and the roles of the caller
+func checkOrderTransition(from int, to int, roles int) error {
+	allowed, ok := orderTransitions[from][to]
+	if !ok {
+		return conflictError("Transition from " + OrderStatusName[from] + " to " + OrderStatusName[to] + " is not allowed")
+	}
+	if allowed&roles == 0 {
+		if allowed&RoleTicketOwner != 0 {
+			return errors.New("Only the ticket owner can move an order to " + OrderStatusName[to])
+		}
+		return errors.New("Only the assignee can move an order to " + OrderStatusName[to])
+	}
+	return nil
+}
+
+//Helper: roles the caller holds on an order
+func callerRoles(caller Caller, ticket Ticket, userID string) int {
+	if caller.IsAdmin {
+		return RoleTicketOwner | RoleAssignee
+	}
+	roles := 0
+	if caller.Registered && caller.Participant.UserID == ticket.UserID {
+		roles |= RoleTicketOwner
+	}
+	if caller.Registered && caller.Participant.UserID == userID {
+		roles |= RoleAssignee
+	}
+	return roles
+}
+
+//Helper: read a single order
+func retrieveOrder(stub shim.ChaincodeStubInterface, ticketID string, userID string) (Order, bool, error) {
+	var order Order
+	key, err := stub.CreateCompositeKey("Order", []string{ticketID, userID})
+	if err != nil {
+		return order, false, err
+	}
+	orderAsByte, err := stub.GetState(key)
+	if err != nil {
+		return order, false, errors.New("retrieveOrder: Error getting order " + ticketID + "/" + userID)
+	}
+	if orderAsByte == nil {
+		return order, false, nil
+	}
+	err = json.Unmarshal(orderAsByte, &order)
+	if err != nil {
+		return order, false, errors.New("retrieveOrder: Corrupt order record " + string(orderAsByte))
+	}
+	return order, true, nil
+}
+
+//Helper: apply the same transition to the orders of several users
+//every user gets a result, refused transitions do not stop the others
+//confirmations beyond the capacity of the ticket put applied orders on its waitlist
+func transitionOrders(stub shim.ChaincodeStubInterface, caller Caller, ticket Ticket, capacity *ticketCapacity, userIDs []string, to int) ([]OrderTransitionResult, error) {
+	var results []OrderTransitionResult
+	for _, userID := range userIDs {
+		result := OrderTransitionResult{UserID: userID, To: OrderStatusName[to]}
+
+		order, exists, err := retrieveOrder(stub, ticket.TicketID, userID)
+		if err != nil {
+			return results, err
+		}
+		if !exists {
+			result.Reason = "No order of " + userID + " for ticket " + ticket.TicketID
+			results = append(results, result)
+			continue
+		}
+		result.From = OrderStatusName[order.Status]
+
+		err = checkOrderTransition(order.Status, to, callerRoles(caller, ticket, userID))
+		if err != nil {
+			result.Reason = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		if to == OrderAwarded {
+			err = checkApprovals(stub, ticket, userID)
+			if err != nil {
+				result.Reason = err.Error()
+				results = append(results, result)
+				continue
+			}
+		}
+
+		if to == OrderConfirmed && capacity.full() {
+			if order.Status == OrderWaitlisted {
+				result.Reason = "Ticket " + ticket.TicketID + " has all " + strconv.Itoa(ticket.MaxAssignees) + " assignees"
+				results = append(results, result)
+				continue
+			}
+			order, err = waitlistOrder(stub, order)
+			if err != nil {
+				return results, err
+			}
+			capacity.follow(order, OrderWaitlisted)
+			result.To = OrderStatusName[OrderWaitlisted]
+			result.Reason = "Ticket " + ticket.TicketID + " has all " + strconv.Itoa(ticket.MaxAssignees) + " assignees, waitlisted"
+			result.OK = true
+			results = append(results, result)
+			continue
+		}
+
+		from := order.Status
+		order.Status = to
+		order.WaitlistedAt = nil
+		capacity.follow(order, to)
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return results, err
+		}
+
+		err = emitEvent(stub, ChaincodeEvent{
+			Type:      EventOrderStatusChanged,
+			TicketID:  ticket.TicketID,
+			UserID:    userID,
+			OldStatus: eventStatus(from),
+			NewStatus: eventStatus(to)})
+		if err != nil {
+			return results, err
+		}
+		result.OK = true
+		results = append(results, result)
+	}
+	return results, nil
+}
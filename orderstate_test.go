@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckOrderTransition(t *testing.T) {
+	cases := []struct {
+		from, to, roles int
+		ok              bool
+	}{
+		{OrderCreated, OrderApplied, RoleAssignee, true},
+		{OrderApplied, OrderConfirmed, RoleTicketOwner, true},
+		{OrderApplied, OrderConfirmed, RoleAssignee, false},
+		{OrderApplied, OrderClosed, RoleAssignee, true},
+		{OrderConfirmed, OrderDone, RoleAssignee, true},
+		{OrderConfirmed, OrderDone, RoleTicketOwner, false},
+		{OrderDone, OrderAwarded, RoleTicketOwner, true},
+		{OrderDone, OrderAwarded, RoleAssignee, false},
+		{OrderApplied, OrderAwarded, RoleTicketOwner | RoleAssignee, false},
+		{OrderAwarded, OrderClosed, RoleTicketOwner | RoleAssignee, false},
+		{OrderRejected, OrderApplied, RoleAssignee, false},
+		{OrderWaitlisted, OrderConfirmed, RoleTicketOwner, true},
+	}
+	for _, c := range cases {
+		err := checkOrderTransition(c.from, c.to, c.roles)
+		if (err == nil) != c.ok {
+			t.Errorf("%s -> %s with roles %d: expected ok %v, got %v", OrderStatusName[c.from], OrderStatusName[c.to], c.roles, c.ok, err)
+		}
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 10)
+	ticket := stub.createTicket("i000001", 10, nil)
+
+	stub.apply(ticket.TicketID, "i000002")
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != OrderApplied {
+		t.Fatalf("expected Applied, got %+v", order)
+	}
+
+	steps := []struct {
+		caller  string
+		request OrderUpdateRequest
+		order   int
+		ticket  int
+	}{
+		{"i000001", OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}}, OrderConfirmed, OrderConfirmed},
+		{"i000002", OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{"i000002"}}, OrderDone, OrderDone},
+		{"i000001", OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002"}}, OrderAwarded, OrderAwarded},
+	}
+	for _, step := range steps {
+		stub.asUser(step.caller)
+		stub.updateOrders(step.request)
+		if order := stub.order(ticket.TicketID, "i000002"); order.Status != step.order {
+			t.Errorf("order: expected %s, got %s", OrderStatusName[step.order], OrderStatusName[order.Status])
+		}
+		if status := stub.ticket(ticket.TicketID).Status; status != step.ticket {
+			t.Errorf("ticket: expected %d, got %d", step.ticket, status)
+		}
+	}
+	if value := stub.credit("i000002").Value; value != 10 {
+		t.Errorf("assignee: expected 10, got %d", value)
+	}
+}
+
+func TestOrderTransitionRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", HANA)
+	ticket := stub.createTicket("i000001", 0, nil)
+	stub.apply(ticket.TicketID, "i000002")
+
+	// the assignee cannot confirm itself nor skip to the award
+	stub.asUser("i000002")
+	var result OrderUpdateResult
+	stub.mustInvoke(&result, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}}))
+	if len(result.Results) != 1 || result.Results[0].OK || result.Results[0].Reason == "" {
+		t.Errorf("self confirmation accepted: %+v", result)
+	}
+	stub.asUser("i000001")
+	stub.mustInvoke(&result, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002", "i000003"}}))
+	if len(result.Results) != 2 || result.Results[0].OK || result.Results[1].OK {
+		t.Errorf("award of applied or missing order accepted: %+v", result)
+	}
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != OrderApplied {
+		t.Errorf("refused transitions moved the order: %+v", order)
+	}
+
+	// applying twice is a conflict, a rejected order cannot apply again
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeConflict, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000002"}))
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Reject: []string{"i000002"}})
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeConflict, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000002"}))
+
+	stub.expectError(ErrCodeNotFound, "OrderUpdate", `{"TicketID":"unknown"}`)
+	stub.expectError(ErrCodeBadRequest, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Close: []string{"i000002"}, Reject: []string{"i000002"}}))
+}
+
+func TestAutoUpdateTicketStatus(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	ticket := stub.createTicket("i000001", 0, nil)
+	stub.apply(ticket.TicketID, "i000002")
+
+	// the status follows the orders, withdrawn orders do not count
+	stub.asUser("i000002")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Close: []string{"i000002"}})
+	stub.asUser("i000001")
+	var updated Ticket
+	stub.mustInvoke(&updated, "AutoUpdateTicketStatus", ticket.TicketID)
+	if updated.Status != OrderCreated {
+		t.Errorf("expected Created, got %d", updated.Status)
+	}
+
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "AutoUpdateTicketStatus", ticket.TicketID)
+	stub.asAdmin()
+	stub.expectError(ErrCodeNotFound, "AutoUpdateTicketStatus", "unknown")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Split modes of a ticket policy, the payees are the assignees holding a slot: confirmed, done or awarded
+//   equal:            every payee gets the same share of the reward
+//   fixed:            every payee gets Amount, in UserID order while the reward lasts
+//   weighted:         shares in proportion to the weights the creator put in Shares, users not listed get nothing
+//   winnerTakesAll:   the first user awarded gets the whole reward
+const (
+	SplitEqual          = "equal"
+	SplitFixed          = "fixed"
+	SplitWeighted       = "weighted"
+	SplitWinnerTakesAll = "winnerTakesAll"
+)
+
+//Handling of the credits equal and weighted splits leave when rounding down
+//   creator:      given back to the creator when the ticket is closed (default)
+//   distribute:   one more credit each for the payees with the largest fractional shares
+const (
+	RemainderCreator    = "creator"
+	RemainderDistribute = "distribute"
+)
+
+//TicketPolicy - the rules a ticket sets in Ticket_Policy, a JSON object, every rule is optional
+//EligibleLoBs:          only participants of these LoBs may apply, any LoB if empty
+//MinCompletedTickets:   tickets an applicant must have been credited for before
+//ExcludedCreators:      participants who may not apply, usually the creator and co-creators of the ticket
+//Split:                 how the reward is paid out to the awarded users
+//
+//e.g. {"eligibleLoBs":[1,2],"minCompletedTickets":3,"excludedCreators":["i000001"],"split":{"mode":"equal","maxPerPayee":50}}
+type TicketPolicy struct {
+	EligibleLoBs        []int      `json:"eligibleLoBs"`
+	MinCompletedTickets int        `json:"minCompletedTickets"`
+	ExcludedCreators    []string   `json:"excludedCreators"`
+	Split               *SplitRule `json:"split"`
+}
+
+//SplitRule - payout rule of a ticket policy
+//Mode:          one of the split modes above, equal if empty
+//Amount:        credit per user of the fixed mode
+//Shares:        weight per UserID of the weighted mode
+//Remainder:     one of the remainder handlings above, creator if empty
+//MaxPerPayee:   most credit a single awarded user gets, 0 for no limit, the rest goes back to the creator
+//
+//e.g. {"mode":"weighted","shares":{"i000002":3,"i000003":1},"remainder":"distribute"}
+type SplitRule struct {
+	Mode        string         `json:"mode"`
+	Amount      int            `json:"amount"`
+	Shares      map[string]int `json:"shares"`
+	Remainder   string         `json:"remainder"`
+	MaxPerPayee int            `json:"maxPerPayee"`
+}
+
+//PolicyValidation - returned by PolicyValidate
+type PolicyValidation struct {
+	Valid  bool          `json:"valid"`
+	Errors []FieldError  `json:"errors"`
+	Policy *TicketPolicy `json:"policy"`
+}
+
+//Helper: parse and check a policy, every problem is reported as a field error
+//an empty policy has no rules
+func lintPolicy(stub shim.ChaincodeStubInterface, text string) (TicketPolicy, ValidationErrors) {
+	var policy TicketPolicy
+	var errs ValidationErrors
+	if strings.TrimSpace(text) == "" {
+		return policy, nil
+	}
+	if len(text) > MaxPolicyLength {
+		errs.maxLength("", text, MaxPolicyLength)
+		return policy, errs
+	}
+	errs = decodeStrict(text, &policy)
+	if len(errs) != 0 {
+		return policy, errs
+	}
+
+	for i, lobID := range policy.EligibleLoBs {
+		_, err := retrieveLoB(stub, lobID)
+		if err != nil {
+			errs.add("eligibleLoBs["+strconv.Itoa(i)+"]", "LoB "+strconv.Itoa(lobID)+" does not exist")
+		}
+	}
+	errs.intRange("minCompletedTickets", policy.MinCompletedTickets, 0, MaxCreditValue)
+	errs.ids("excludedCreators", policy.ExcludedCreators)
+	if policy.Split != nil {
+		lintSplit(&errs, *policy.Split)
+	}
+	return policy, errs
+}
+
+func lintSplit(errs *ValidationErrors, split SplitRule) {
+	if split.Mode != "" {
+		errs.oneOf("split.mode", split.Mode, SplitEqual, SplitFixed, SplitWeighted, SplitWinnerTakesAll)
+	}
+	if split.Mode == SplitFixed {
+		errs.intRange("split.amount", split.Amount, 1, MaxCreditValue)
+	} else if split.Amount != 0 {
+		errs.add("split.amount", "is only used by the fixed mode")
+	}
+	if split.Mode == SplitWeighted {
+		if len(split.Shares) == 0 {
+			errs.add("split.shares", "is required by the weighted mode")
+		}
+		// map order is random, errors are reported in UserID order
+		userIDs := make([]string, 0, len(split.Shares))
+		for userID := range split.Shares {
+			userIDs = append(userIDs, userID)
+		}
+		sort.Strings(userIDs)
+		for _, userID := range userIDs {
+			errs.id("split.shares", userID)
+			errs.intRange("split.shares."+userID, split.Shares[userID], 0, MaxCreditValue)
+		}
+	} else if len(split.Shares) != 0 {
+		errs.add("split.shares", "is only used by the weighted mode")
+	}
+	if split.Remainder != "" {
+		errs.oneOf("split.remainder", split.Remainder, RemainderCreator, RemainderDistribute)
+		if split.Mode == SplitFixed || split.Mode == SplitWinnerTakesAll {
+			errs.add("split.remainder", "is only used by the equal and weighted modes")
+		}
+	}
+	errs.intRange("split.maxPerPayee", split.MaxPerPayee, 0, MaxCreditValue)
+}
+
+//Helper: policy of a stored ticket
+//tickets from before the policy language may hold free text, it is read as no rules
+func ticketPolicy(ticket Ticket) TicketPolicy {
+	var policy TicketPolicy
+	if strings.TrimSpace(ticket.Policy) == "" {
+		return policy
+	}
+	err := json.Unmarshal([]byte(ticket.Policy), &policy)
+	if err != nil {
+		logger.Info("ticketPolicy: Policy of ticket " + ticket.TicketID + " is not a policy object, ignored")
+		return TicketPolicy{}
+	}
+	return policy
+}
+
+//Helper: check the policy of a ticket in TicketCreate and TicketUpdate, field names are prefixed with Ticket_Policy
+func checkTicketPolicy(stub shim.ChaincodeStubInterface, ticket Ticket) error {
+	policy, errs := lintPolicy(stub, ticket.Policy)
+	if len(errs) == 0 && policy.Split != nil && policy.Split.Mode == SplitFixed && ticket.MaxAssignees > 0 &&
+		int64(policy.Split.Amount)*int64(ticket.MaxAssignees) > int64(ticket.Value) {
+		errs.add("split.amount", "times Ticket_MaxAssignees exceeds Ticket_Value")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	var prefixed ValidationErrors
+	for _, fieldError := range errs {
+		field := "Ticket_Policy"
+		if fieldError.Field != "" {
+			field += "." + fieldError.Field
+		}
+		prefixed.add(field, fieldError.Message)
+	}
+	return prefixed
+}
+
+//Helper: tickets a participant has been credited for, grants without a ticket do not count
+func completedTicketCount(credit Credit) int {
+	count := 0
+	for _, ticketID := range credit.TicketIDs {
+		if ticketID != creditAddNoTicket && ticketID != "" {
+			count++
+		}
+	}
+	return count
+}
+
+//Helper: whether a participant may apply to a ticket under its policy
+func checkEligibility(stub shim.ChaincodeStubInterface, ticket Ticket, userID string) error {
+	policy := ticketPolicy(ticket)
+
+	if Is_Inarray(policy.ExcludedCreators, userID) {
+		return forbiddenError("checkEligibility: " + userID + " is excluded from ticket " + ticket.TicketID)
+	}
+
+	if len(policy.EligibleLoBs) != 0 {
+		participant, registered, err := lookupParticipant(stub, userID)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return notFoundError("checkEligibility: Participant " + userID + " does not exist")
+		}
+		eligible := false
+		for _, lobID := range policy.EligibleLoBs {
+			if lobID == participant.LoBID {
+				eligible = true
+			}
+		}
+		if !eligible {
+			return forbiddenError("checkEligibility: Ticket " + ticket.TicketID + " is not open to LoB " + strconv.Itoa(participant.LoBID))
+		}
+	}
+
+	if policy.MinCompletedTickets > 0 {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return err
+		}
+		if completed := completedTicketCount(credit); completed < policy.MinCompletedTickets {
+			return forbiddenError("checkEligibility: Ticket " + ticket.TicketID + " needs " + strconv.Itoa(policy.MinCompletedTickets) +
+				" completed tickets, " + userID + " has " + strconv.Itoa(completed))
+		}
+	}
+	return nil
+}
+
+//Helper: credit paid to each awarded user under the split rule of a policy
+//share is the equal share of the escrow, the rule can only lower it
+func policyPayout(policy TicketPolicy, share int) int {
+	if policy.Split != nil && policy.Split.MaxPerPayee > 0 && share > policy.Split.MaxPerPayee {
+		return policy.Split.MaxPerPayee
+	}
+	return share
+}
+
+//Query Route: PolicyValidate
+//lints a policy before it is put on a ticket, the result lists every problem found
+func (rdg *SmartContract) PolicyValidate(stub shim.ChaincodeStubInterface, text string) peer.Response {
+	policy, errs := lintPolicy(stub, text)
+	result := PolicyValidation{Valid: len(errs) == 0, Errors: []FieldError(errs)}
+	if result.Errors == nil {
+		result.Errors = []FieldError{}
+	}
+	if result.Valid {
+		result.Policy = &policy
+	}
+	return successResponse("PolicyValidate", result)
+}
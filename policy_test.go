@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	stub := newTestStub(t)
+	cases := []struct {
+		policy string
+		fields []string
+	}{
+		{`{"eligibleLoBs":[1,2],"minCompletedTickets":3,"excludedCreators":["i000001"],"split":{"mode":"equal","maxPerPayee":50}}`, []string{}},
+		{`{"split":{"mode":"weighted","shares":{"i000002":3,"i000003":1},"remainder":"distribute"}}`, []string{}},
+		{`{"eligibleLoBs":[99],"minCompletedTickets":-1}`, []string{"eligibleLoBs[0]", "minCompletedTickets"}},
+		{`{"split":{"mode":"fixed"}}`, []string{"split.amount"}},
+		{`{"split":{"mode":"weighted","amount":5}}`, []string{"split.amount", "split.shares"}},
+		{`{"split":{"mode":"winnerTakesAll","remainder":"distribute"}}`, []string{"split.remainder"}},
+		{`{"split":{"mode":"random"}}`, []string{"split.mode"}},
+		{`{"eligible":[1]}`, []string{"eligible"}},
+		{`only HANA`, []string{""}},
+	}
+	for _, c := range cases {
+		var result PolicyValidation
+		stub.mustInvoke(&result, "PolicyValidate", c.policy)
+		if fields := detailFields(result.Errors); !equalStrings(fields, c.fields) || result.Valid != (len(c.fields) == 0) {
+			t.Errorf("%s: expected %v, got %+v", c.policy, c.fields, result)
+		}
+		if result.Valid && result.Policy == nil {
+			t.Errorf("%s: parsed policy missing", c.policy)
+		}
+	}
+}
+
+func TestTicketPolicyChecked(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+
+	response := stub.expectError(ErrCodeBadRequest, "TicketCreate", toJSON(t, map[string]interface{}{
+		"Ticket_Title": "t", "Ticket_Type": P1, "Ticket_Value": 0, "Ticket_UserID": "i000001", "Ticket_Policy": `{"eligibleLoBs":[99]}`}))
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Policy.eligibleLoBs[0]"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+	stub.grant("i000001", 10)
+	stub.asUser("i000001")
+	response = stub.expectError(ErrCodeBadRequest, "TicketCreate", toJSON(t, map[string]interface{}{
+		"Ticket_Title": "t", "Ticket_Type": P1, "Ticket_Value": 10, "Ticket_UserID": "i000001", "Ticket_MaxAssignees": 3,
+		"Ticket_Policy": `{"split":{"mode":"fixed","amount":5}}`}))
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Policy.split.amount"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+}
+
+func TestPolicyEligibility(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", SMB)
+	stub.addUser("i000004", HANA)
+	stub.grant("i000001", 10)
+	ticket := stub.createTicket("i000001", 0, map[string]interface{}{
+		"Ticket_Policy": `{"eligibleLoBs":[1],"minCompletedTickets":1,"excludedCreators":["i000004"]}`})
+
+	stub.asUser("i000004")
+	stub.expectError(ErrCodeForbidden, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000004"}))
+	stub.asUser("i000003")
+	stub.expectError(ErrCodeForbidden, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000003"}))
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "OrderCreate", toJSON(t, Order{TicketID: ticket.TicketID, UserID: "i000002"}))
+
+	// a credited ticket makes the applicant eligible
+	stub.completeTicket(stub.createTicket("i000001", 10, nil), "i000002")
+	stub.apply(ticket.TicketID, "i000002")
+	if order := stub.order(ticket.TicketID, "i000002"); order.Status != OrderApplied {
+		t.Errorf("unexpected order %+v", order)
+	}
+}
+
+func TestPolicyMaxPerPayee(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 100)
+	ticket := stub.createTicket("i000001", 100, map[string]interface{}{"Ticket_Policy": `{"split":{"maxPerPayee":30}}`})
+
+	stub.completeTicket(ticket, "i000002")
+	if value := stub.credit("i000002").Value; value != 30 {
+		t.Errorf("assignee: expected 30, got %d", value)
+	}
+	if value := stub.credit("i000001").Value; value != 70 {
+		t.Errorf("creator: expected the rest of 70 back, got %d", value)
+	}
+	if escrow := stub.escrow(ticket.TicketID); escrow.Remaining != 0 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+}
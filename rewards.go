@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Stock of a reward that never runs out
+const UnlimitedStock = -1
+
+//Maximum length of a reward description
+const MaxDescriptionLength = 1024
+
+//Maximum number of items of one redemption
+const MaxRedeemQuantity = 100
+
+//Redemption status
+//Pending  ->  Fulfilled
+//         ->  Refunded
+const (
+	RedemptionPending = iota
+	RedemptionFulfilled
+	RedemptionRefunded
+)
+
+//RewardItem information, an entry of the reward catalog, Reward~<itemID>
+//ItemID:        XXXXXX
+//Name:          shown in the catalog
+//Description:   free text
+//Cost:          credit debited per item
+//Stock:         items left, UnlimitedStock if it never runs out
+//LoBIDs:        LoBs whose participants may redeem the item, all LoBs if empty
+//Archived:      archived items stay readable but cannot be redeemed
+type RewardItem struct {
+	ItemID      string `json:"Reward_ItemID"`
+	Name        string `json:"Reward_Name"`
+	Description string `json:"Reward_Description"`
+
+	Cost     int   `json:"Reward_Cost"`
+	Stock    int   `json:"Reward_Stock"`
+	LoBIDs   []int `json:"Reward_LoBIDs"`
+	Archived bool  `json:"Reward_Archived"`
+
+	UpdatedAt time.Time `json:"Reward_UpdatedAt"`
+}
+
+//RewardRequest - input of RewardCreate and RewardUpdate, RewardUpdate replaces the whole item
+type RewardRequest struct {
+	ItemID      string `json:"itemID"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Cost        int    `json:"cost"`
+	Stock       int    `json:"stock"`
+	LoBIDs      []int  `json:"lobIds"`
+	Archived    bool   `json:"archived"`
+}
+
+//Redemption information, Redemption~<redemptionID> and UserRedemption~<userID>~<redemptionID>
+//RedemptionID:   transaction ID of the redemption
+//UserID:         iXXXXXX
+//ItemID:         reward redeemed
+//Quantity:       number of items
+//Cost:           credit debited for all items
+//Status:         Pending, Fulfilled or Refunded
+//HandledBy:      enrollment ID of the admin who fulfilled or refunded it
+type Redemption struct {
+	RedemptionID string `json:"Redemption_RedemptionID"`
+	UserID       string `json:"Redemption_UserID"`
+	ItemID       string `json:"Redemption_ItemID"`
+
+	Quantity  int    `json:"Redemption_Quantity"`
+	Cost      int    `json:"Redemption_Cost"`
+	Status    int    `json:"Redemption_Status"`
+	HandledBy string `json:"Redemption_HandledBy,omitempty"`
+
+	CreatedAt time.Time `json:"Redemption_CreatedAt"`
+	UpdatedAt time.Time `json:"Redemption_UpdatedAt"`
+}
+
+//RedeemRequest - input of Redeem, Quantity defaults to 1
+type RedeemRequest struct {
+	UserID   string `json:"userID"`
+	ItemID   string `json:"itemID"`
+	Quantity int    `json:"quantity"`
+}
+
+func rewardKey(stub shim.ChaincodeStubInterface, itemID string) (string, error) {
+	return stub.CreateCompositeKey("Reward", []string{itemID})
+}
+
+func redemptionKey(stub shim.ChaincodeStubInterface, redemptionID string) (string, error) {
+	return stub.CreateCompositeKey("Redemption", []string{redemptionID})
+}
+
+func retrieveReward(stub shim.ChaincodeStubInterface, itemID string) (RewardItem, bool, error) {
+	var item RewardItem
+	key, err := rewardKey(stub, itemID)
+	if err != nil {
+		return item, false, wrapError("retrieveReward", err)
+	}
+	itemAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return item, false, errors.New("retrieveReward: Error getting reward " + itemID)
+	}
+	if itemAsBytes == nil {
+		return item, false, nil
+	}
+	err = json.Unmarshal(itemAsBytes, &item)
+	if err != nil {
+		return item, false, errors.New("retrieveReward: Corrupt reward record " + string(itemAsBytes))
+	}
+	return item, true, nil
+}
+
+func saveReward(stub shim.ChaincodeStubInterface, item RewardItem) ([]byte, error) {
+	itemAsBytes, err := json.Marshal(item)
+	if err != nil {
+		return nil, errors.New("saveReward: Error marshalling reward " + item.ItemID)
+	}
+	key, err := rewardKey(stub, item.ItemID)
+	if err != nil {
+		return nil, wrapError("saveReward", err)
+	}
+	err = stub.PutState(key, itemAsBytes)
+	if err != nil {
+		return nil, errors.New("saveReward: Error storing reward " + item.ItemID)
+	}
+	return itemAsBytes, nil
+}
+
+func retrieveRedemption(stub shim.ChaincodeStubInterface, redemptionID string) (Redemption, error) {
+	var redemption Redemption
+	key, err := redemptionKey(stub, redemptionID)
+	if err != nil {
+		return redemption, wrapError("retrieveRedemption", err)
+	}
+	redemptionAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return redemption, errors.New("retrieveRedemption: Error getting redemption " + redemptionID)
+	}
+	if redemptionAsBytes == nil {
+		return redemption, notFoundError("retrieveRedemption: Redemption " + redemptionID + " does not exist")
+	}
+	err = json.Unmarshal(redemptionAsBytes, &redemption)
+	if err != nil {
+		return redemption, errors.New("retrieveRedemption: Corrupt redemption record " + string(redemptionAsBytes))
+	}
+	return redemption, nil
+}
+
+//Helper: save a redemption and its entry in the redemptions of the user
+func saveRedemption(stub shim.ChaincodeStubInterface, redemption Redemption) ([]byte, error) {
+	redemptionAsBytes, err := json.Marshal(redemption)
+	if err != nil {
+		return nil, errors.New("saveRedemption: Error marshalling redemption " + redemption.RedemptionID)
+	}
+	key, err := redemptionKey(stub, redemption.RedemptionID)
+	if err != nil {
+		return nil, wrapError("saveRedemption", err)
+	}
+	err = stub.PutState(key, redemptionAsBytes)
+	if err != nil {
+		return nil, errors.New("saveRedemption: Error storing redemption " + redemption.RedemptionID)
+	}
+	userKey, err := stub.CreateCompositeKey("UserRedemption", []string{redemption.UserID, redemption.RedemptionID})
+	if err != nil {
+		return nil, wrapError("saveRedemption", err)
+	}
+	err = stub.PutState(userKey, []byte(redemption.RedemptionID))
+	if err != nil {
+		return nil, errors.New("saveRedemption: Error storing redemption index of " + redemption.UserID)
+	}
+	return redemptionAsBytes, nil
+}
+
+//Helper: redemptions of a user, oldest transaction ID first
+func listRedemptions(stub shim.ChaincodeStubInterface, userID string) ([]Redemption, error) {
+	var redemptions []Redemption
+	redemptionIterator, err := stub.GetStateByPartialCompositeKey("UserRedemption", []string{userID})
+	if err != nil {
+		return nil, wrapError("listRedemptions", err)
+	}
+	defer redemptionIterator.Close()
+
+	for redemptionIterator.HasNext() {
+		queryResponse, err := redemptionIterator.Next()
+		if err != nil {
+			return nil, wrapError("listRedemptions", err)
+		}
+		redemption, err := retrieveRedemption(stub, string(queryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		redemptions = append(redemptions, redemption)
+	}
+	return redemptions, nil
+}
+
+//Helper: delete every redemption of a user, used when a participant is purged
+func deleteRedemptions(stub shim.ChaincodeStubInterface, userID string) (int, error) {
+	redemptions, err := listRedemptions(stub, userID)
+	if err != nil {
+		return 0, err
+	}
+	for _, redemption := range redemptions {
+		key, err := redemptionKey(stub, redemption.RedemptionID)
+		if err != nil {
+			return 0, wrapError("deleteRedemptions", err)
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return 0, errors.New("deleteRedemptions: Error deleting redemption " + redemption.RedemptionID)
+		}
+	}
+	_, err = deleteByPartialKey(stub, "UserRedemption", []string{userID})
+	if err != nil {
+		return 0, err
+	}
+	return len(redemptions), nil
+}
+
+//Helper: whether participants of a LoB may redeem an item
+func rewardAvailableTo(item RewardItem, lobID int) bool {
+	if len(item.LoBIDs) == 0 {
+		return true
+	}
+	for _, id := range item.LoBIDs {
+		if id == lobID {
+			return true
+		}
+	}
+	return false
+}
+
+//Helper: reward item from a request, the LoBs it is offered in have to exist
+func rewardFromRequest(stub shim.ChaincodeStubInterface, request RewardRequest) (RewardItem, error) {
+	for _, lobID := range request.LoBIDs {
+		_, err := retrieveLoB(stub, lobID)
+		if err != nil {
+			return RewardItem{}, err
+		}
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return RewardItem{}, err
+	}
+	return RewardItem{
+		ItemID:      request.ItemID,
+		Name:        strings.TrimSpace(request.Name),
+		Description: request.Description,
+		Cost:        request.Cost,
+		Stock:       request.Stock,
+		LoBIDs:      request.LoBIDs,
+		Archived:    request.Archived,
+		UpdatedAt:   txTime}, nil
+}
+
+//Invoke Route: RewardCreate
+func (rdg *SmartContract) RewardCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request RewardRequest
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateReward(request)
+	}, "itemID", "name", "cost", "stock")
+	if err != nil {
+		return validationResponse("RewardCreate", err)
+	}
+
+	_, exists, err := retrieveReward(stub, request.ItemID)
+	if err != nil {
+		return routeError(err)
+	}
+	if exists {
+		return errorResponse(ErrCodeConflict, "RewardCreate: Reward "+request.ItemID+" already exists")
+	}
+	item, err := rewardFromRequest(stub, request)
+	if err != nil {
+		return routeError(err)
+	}
+	itemAsBytes, err := saveReward(stub, item)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(itemAsBytes)
+}
+
+//Invoke Route: RewardUpdate
+//replaces cost, stock, availability and description of an item, archived items can be restored
+func (rdg *SmartContract) RewardUpdate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request RewardRequest
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateReward(request)
+	}, "itemID", "name", "cost", "stock")
+	if err != nil {
+		return validationResponse("RewardUpdate", err)
+	}
+
+	_, exists, err := retrieveReward(stub, request.ItemID)
+	if err != nil {
+		return routeError(err)
+	}
+	if !exists {
+		return errorResponse(ErrCodeNotFound, "RewardUpdate: Reward "+request.ItemID+" does not exist")
+	}
+	item, err := rewardFromRequest(stub, request)
+	if err != nil {
+		return routeError(err)
+	}
+	itemAsBytes, err := saveReward(stub, item)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(itemAsBytes)
+}
+
+//Query Route: RewardRead
+func (rdg *SmartContract) RewardRead(stub shim.ChaincodeStubInterface, itemID string) peer.Response {
+	item, exists, err := retrieveReward(stub, itemID)
+	if err != nil {
+		return routeError(err)
+	}
+	if !exists {
+		return errorResponse(ErrCodeNotFound, "RewardRead: Reward "+itemID+" does not exist")
+	}
+	return successResponse("RewardRead", item)
+}
+
+//Query Route: RewardList
+//optional arg "true" also lists archived items
+func (rdg *SmartContract) RewardList(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	includeArchived := len(args) > 0 && args[0] == "true"
+
+	rewardIterator, err := stub.GetStateByPartialCompositeKey("Reward", []string{})
+	if err != nil {
+		return routeError(wrapError("RewardList", err))
+	}
+	defer rewardIterator.Close()
+
+	items := []RewardItem{}
+	for rewardIterator.HasNext() {
+		var item RewardItem
+		queryResponse, err := rewardIterator.Next()
+		if err != nil {
+			return routeError(wrapError("RewardList", err))
+		}
+		err = json.Unmarshal(queryResponse.Value, &item)
+		if err != nil {
+			return shim.Error("RewardList: Corrupt reward record " + string(queryResponse.Value))
+		}
+		if item.Archived && !includeArchived {
+			continue
+		}
+		items = append(items, item)
+	}
+	return successResponse("RewardList", items)
+}
+
+//Invoke Route: Redeem
+//debits the credit of the participant and takes the items from the stock in one transaction,
+//concurrent redemptions of the last items conflict on the reward record and only one of them commits
+func (rdg *SmartContract) Redeem(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request RedeemRequest
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateRedeem(request)
+	}, "userID", "itemID")
+	if err != nil {
+		return validationResponse("Redeem", err)
+	}
+	if request.Quantity == 0 {
+		request.Quantity = 1
+	}
+
+	item, exists, err := retrieveReward(stub, request.ItemID)
+	if err != nil {
+		return routeError(err)
+	}
+	if !exists {
+		return errorResponse(ErrCodeNotFound, "Redeem: Reward "+request.ItemID+" does not exist")
+	}
+	if item.Archived {
+		return errorResponse(ErrCodeConflict, "Redeem: Reward "+request.ItemID+" is archived")
+	}
+	if item.Stock != UnlimitedStock && item.Stock < request.Quantity {
+		return errorResponse(ErrCodeConflict, "Redeem: Only "+strconv.Itoa(item.Stock)+" of reward "+request.ItemID+" left")
+	}
+
+	participant, registered, err := lookupParticipant(stub, request.UserID)
+	if err != nil {
+		return routeError(err)
+	}
+	if !registered {
+		return errorResponse(ErrCodeNotFound, "Redeem: Participant "+request.UserID+" does not exist")
+	}
+	if !rewardAvailableTo(item, participant.LoBID) {
+		return errorResponse(ErrCodeForbidden, "Redeem: Reward "+request.ItemID+" is not offered to LoB "+strconv.Itoa(participant.LoBID))
+	}
+
+	cost := item.Cost * request.Quantity
+	credit, err := retrieveSingleCredit(stub, request.UserID)
+	if err != nil {
+		return routeError(err)
+	}
+	if credit.Value < cost {
+		return errorResponse(ErrCodeInsufficientCredit, "Redeem: Insufficient credit of "+request.UserID+" to redeem "+strconv.Itoa(cost))
+	}
+
+	// ==== Debit the credit, LoB totals count awarded credit and are left alone ====
+	credit.Value -= cost
+	_, err = saveCredit(stub, credit)
+	if err != nil {
+		return routeError(err)
+	}
+	redemptionID := stub.GetTxID()
+	err = journalCredit(stub, credit, JournalEntry{Amount: -cost, Reason: ReasonRedemption, RedemptionID: redemptionID})
+	if err != nil {
+		return routeError(err)
+	}
+
+	if item.Stock != UnlimitedStock {
+		item.Stock -= request.Quantity
+		_, err = saveReward(stub, item)
+		if err != nil {
+			return routeError(err)
+		}
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	redemption := Redemption{
+		RedemptionID: redemptionID,
+		UserID:       request.UserID,
+		ItemID:       request.ItemID,
+		Quantity:     request.Quantity,
+		Cost:         cost,
+		Status:       RedemptionPending,
+		CreatedAt:    txTime,
+		UpdatedAt:    txTime}
+	redemptionAsBytes, err := saveRedemption(stub, redemption)
+	if err != nil {
+		return routeError(err)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventRewardRedeemed, UserID: request.UserID, Value: cost})
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(redemptionAsBytes)
+}
+
+//Helper: close a pending redemption, a refund gives back the credit and the items
+func closeRedemption(stub shim.ChaincodeStubInterface, redemptionID string, status int) ([]byte, error) {
+	redemption, err := retrieveRedemption(stub, redemptionID)
+	if err != nil {
+		return nil, err
+	}
+	if redemption.Status != RedemptionPending {
+		return nil, conflictError("closeRedemption: Redemption " + redemptionID + " is already closed")
+	}
+
+	if status == RedemptionRefunded {
+		credit, err := retrieveSingleCredit(stub, redemption.UserID)
+		if err != nil {
+			return nil, err
+		}
+		credit.Value += redemption.Cost
+		_, err = saveCredit(stub, credit)
+		if err != nil {
+			return nil, err
+		}
+		err = journalCredit(stub, credit, JournalEntry{Amount: redemption.Cost, Reason: ReasonRedemption, RedemptionID: redemptionID, IssuedBy: callerEnrollmentID(stub)})
+		if err != nil {
+			return nil, err
+		}
+
+		item, exists, err := retrieveReward(stub, redemption.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		if exists && item.Stock != UnlimitedStock {
+			item.Stock += redemption.Quantity
+			_, err = saveReward(stub, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := redemption.Status
+	redemption.Status = status
+	redemption.HandledBy = callerEnrollmentID(stub)
+	redemption.UpdatedAt = txTime
+	redemptionAsBytes, err := saveRedemption(stub, redemption)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventRedemptionStatusChanged, UserID: redemption.UserID, OldStatus: eventStatus(oldStatus), NewStatus: eventStatus(status), Value: redemption.Cost})
+	if err != nil {
+		return nil, err
+	}
+	return redemptionAsBytes, nil
+}
+
+//Invoke Route: RedemptionFulfil
+func (rdg *SmartContract) RedemptionFulfil(stub shim.ChaincodeStubInterface, redemptionID string) peer.Response {
+	redemptionAsBytes, err := closeRedemption(stub, redemptionID, RedemptionFulfilled)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(redemptionAsBytes)
+}
+
+//Invoke Route: RedemptionRefund
+func (rdg *SmartContract) RedemptionRefund(stub shim.ChaincodeStubInterface, redemptionID string) peer.Response {
+	redemptionAsBytes, err := closeRedemption(stub, redemptionID, RedemptionRefunded)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(redemptionAsBytes)
+}
+
+//Query Route: RedemptionRead
+func (rdg *SmartContract) RedemptionRead(stub shim.ChaincodeStubInterface, redemptionID string) peer.Response {
+	redemption, err := retrieveRedemption(stub, redemptionID)
+	if err != nil {
+		return routeError(err)
+	}
+	return successResponse("RedemptionRead", redemption)
+}
+
+//Query Route: RedemptionList
+//lists the redemptions of a participant
+func (rdg *SmartContract) RedemptionList(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	redemptions, err := listRedemptions(stub, userID)
+	if err != nil {
+		return routeError(err)
+	}
+	if redemptions == nil {
+		redemptions = []Redemption{}
+	}
+	return successResponse("RedemptionList", redemptions)
+}
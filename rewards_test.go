@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+)
+
+//Helper: reward item of the catalog
+func (stub *testStub) reward(itemID string) RewardItem {
+	stub.t.Helper()
+	var item RewardItem
+	stub.mustInvoke(&item, "RewardRead", itemID)
+	return item
+}
+
+func TestRedeemAndClose(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.grant("i000001", 100)
+	stub.asAdmin()
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"mug","name":"Mug","cost":15,"stock":5,"lobIds":[1]}`)
+
+	stub.asUser("i000001")
+	var redemption Redemption
+	stub.mustInvoke(&redemption, "Redeem", `{"userID":"i000001","itemID":"mug","quantity":2}`)
+	if redemption.RedemptionID != stub.LastTxID || redemption.Cost != 30 || redemption.Status != RedemptionPending {
+		t.Errorf("unexpected redemption %+v", redemption)
+	}
+	if events := stub.events(); len(events) != 1 || events[0].Type != EventRewardRedeemed || events[0].Value != 30 {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if value := stub.credit("i000001").Value; value != 70 {
+		t.Errorf("credit: expected 70, got %d", value)
+	}
+	if stock := stub.reward("mug").Stock; stock != 3 {
+		t.Errorf("stock: expected 3, got %d", stock)
+	}
+
+	// a refund gives back the credit and the items
+	stub.asAdmin()
+	var refunded Redemption
+	stub.mustInvoke(&refunded, "RedemptionRefund", redemption.RedemptionID)
+	if refunded.Status != RedemptionRefunded || refunded.HandledBy != testAdminID {
+		t.Errorf("unexpected refund %+v", refunded)
+	}
+	if value := stub.credit("i000001").Value; value != 100 {
+		t.Errorf("credit after refund: expected 100, got %d", value)
+	}
+	if stock := stub.reward("mug").Stock; stock != 5 {
+		t.Errorf("stock after refund: expected 5, got %d", stock)
+	}
+
+	stub.asUser("i000001")
+	stub.mustInvoke(&redemption, "Redeem", `{"userID":"i000001","itemID":"mug"}`)
+	stub.asAdmin()
+	var fulfilled Redemption
+	stub.mustInvoke(&fulfilled, "RedemptionFulfil", redemption.RedemptionID)
+	if fulfilled.Status != RedemptionFulfilled || fulfilled.Quantity != 1 {
+		t.Errorf("unexpected fulfilment %+v", fulfilled)
+	}
+	// a closed redemption stays closed
+	stub.expectError(ErrCodeConflict, "RedemptionRefund", redemption.RedemptionID)
+	stub.expectError(ErrCodeConflict, "RedemptionFulfil", redemption.RedemptionID)
+
+	stub.asUser("i000001")
+	var redemptions []Redemption
+	stub.mustInvoke(&redemptions, "RedemptionList", "i000001")
+	if len(redemptions) != 2 {
+		t.Errorf("unexpected redemptions %+v", redemptions)
+	}
+}
+
+func TestRewardCatalog(t *testing.T) {
+	stub := newTestStub(t)
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"mug","name":"Mug","cost":15,"stock":-1}`)
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"cap","name":"Cap","cost":5,"stock":0}`)
+	stub.mustInvoke(nil, "RewardUpdate", `{"itemID":"cap","name":"Cap","cost":5,"stock":0,"archived":true}`)
+
+	var active, all []RewardItem
+	stub.mustInvoke(&active, "RewardList")
+	stub.mustInvoke(&all, "RewardList", "true")
+	if len(active) != 1 || active[0].ItemID != "mug" || len(all) != 2 {
+		t.Errorf("unexpected catalog %+v %+v", active, all)
+	}
+
+	stub.expectError(ErrCodeConflict, "RewardCreate", `{"itemID":"mug","name":"Mug","cost":15,"stock":1}`)
+	stub.expectError(ErrCodeNotFound, "RewardUpdate", `{"itemID":"hat","name":"Hat","cost":15,"stock":1}`)
+	stub.expectError(ErrCodeNotFound, "RewardCreate", `{"itemID":"hat","name":"Hat","cost":15,"stock":1,"lobIds":[99]}`)
+	stub.expectError(ErrCodeBadRequest, "RewardCreate", `{"itemID":"hat","name":"Hat","cost":0,"stock":-2}`)
+	stub.expectError(ErrCodeNotFound, "RewardRead", "hat")
+
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "RewardCreate", `{"itemID":"hat","name":"Hat","cost":15,"stock":1}`)
+}
+
+func TestRedeemRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 20)
+	stub.asAdmin()
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"mug","name":"Mug","cost":15,"stock":1}`)
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"smb","name":"SMB shirt","cost":1,"stock":-1,"lobIds":[2]}`)
+	stub.mustInvoke(nil, "RewardCreate", `{"itemID":"old","name":"Old","cost":1,"stock":-1,"archived":true}`)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeConflict, "Redeem", `{"userID":"i000001","itemID":"mug","quantity":2}`)
+	stub.expectError(ErrCodeConflict, "Redeem", `{"userID":"i000001","itemID":"old"}`)
+	stub.expectError(ErrCodeForbidden, "Redeem", `{"userID":"i000001","itemID":"smb"}`)
+	stub.expectError(ErrCodeNotFound, "Redeem", `{"userID":"i000001","itemID":"hat"}`)
+	stub.expectError(ErrCodeBadRequest, "Redeem", `{"userID":"i000001","itemID":"mug","quantity":101}`)
+	stub.expectError(ErrCodeForbidden, "Redeem", `{"userID":"i000002","itemID":"mug"}`)
+	stub.expectError(ErrCodeForbidden, "RedemptionFulfil", "tx1")
+
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeInsufficientCredit, "Redeem", `{"userID":"i000002","itemID":"mug"}`)
+	if stock := stub.reward("mug").Stock; stock != 1 {
+		t.Errorf("rejected redemptions changed the stock to %d", stock)
+	}
+
+	stub.asAdmin()
+	stub.expectError(ErrCodeNotFound, "RedemptionRefund", "unknown")
+	stub.expectError(ErrCodeNotFound, "RedemptionRead", "unknown")
+}
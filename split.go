@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+)
+
+//Helper: split a reward between payees in proportion to their weights
+//every payee gets the integer part of its share, with RemainderDistribute the credits left by rounding
+//go one each to the payees with the largest fractional parts, ties in UserID order
+func splitWeighted(total int, payees []string, weights map[string]int, remainder string) map[string]int {
+	payouts := map[string]int{}
+	sum := int64(0)
+	for _, userID := range payees {
+		sum += int64(weights[userID])
+	}
+	if sum == 0 {
+		return payouts
+	}
+
+	fractions := map[string]int64{}
+	paid := 0
+	for _, userID := range payees {
+		share := int64(total) * int64(weights[userID])
+		payouts[userID] = int(share / sum)
+		fractions[userID] = share % sum
+		paid += payouts[userID]
+	}
+	if remainder != RemainderDistribute {
+		return payouts
+	}
+
+	order := make([]string, 0, len(payees))
+	for _, userID := range payees {
+		if weights[userID] > 0 {
+			order = append(order, userID)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return fractions[order[i]] > fractions[order[j]]
+	})
+	for i := 0; paid < total && len(order) != 0; i = (i + 1) % len(order) {
+		payouts[order[i]]++
+		paid++
+	}
+	return payouts
+}
+
+//Helper: what each payee of a ticket gets under a split rule
+//payees are the assignees holding a slot in UserID order, total is the whole reward
+func splitPayouts(rule SplitRule, total int, payees []string) map[string]int {
+	switch rule.Mode {
+	case SplitFixed:
+		payouts := map[string]int{}
+		left := total
+		for _, userID := range payees {
+			payouts[userID] = Min(rule.Amount, left)
+			left -= payouts[userID]
+		}
+		return payouts
+	case SplitWeighted:
+		return splitWeighted(total, payees, rule.Shares, rule.Remainder)
+	default:
+		weights := map[string]int{}
+		for _, userID := range payees {
+			weights[userID] = 1
+		}
+		return splitWeighted(total, payees, weights, rule.Remainder)
+	}
+}
+
+//Helper: payouts of the users awarded by one OrderUpdate
+//winner takes all pays the whole reward to the first user ever awarded, the first of awarded if none was paid yet,
+//the other modes split the reward between every assignee holding a slot, awarded or not,
+//so users awarded one after the other get the same shares as users awarded together
+func planPayouts(policy TicketPolicy, escrow Escrow, payees []string, awarded []string) map[string]int {
+	rule := SplitRule{Mode: SplitEqual}
+	if policy.Split != nil {
+		rule = *policy.Split
+	}
+
+	var payouts map[string]int
+	if rule.Mode == SplitWinnerTakesAll {
+		payouts = map[string]int{}
+		if len(escrow.Payouts) == 0 && len(awarded) != 0 {
+			payouts[awarded[0]] = escrow.Remaining
+		}
+	} else {
+		payouts = splitPayouts(rule, escrow.Amount, payees)
+	}
+
+	for userID, value := range payouts {
+		payouts[userID] = policyPayout(policy, value)
+	}
+	return payouts
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPayouts(t *testing.T) {
+	payees := []string{"i000002", "i000003", "i000004"}
+	cases := []struct {
+		name     string
+		rule     SplitRule
+		total    int
+		payees   []string
+		expected map[string]int
+	}{
+		{"equal", SplitRule{Mode: SplitEqual}, 100, payees, map[string]int{"i000002": 33, "i000003": 33, "i000004": 33}},
+		{"equal distributed", SplitRule{Remainder: RemainderDistribute}, 100, payees, map[string]int{"i000002": 34, "i000003": 33, "i000004": 33}},
+		{"fixed", SplitRule{Mode: SplitFixed, Amount: 40}, 100, payees, map[string]int{"i000002": 40, "i000003": 40, "i000004": 20}},
+		{"weighted", SplitRule{Mode: SplitWeighted, Shares: map[string]int{"i000002": 3, "i000003": 1}}, 10, payees[:2], map[string]int{"i000002": 7, "i000003": 2}},
+		{"weighted distributed", SplitRule{Mode: SplitWeighted, Shares: map[string]int{"i000002": 3, "i000003": 1}, Remainder: RemainderDistribute}, 10, payees[:2], map[string]int{"i000002": 8, "i000003": 2}},
+		{"weighted without shares", SplitRule{Mode: SplitWeighted, Shares: map[string]int{"i000009": 1}}, 10, payees, map[string]int{}},
+		{"nobody", SplitRule{Mode: SplitEqual}, 10, []string{}, map[string]int{}},
+	}
+	for _, c := range cases {
+		if payouts := splitPayouts(c.rule, c.total, c.payees); !reflect.DeepEqual(payouts, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, payouts)
+		}
+	}
+}
+
+func TestPlanPayoutsWinnerTakesAll(t *testing.T) {
+	policy := TicketPolicy{Split: &SplitRule{Mode: SplitWinnerTakesAll}}
+	escrow := Escrow{Amount: 50, Remaining: 50, Payouts: map[string]int{}}
+	payees := []string{"i000002", "i000003"}
+	if payouts := planPayouts(policy, escrow, payees, []string{"i000003", "i000002"}); !reflect.DeepEqual(payouts, map[string]int{"i000003": 50}) {
+		t.Errorf("unexpected payouts %v", payouts)
+	}
+	escrow.Payouts["i000003"] = 50
+	escrow.Remaining = 0
+	if payouts := planPayouts(policy, escrow, payees, []string{"i000002"}); len(payouts) != 0 {
+		t.Errorf("second award paid %v", payouts)
+	}
+}
+
+func TestAwardSplitsReward(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	for _, userID := range []string{"i000002", "i000003", "i000004"} {
+		stub.addUser(userID, HANA)
+	}
+	stub.grant("i000001", 100)
+	ticket := stub.createTicket("i000001", 100, nil)
+	for _, userID := range []string{"i000002", "i000003", "i000004"} {
+		stub.apply(ticket.TicketID, userID)
+	}
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002", "i000003", "i000004"}})
+	for _, userID := range []string{"i000002", "i000003", "i000004"} {
+		stub.asUser(userID)
+		stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{userID}})
+	}
+
+	// users awarded one after the other get the shares of users awarded together
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000003"}})
+	if value := stub.credit("i000003").Value; value != 33 {
+		t.Errorf("first award: expected 33, got %d", value)
+	}
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002", "i000004"}})
+	for _, userID := range []string{"i000002", "i000004"} {
+		if value := stub.credit(userID).Value; value != 33 {
+			t.Errorf("%s: expected 33, got %d", userID, value)
+		}
+	}
+
+	// the credit left by rounding goes back to the creator
+	if value := stub.credit("i000001").Value; value != 1 {
+		t.Errorf("creator: expected 1, got %d", value)
+	}
+	if escrow := stub.escrow(ticket.TicketID); escrow.Status != EscrowReleased || escrow.Remaining != 0 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+}
+
+func TestAwardWinnerTakesAll(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.addUser("i000003", HANA)
+	stub.grant("i000001", 50)
+	ticket := stub.createTicket("i000001", 50, map[string]interface{}{"Ticket_Policy": `{"split":{"mode":"winnerTakesAll"}}`})
+	stub.completeTicket(ticket, "i000003", "i000002")
+
+	if value := stub.credit("i000003").Value; value != 50 {
+		t.Errorf("winner: expected 50, got %d", value)
+	}
+	if value := stub.credit("i000002").Value; value != 0 {
+		t.Errorf("second: expected 0, got %d", value)
+	}
+	if value := stub.credit("i000001").Value; value != 0 {
+		t.Errorf("creator: expected 0, got %d", value)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTicketIDFromTransaction(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+
+	first := stub.createTicket("i000001", 0, nil)
+	firstTx, firstTxNumber := stub.LastTxID, stub.tx
+	second := stub.createTicket("i000001", 0, nil)
+	if first.TicketID == second.TicketID || len(first.TicketID) != 16 {
+		t.Errorf("unexpected IDs %s %s", first.TicketID, second.TicketID)
+	}
+	if stub.State["TICKETID"] != nil {
+		t.Error("TICKETID written by TicketCreate")
+	}
+
+	stub.startTx()
+	stub.TxID = firstTx
+	if id := newTicketID(stub); id != first.TicketID {
+		t.Errorf("expected %s for %s, got %s", first.TicketID, firstTx, id)
+	}
+	stub.endTx(false)
+
+	// a transaction ID is used once, a replayed one finds its ticket
+	stub.tx = firstTxNumber - 1
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeConflict, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":1,"Ticket_Value":0,"Ticket_UserID":"i000001"}`)
+}
+
+func TestNumberedTicketsKept(t *testing.T) {
+	stub := newTestStubWithState(t, map[string]string{
+		"readingIDIndex": `{"UserIDs":[]}`,
+		"TICKETID":       "2",
+		"2":              `{"Ticket_TicketID":"2","Ticket_Status":1,"Ticket_Title":"Numbered","Ticket_Type":0,"Ticket_Value":0,"Ticket_UserID":"i000001"}`,
+	})
+	stub.addUser("i000002", HANA)
+	ticket := stub.createTicket("i000002", 0, nil)
+	if string(stub.State["TICKETID"]) != "2" {
+		t.Errorf("TICKETID changed to %s", stub.State["TICKETID"])
+	}
+
+	var tickets []Ticket
+	stub.mustInvoke(&tickets, "TicketRead2")
+	if len(tickets) != 2 || tickets[0].TicketID != "2" || tickets[1].TicketID != ticket.TicketID {
+		t.Errorf("unexpected tickets %+v", tickets)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Page size of TicketList when none or an invalid one is given
+const (
+	DefaultTicketPageSize = 20
+	MaxTicketPageSize     = 100
+)
+
+//Fields TicketList can sort on, each has a CouchDB index in META-INF/statedb/couchdb/indexes
+var ticketSortFields = map[string]string{
+	"deadline": "Ticket_Deadline",
+	"value":    "Ticket_Value",
+}
+
+//TicketListRequest - filters, sorting and paging of TicketList, every filter is optional
+//Status:          ticket status
+//Type:            ticket type
+//Creator:         Ticket_UserID
+//LoBID:           LoB of the creator
+//DeadlineFrom:    earliest deadline, RFC3339
+//DeadlineTo:      latest deadline, RFC3339
+//SortBy:          deadline or value
+//SortOrder:       asc (default) or desc
+//PageSize:        1..100, default 20
+//Bookmark:        bookmark returned by the previous page
+type TicketListRequest struct {
+	Status       *int   `json:"status"`
+	Type         *int   `json:"type"`
+	Creator      string `json:"creator"`
+	LoBID        *int   `json:"lobId"`
+	DeadlineFrom string `json:"deadlineFrom"`
+	DeadlineTo   string `json:"deadlineTo"`
+
+	SortBy    string `json:"sortBy"`
+	SortOrder string `json:"sortOrder"`
+
+	PageSize int32  `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+}
+
+//TicketListResponse - one page of tickets
+type TicketListResponse struct {
+	Records      []Ticket `json:"records"`
+	Bookmark     string   `json:"bookmark"`
+	FetchedCount int32    `json:"fetchedCount"`
+}
+
+//Helper: creators that belong to a LoB
+func lobMembers(stub shim.ChaincodeStubInterface, lobID int) ([]string, error) {
+	LoB_temp, err := retrieveLoB(stub, lobID)
+	if err != nil {
+		return nil, wrapError("lobMembers", err)
+	}
+	return LoB_temp.UserIDs, nil
+}
+
+//Helper: deadline as it is stored, in UTC and to the second
+//stored deadlines then marshal to RFC3339 strings of one length, which CouchDB compares in time order
+func normalizeDeadline(deadline time.Time) time.Time {
+	return deadline.UTC().Truncate(time.Second)
+}
+
+//Helper: build the CouchDB query of a TicketList request
+//returns an empty query if the filters cannot match any ticket
+func buildTicketQuery(stub shim.ChaincodeStubInterface, request TicketListRequest) (string, error) {
+	selector := map[string]interface{}{
+		"Ticket_TicketID": map[string]interface{}{"$exists": true},
+	}
+	if request.Status != nil {
+		selector["Ticket_Status"] = *request.Status
+	}
+	if request.Type != nil {
+		selector["Ticket_Type"] = *request.Type
+	}
+
+	if request.LoBID != nil {
+		members, err := lobMembers(stub, *request.LoBID)
+		if err != nil {
+			return "", err
+		}
+		if request.Creator != "" {
+			if !Is_Inarray(members, request.Creator) {
+				return "", nil
+			}
+			members = []string{request.Creator}
+		}
+		if len(members) == 0 {
+			return "", nil
+		}
+		selector["Ticket_UserID"] = map[string]interface{}{"$in": members}
+	} else if request.Creator != "" {
+		selector["Ticket_UserID"] = request.Creator
+	}
+
+	// deadlines are compared as strings, so the bounds take the stored form: UTC, whole seconds
+	// the lower bound is rounded up and the upper bound down, no deadline outside the request matches
+	deadline := map[string]interface{}{}
+	if request.DeadlineFrom != "" {
+		from, err := time.Parse(time.RFC3339, request.DeadlineFrom)
+		if err != nil {
+			return "", errors.New("buildTicketQuery: deadlineFrom is not RFC3339")
+		}
+		if !from.Equal(normalizeDeadline(from)) {
+			from = normalizeDeadline(from).Add(time.Second)
+		}
+		deadline["$gte"] = normalizeDeadline(from).Format(time.RFC3339)
+	}
+	if request.DeadlineTo != "" {
+		to, err := time.Parse(time.RFC3339, request.DeadlineTo)
+		if err != nil {
+			return "", errors.New("buildTicketQuery: deadlineTo is not RFC3339")
+		}
+		deadline["$lte"] = normalizeDeadline(to).Format(time.RFC3339)
+	}
+	if len(deadline) != 0 {
+		selector["Ticket_Deadline"] = deadline
+	}
+
+	query := map[string]interface{}{"selector": selector}
+	if request.SortBy != "" {
+		field, ok := ticketSortFields[request.SortBy]
+		if !ok {
+			return "", errors.New("buildTicketQuery: Cannot sort by " + request.SortBy)
+		}
+		order := "asc"
+		if request.SortOrder == "desc" {
+			order = "desc"
+		} else if request.SortOrder != "" && request.SortOrder != "asc" {
+			return "", errors.New("buildTicketQuery: sortOrder must be asc or desc")
+		}
+		// CouchDB only uses the index if the sort field is part of the selector
+		if _, ok := selector[field]; !ok {
+			selector[field] = map[string]interface{}{"$gt": nil}
+		}
+		query["sort"] = []map[string]string{{field: order}}
+	}
+
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", errors.New("buildTicketQuery: Error marshalling query")
+	}
+	return string(queryAsBytes), nil
+}
+
+//Query Route: TicketList
+//one page of the tickets matching the filters, needs CouchDB as state database
+func (sc *SmartContract) TicketList(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request TicketListRequest
+	response := TicketListResponse{Records: []Ticket{}}
+
+	if len(args) > 0 && args[0] != "" {
+		err := decodeAndValidate(args[0], &request, func() error {
+			return validateTicketList(request)
+		})
+		if err != nil {
+			return validationResponse("TicketList", err)
+		}
+	}
+	if request.PageSize <= 0 || request.PageSize > MaxTicketPageSize {
+		request.PageSize = DefaultTicketPageSize
+	}
+
+	query, err := buildTicketQuery(stub, request)
+	if err != nil {
+		return routeError(err)
+	}
+
+	if query != "" {
+		logger.Info("TicketList query:", query)
+		ticketIterator, metadata, err := stub.GetQueryResultWithPagination(query, request.PageSize, request.Bookmark)
+		if err != nil {
+			return routeError(wrapError("TicketList", err))
+		}
+		defer ticketIterator.Close()
+
+		for ticketIterator.HasNext() {
+			var ticket Ticket
+			queryResponse, err := ticketIterator.Next()
+			if err != nil {
+				return routeError(err)
+			}
+			err = json.Unmarshal(queryResponse.Value, &ticket)
+			if err != nil {
+				return shim.Error("TicketList: Corrupt ticket record " + queryResponse.Key)
+			}
+			response.Records = append(response.Records, ticket)
+		}
+		response.Bookmark = metadata.Bookmark
+		response.FetchedCount = metadata.FetchedRecordsCount
+	}
+
+	responseAsBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error("TicketList: Error marshalling response")
+	}
+	return shim.Success(responseAsBytes)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildTicketQuery(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.startTx()
+	defer stub.endTx(false)
+
+	status, ticketType, lobID := OrderApplied, P1, HANA
+	query, err := buildTicketQuery(stub, TicketListRequest{
+		Status:       &status,
+		Type:         &ticketType,
+		LoBID:        &lobID,
+		DeadlineFrom: "2024-03-01T10:00:00.5+01:00",
+		DeadlineTo:   "2024-03-31T00:00:00Z",
+		SortBy:       "value",
+		SortOrder:    "desc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	err = json.Unmarshal([]byte(query), &parsed)
+	if err != nil {
+		t.Fatalf("query is not JSON: %s", query)
+	}
+	expected := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"Ticket_TicketID": map[string]interface{}{"$exists": true},
+			"Ticket_Status":   float64(OrderApplied),
+			"Ticket_Type":     float64(P1),
+			"Ticket_UserID":   map[string]interface{}{"$in": []interface{}{"i000001", "i000002"}},
+			"Ticket_Deadline": map[string]interface{}{"$gte": "2024-03-01T09:00:01Z", "$lte": "2024-03-31T00:00:00Z"},
+			"Ticket_Value":    map[string]interface{}{"$gt": nil},
+		},
+		"sort": []interface{}{map[string]interface{}{"Ticket_Value": "desc"}},
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("unexpected query %s", query)
+	}
+
+	// a creator outside the LoB matches nothing
+	creator := TicketListRequest{LoBID: &lobID, Creator: "i000009"}
+	if query, err := buildTicketQuery(stub, creator); err != nil || query != "" {
+		t.Errorf("expected no query, got %q %v", query, err)
+	}
+}
+
+func TestTicketList(t *testing.T) {
+	stub := newTestStub(t)
+
+	// filters that cannot match return an empty page without querying
+	var page TicketListResponse
+	stub.mustInvoke(&page, "TicketList", `{"lobId":2}`)
+	if len(page.Records) != 0 || page.Bookmark != "" {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	stub.expectError(ErrCodeBadRequest, "TicketList", `{"sortBy":"title"}`)
+	stub.expectError(ErrCodeBadRequest, "TicketList", `{"deadlineFrom":"tomorrow"}`)
+	stub.expectError(ErrCodeBadRequest, "TicketList", `{"pages":2}`)
+	stub.expectError(ErrCodeNotFound, "TicketList", `{"lobId":99}`)
+}
+
+func TestTicketRead2(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	first := stub.createTicket("i000001", 0, nil)
+	stub.advance(time.Minute)
+	second := stub.createTicket("i000001", 0, nil)
+
+	var tickets []Ticket
+	stub.mustInvoke(&tickets, "TicketRead2")
+	if len(tickets) != 2 || tickets[0].TicketID != first.TicketID || tickets[1].TicketID != second.TicketID {
+		t.Errorf("expected the tickets in creation order, got %+v", tickets)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Key holding the highest TypeID handed out, ticket types get stable IDs 0..TicketTypeLastID
+//ledgers without it only know the default types P0 and P1
+const ticketTypeLastIDKey = "TicketTypeLastID"
+
+//Default ticket types, readable without being stored, Ticket_Type of tickets created before the registry
+const NumberOfTicketTypes = 2
+
+var TicketType_Name = [NumberOfTicketTypes]string{P0: "P0", P1: "P1"}
+
+//Maximum number of approvals a ticket type can require
+const MaxRequiredApprovals = 10
+
+//TicketType information, an entry of the ticket type registry, TicketType~<typeID>
+//TypeID:              stable ID, the Ticket_Type of its tickets
+//Name:                unique display name
+//Description:         free text
+//DefaultValue:        Ticket_Value of tickets created without one
+//MinValue:            lowest Ticket_Value
+//MaxValue:            highest Ticket_Value
+//RequiredApprovals:   approvals an order needs, see OrderApprove, before it can be awarded
+//DefaultPolicy:       Ticket_Policy of tickets created without one
+//Archived:            archived types keep their tickets but no new ones are created
+type TicketType struct {
+	TypeID      int    `json:"TicketType_TypeID"`
+	Name        string `json:"TicketType_Name"`
+	Description string `json:"TicketType_Description"`
+
+	DefaultValue int `json:"TicketType_DefaultValue"`
+	MinValue     int `json:"TicketType_MinValue"`
+	MaxValue     int `json:"TicketType_MaxValue"`
+
+	RequiredApprovals int    `json:"TicketType_RequiredApprovals"`
+	DefaultPolicy     string `json:"TicketType_DefaultPolicy"`
+	Archived          bool   `json:"TicketType_Archived"`
+}
+
+//TicketTypeStats - tickets of one type, returned by TicketTypeStats
+//Open counts tickets still taking applications or work, PaidOut the credit awarded from their escrows
+type TicketTypeStats struct {
+	TypeID     int    `json:"typeId"`
+	Name       string `json:"name"`
+	Tickets    int    `json:"tickets"`
+	Open       int    `json:"open"`
+	Awarded    int    `json:"awarded"`
+	Expired    int    `json:"expired"`
+	Closed     int    `json:"closed"`
+	TotalValue int    `json:"totalValue"`
+	PaidOut    int    `json:"paidOut"`
+}
+
+//OrderApproval information, OrderApproval~<ticketID>~<userID>~<approver>
+type OrderApproval struct {
+	TicketID  string    `json:"Approval_TicketID"`
+	UserID    string    `json:"Approval_UserID"`
+	Approver  string    `json:"Approval_Approver"`
+	Timestamp time.Time `json:"Approval_Timestamp"`
+}
+
+func getTicketTypeLastID(stub shim.ChaincodeStubInterface) (int, error) {
+	lastIDAsBytes, err := stub.GetState(ticketTypeLastIDKey)
+	if err != nil {
+		return 0, errors.New("getTicketTypeLastID: Error getting last ticket type ID")
+	}
+	if lastIDAsBytes == nil {
+		return NumberOfTicketTypes - 1, nil
+	}
+	return strconv.Atoi(string(lastIDAsBytes))
+}
+
+func ticketTypeKey(stub shim.ChaincodeStubInterface, typeID int) (string, error) {
+	return stub.CreateCompositeKey("TicketType", []string{strconv.Itoa(typeID)})
+}
+
+//Helper: read a ticket type, the default types exist until they are stored
+func retrieveTicketType(stub shim.ChaincodeStubInterface, typeID int) (TicketType, error) {
+	var ticketType TicketType
+	key, err := ticketTypeKey(stub, typeID)
+	if err != nil {
+		return ticketType, wrapError("retrieveTicketType", err)
+	}
+	typeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return ticketType, errors.New("retrieveTicketType: Error getting ticket type " + strconv.Itoa(typeID))
+	}
+	if typeAsBytes == nil {
+		if typeID >= 0 && typeID < NumberOfTicketTypes {
+			return TicketType{TypeID: typeID, Name: TicketType_Name[typeID], MaxValue: MaxCreditValue}, nil
+		}
+		return ticketType, notFoundError("retrieveTicketType: Ticket type " + strconv.Itoa(typeID) + " does not exist")
+	}
+	err = json.Unmarshal(typeAsBytes, &ticketType)
+	if err != nil {
+		return ticketType, errors.New("retrieveTicketType: Corrupt ticket type record " + string(typeAsBytes))
+	}
+	return ticketType, nil
+}
+
+func saveTicketType(stub shim.ChaincodeStubInterface, ticketType TicketType) ([]byte, error) {
+	typeAsBytes, err := json.Marshal(ticketType)
+	if err != nil {
+		return nil, errors.New("saveTicketType: Error marshalling ticket type")
+	}
+	key, err := ticketTypeKey(stub, ticketType.TypeID)
+	if err != nil {
+		return nil, wrapError("saveTicketType", err)
+	}
+	err = stub.PutState(key, typeAsBytes)
+	if err != nil {
+		return nil, errors.New("saveTicketType: Error storing ticket type")
+	}
+	return typeAsBytes, nil
+}
+
+//Helper: every ticket type, ordered by ID
+func listTicketTypes(stub shim.ChaincodeStubInterface, includeArchived bool) ([]TicketType, error) {
+	ticketTypes := []TicketType{}
+	lastID, err := getTicketTypeLastID(stub)
+	if err != nil {
+		return nil, err
+	}
+	for typeID := 0; typeID <= lastID; typeID++ {
+		ticketType, err := retrieveTicketType(stub, typeID)
+		if err != nil {
+			return nil, err
+		}
+		if ticketType.Archived && !includeArchived {
+			continue
+		}
+		ticketTypes = append(ticketTypes, ticketType)
+	}
+	return ticketTypes, nil
+}
+
+//Helper: check a ticket type, its default policy has to be valid, whether another type has its name is checked by checkTicketTypeName
+func checkTicketType(stub shim.ChaincodeStubInterface, ticketType TicketType) error {
+	var errs ValidationErrors
+	if errs.required("TicketType_Name", ticketType.Name) {
+		errs.maxLength("TicketType_Name", ticketType.Name, MaxTitleLength)
+	}
+	errs.maxLength("TicketType_Description", ticketType.Description, MaxCommentLength)
+	errs.intRange("TicketType_MinValue", ticketType.MinValue, 0, MaxCreditValue)
+	errs.intRange("TicketType_MaxValue", ticketType.MaxValue, ticketType.MinValue, MaxCreditValue)
+	if ticketType.DefaultValue != 0 {
+		errs.intRange("TicketType_DefaultValue", ticketType.DefaultValue, ticketType.MinValue, ticketType.MaxValue)
+	}
+	errs.intRange("TicketType_RequiredApprovals", ticketType.RequiredApprovals, 0, MaxRequiredApprovals)
+	_, policyErrs := lintPolicy(stub, ticketType.DefaultPolicy)
+	for _, fieldError := range policyErrs {
+		errs.add(strings.TrimSuffix("TicketType_DefaultPolicy."+fieldError.Field, "."), fieldError.Message)
+	}
+	return errs.err()
+}
+
+//Helper: check a ticket type name is not used by another ticket type
+func checkTicketTypeName(stub shim.ChaincodeStubInterface, ticketType TicketType) error {
+	ticketTypes, err := listTicketTypes(stub, true)
+	if err != nil {
+		return err
+	}
+	for _, other := range ticketTypes {
+		if other.TypeID != ticketType.TypeID && other.Name == ticketType.Name {
+			return conflictError("checkTicketTypeName: Ticket type name " + ticketType.Name + " is used by ticket type " + strconv.Itoa(other.TypeID))
+		}
+	}
+	return nil
+}
+
+//Helper: fill in the defaults of the type of a new ticket and check its value against the bounds of the type
+//previous is the stored ticket of an update, nil for a new ticket
+//valueGiven tells whether the input set Ticket_Value, only new tickets without it get the default value
+//updates keeping type and value are not checked, so types can be archived or narrowed under existing tickets
+func applyTicketType(stub shim.ChaincodeStubInterface, ticket *Ticket, previous *Ticket, valueGiven bool) error {
+	create := previous == nil
+	if !create && ticket.Type == previous.Type && ticket.Value == previous.Value {
+		return nil
+	}
+	ticketType, err := retrieveTicketType(stub, ticket.Type)
+	if err != nil {
+		var errs ValidationErrors
+		errs.add("Ticket_Type", err.Error())
+		return errs
+	}
+	if ticketType.Archived && (create || ticket.Type != previous.Type) {
+		var errs ValidationErrors
+		errs.add("Ticket_Type", "ticket type "+ticketType.Name+" is archived")
+		return errs
+	}
+	if create {
+		if !valueGiven {
+			ticket.Value = ticketType.DefaultValue
+		}
+		if ticket.Policy == "" {
+			ticket.Policy = ticketType.DefaultPolicy
+		}
+	}
+	if ticket.Value < ticketType.MinValue || ticket.Value > ticketType.MaxValue {
+		var errs ValidationErrors
+		errs.add("Ticket_Value", "must be between "+strconv.Itoa(ticketType.MinValue)+" and "+strconv.Itoa(ticketType.MaxValue)+" for ticket type "+ticketType.Name)
+		return errs
+	}
+	return nil
+}
+
+//Helper: approvals an order has collected
+func countApprovals(stub shim.ChaincodeStubInterface, ticketID string, userID string) (int, error) {
+	approvalIterator, err := stub.GetStateByPartialCompositeKey("OrderApproval", []string{ticketID, userID})
+	if err != nil {
+		return 0, wrapError("countApprovals", err)
+	}
+	defer approvalIterator.Close()
+
+	count := 0
+	for approvalIterator.HasNext() {
+		_, err := approvalIterator.Next()
+		if err != nil {
+			return 0, wrapError("countApprovals", err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+//Helper: an order can be awarded once it has the approvals the type of its ticket requires
+func checkApprovals(stub shim.ChaincodeStubInterface, ticket Ticket, userID string) error {
+	ticketType, err := retrieveTicketType(stub, ticket.Type)
+	if err != nil || ticketType.RequiredApprovals == 0 {
+		// tickets of types that are not registered need no approvals
+		return nil
+	}
+	approvals, err := countApprovals(stub, ticket.TicketID, userID)
+	if err != nil {
+		return err
+	}
+	if approvals < ticketType.RequiredApprovals {
+		return errors.New("The order of " + userID + " has " + strconv.Itoa(approvals) + " of " +
+			strconv.Itoa(ticketType.RequiredApprovals) + " approvals required by ticket type " + ticketType.Name)
+	}
+	return nil
+}
+
+//Invoke Route: TicketTypeCreate
+func (rdg *SmartContract) TicketTypeCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var ticketType TicketType
+	err := decodeAndValidate(args[0], &ticketType, nil, "TicketType_Name", "TicketType_MaxValue")
+	if err != nil {
+		return validationResponse("TicketTypeCreate", err)
+	}
+
+	lastID, err := getTicketTypeLastID(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	ticketType.TypeID = lastID + 1
+	ticketType.Name = strings.TrimSpace(ticketType.Name)
+	ticketType.Archived = false
+	err = checkTicketType(stub, ticketType)
+	if err != nil {
+		return validationResponse("TicketTypeCreate", err)
+	}
+	err = checkTicketTypeName(stub, ticketType)
+	if err != nil {
+		return routeError(err)
+	}
+
+	typeAsBytes, err := saveTicketType(stub, ticketType)
+	if err != nil {
+		return routeError(err)
+	}
+	err = stub.PutState(ticketTypeLastIDKey, []byte(strconv.Itoa(ticketType.TypeID)))
+	if err != nil {
+		return shim.Error("TicketTypeCreate: Error storing last ticket type ID")
+	}
+	return shim.Success(typeAsBytes)
+}
+
+//Invoke Route: TicketTypeUpdate
+//replaces a ticket type, tickets already created keep their value and policy
+func (rdg *SmartContract) TicketTypeUpdate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var ticketType TicketType
+	err := decodeAndValidate(args[0], &ticketType, nil, "TicketType_TypeID", "TicketType_Name", "TicketType_MaxValue")
+	if err != nil {
+		return validationResponse("TicketTypeUpdate", err)
+	}
+	_, err = retrieveTicketType(stub, ticketType.TypeID)
+	if err != nil {
+		return routeError(err)
+	}
+	ticketType.Name = strings.TrimSpace(ticketType.Name)
+	err = checkTicketType(stub, ticketType)
+	if err != nil {
+		return validationResponse("TicketTypeUpdate", err)
+	}
+	err = checkTicketTypeName(stub, ticketType)
+	if err != nil {
+		return routeError(err)
+	}
+
+	typeAsBytes, err := saveTicketType(stub, ticketType)
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(typeAsBytes)
+}
+
+//Query Route: TicketTypeRead
+func (rdg *SmartContract) TicketTypeRead(stub shim.ChaincodeStubInterface, typeid string) peer.Response {
+	typeID, err := strconv.Atoi(typeid)
+	if err != nil {
+		return shim.Error("TicketTypeRead: Input TypeID is invalid")
+	}
+	ticketType, err := retrieveTicketType(stub, typeID)
+	if err != nil {
+		return routeError(err)
+	}
+	return successResponse("TicketTypeRead", ticketType)
+}
+
+//Query Route: TicketTypeList
+//optional arg "true" also lists archived types
+func (rdg *SmartContract) TicketTypeList(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	includeArchived := len(args) > 0 && args[0] == "true"
+	ticketTypes, err := listTicketTypes(stub, includeArchived)
+	if err != nil {
+		return routeError(err)
+	}
+	return successResponse("TicketTypeList", ticketTypes)
+}
+
+//Query Route: TicketTypeStats
+//statistics of every ticket type, or of the type given as optional arg
+func (rdg *SmartContract) TicketTypeStats(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	ticketTypes, err := listTicketTypes(stub, true)
+	if err != nil {
+		return routeError(err)
+	}
+	if len(args) > 0 && args[0] != "" {
+		typeID, _ := strconv.Atoi(args[0])
+		ticketType, err := retrieveTicketType(stub, typeID)
+		if err != nil {
+			return routeError(err)
+		}
+		ticketTypes = []TicketType{ticketType}
+	}
+
+	stats := make([]TicketTypeStats, len(ticketTypes))
+	position := map[int]int{}
+	for i, ticketType := range ticketTypes {
+		stats[i] = TicketTypeStats{TypeID: ticketType.TypeID, Name: ticketType.Name}
+		position[ticketType.TypeID] = i
+	}
+
+	tickets, err := listAllTickets(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	for _, ticket := range tickets {
+		i, ok := position[ticket.Type]
+		if !ok {
+			continue
+		}
+		stats[i].Tickets++
+		stats[i].TotalValue += ticket.Value
+		switch ticket.Status {
+		case OrderAwarded:
+			stats[i].Awarded++
+		case OrderExpired:
+			stats[i].Expired++
+		case OrderClosed, OrderRejected:
+			stats[i].Closed++
+		default:
+			stats[i].Open++
+		}
+		escrow, err := retrieveEscrow(stub, ticket.TicketID)
+		if err == nil {
+			for _, paid := range escrow.Payouts {
+				stats[i].PaidOut += paid
+			}
+		}
+	}
+	return successResponse("TicketTypeStats", stats)
+}
+
+//Invoke Route: OrderApprove
+//args: TicketID, UserID, records the approval of a done order by the caller, each approver counts once
+func (sc *SmartContract) OrderApprove(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	ticketID, userID := args[0], args[1]
+	order, exists, err := retrieveOrder(stub, ticketID, userID)
+	if err != nil {
+		return routeError(err)
+	}
+	if !exists {
+		return errorResponse(ErrCodeNotFound, "OrderApprove: No order of "+userID+" for ticket "+ticketID)
+	}
+	if order.Status != OrderDone {
+		return errorResponse(ErrCodeConflict, "OrderApprove: Only done orders can be approved, the order is "+OrderStatusName[order.Status])
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(ErrCodeUnauthenticated, err.Error())
+	}
+	if caller.Registered && caller.Participant.UserID == userID {
+		return errorResponse(ErrCodeForbidden, "OrderApprove: Assignees cannot approve their own order")
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	approval := OrderApproval{TicketID: ticketID, UserID: userID, Approver: caller.EnrollmentID, Timestamp: txTime}
+	approvalAsBytes, err := json.Marshal(approval)
+	if err != nil {
+		return shim.Error("OrderApprove: Error marshalling approval")
+	}
+	key, err := stub.CreateCompositeKey("OrderApproval", []string{ticketID, userID, caller.EnrollmentID})
+	if err != nil {
+		return routeError(wrapError("OrderApprove", err))
+	}
+	err = stub.PutState(key, approvalAsBytes)
+	if err != nil {
+		return shim.Error("OrderApprove: Error storing approval")
+	}
+	return shim.Success(approvalAsBytes)
+}
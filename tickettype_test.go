@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+//Helper: ticket type Bug worth 5 to 50, 10 by default, awarded after one approval
+func (stub *testStub) bugType() TicketType {
+	stub.t.Helper()
+	var ticketType TicketType
+	stub.asAdmin()
+	stub.mustInvoke(&ticketType, "TicketTypeCreate", `{"TicketType_Name":" Bug ","TicketType_DefaultValue":10,"TicketType_MinValue":5,"TicketType_MaxValue":50,"TicketType_RequiredApprovals":1,"TicketType_DefaultPolicy":"{\"split\":{\"maxPerPayee\":8}}"}`)
+	return ticketType
+}
+
+func TestTicketTypeRegistry(t *testing.T) {
+	stub := newTestStub(t)
+	bug := stub.bugType()
+	if bug.TypeID != NumberOfTicketTypes || bug.Name != "Bug" {
+		t.Fatalf("unexpected ticket type %+v", bug)
+	}
+
+	var types []TicketType
+	stub.mustInvoke(&types, "TicketTypeList")
+	if len(types) != NumberOfTicketTypes+1 || types[P1].Name != "P1" || types[bug.TypeID].Name != "Bug" {
+		t.Errorf("unexpected ticket types %+v", types)
+	}
+
+	// new tickets take the defaults of their type
+	stub.addUser("i000001", HANA)
+	stub.grant("i000001", 100)
+	stub.asUser("i000001")
+	var ticket Ticket
+	stub.mustInvoke(&ticket, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":2,"Ticket_UserID":"i000001"}`)
+	if ticket.Value != 10 || ticket.Policy != bug.DefaultPolicy {
+		t.Errorf("defaults not applied %+v", ticket)
+	}
+	response := stub.expectError(ErrCodeBadRequest, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":2,"Ticket_Value":60,"Ticket_UserID":"i000001"}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Value"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+	response = stub.expectError(ErrCodeBadRequest, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":9,"Ticket_Value":0,"Ticket_UserID":"i000001"}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Type"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+
+	// archived types take no new tickets, their tickets stay
+	bug.Archived = true
+	stub.asAdmin()
+	stub.mustInvoke(nil, "TicketTypeUpdate", toJSON(t, bug))
+	stub.mustInvoke(&types, "TicketTypeList")
+	if len(types) != NumberOfTicketTypes {
+		t.Errorf("archived type listed %+v", types)
+	}
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeBadRequest, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":2,"Ticket_Value":10,"Ticket_UserID":"i000001"}`)
+
+	var stats []TicketTypeStats
+	stub.mustInvoke(&stats, "TicketTypeStats", strconv.Itoa(bug.TypeID))
+	if len(stats) != 1 || stats[0].Tickets != 1 || stats[0].Open != 1 || stats[0].TotalValue != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestTicketTypeRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.bugType()
+
+	stub.expectError(ErrCodeConflict, "TicketTypeCreate", `{"TicketType_Name":"Bug","TicketType_MaxValue":10}`)
+	stub.expectError(ErrCodeConflict, "TicketTypeUpdate", `{"TicketType_TypeID":2,"TicketType_Name":"P1","TicketType_MaxValue":10}`)
+	stub.expectError(ErrCodeBadRequest, "TicketTypeCreate", `{"TicketType_Name":"Task","TicketType_MinValue":20,"TicketType_MaxValue":10}`)
+	stub.expectError(ErrCodeBadRequest, "TicketTypeCreate", `{"TicketType_Name":"Task","TicketType_MaxValue":10,"TicketType_DefaultPolicy":"{\"split\":{\"mode\":\"random\"}}"}`)
+	stub.expectError(ErrCodeNotFound, "TicketTypeUpdate", `{"TicketType_TypeID":9,"TicketType_Name":"Task","TicketType_MaxValue":10}`)
+	stub.expectError(ErrCodeNotFound, "TicketTypeRead", "9")
+	stub.expectError(ErrCodeNotFound, "TicketTypeStats", "9")
+
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeForbidden, "TicketTypeCreate", `{"TicketType_Name":"Task","TicketType_MaxValue":10}`)
+}
+
+func TestOrderApprovals(t *testing.T) {
+	stub := newTestStub(t)
+	stub.bugType()
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 100)
+	stub.asUser("i000001")
+	var ticket Ticket
+	stub.mustInvoke(&ticket, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":2,"Ticket_Value":20,"Ticket_UserID":"i000001"}`)
+	stub.apply(ticket.TicketID, "i000002")
+	stub.asUser("i000001")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Confirm: []string{"i000002"}})
+
+	// only done orders are approved, and not by their assignee
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeConflict, "OrderApprove", ticket.TicketID, "i000002")
+	stub.asUser("i000002")
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Done: []string{"i000002"}})
+	stub.expectError(ErrCodeForbidden, "OrderApprove", ticket.TicketID, "i000002")
+
+	// an award waits for the approvals of the type
+	stub.asUser("i000001")
+	var result OrderUpdateResult
+	stub.mustInvoke(&result, "OrderUpdate", toJSON(t, OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002"}}))
+	if len(result.Results) != 1 || result.Results[0].OK {
+		t.Errorf("award without approval %+v", result.Results)
+	}
+	stub.asUser("i000001")
+	var approval OrderApproval
+	stub.mustInvoke(&approval, "OrderApprove", ticket.TicketID, "i000002")
+	if approval.Approver != "i000001" {
+		t.Errorf("unexpected approval %+v", approval)
+	}
+	stub.updateOrders(OrderUpdateRequest{TicketID: ticket.TicketID, Award: []string{"i000002"}})
+
+	// the default policy of the type caps the payout
+	if value := stub.credit("i000002").Value; value != 8 {
+		t.Errorf("assignee: expected 8, got %d", value)
+	}
+	stub.expectError(ErrCodeNotFound, "OrderApprove", ticket.TicketID, "i000009")
+}
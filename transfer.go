@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Maximum length of a transfer memo
+const MaxMemoLength = 256
+
+//Transfer information
+//TransferID:   transaction ID of the transfer
+//From:         iXXXXXX
+//To:           iXXXXXX
+//Value:        123
+//Memo:         free text
+//Timestamp:    transaction timestamp
+type Transfer struct {
+	TransferID string `json:"Transfer_TransferID"`
+	From       string `json:"Transfer_From"`
+	To         string `json:"Transfer_To"`
+
+	Value     int       `json:"Transfer_Value"`
+	Memo      string    `json:"Transfer_Memo"`
+	Timestamp time.Time `json:"Transfer_Timestamp"`
+}
+
+//TransferRequest - input of CreditTransfer
+type TransferRequest struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value int    `json:"value"`
+	Memo  string `json:"memo"`
+}
+
+//Helper: save a transfer once per involved user so it can be listed by either of them
+func saveTransfer(stub shim.ChaincodeStubInterface, transfer Transfer) ([]byte, error) {
+	transferAsBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return nil, errors.New("saveTransfer: Error marshalling transfer")
+	}
+	for _, userID := range []string{transfer.From, transfer.To} {
+		key, err := stub.CreateCompositeKey("Transfer", []string{userID, transfer.TransferID})
+		if err != nil {
+			return nil, wrapError("saveTransfer", err)
+		}
+		err = stub.PutState(key, transferAsBytes)
+		if err != nil {
+			return nil, errors.New("saveTransfer: Error storing transfer for " + userID)
+		}
+	}
+	return transferAsBytes, nil
+}
+
+//Helper: move the value of a transfer between the LoB totals of sender and receiver
+//the changes are netted per LoB first, a transfer inside one LoB leaves its total untouched
+func transferLoBCredit(stub shim.ChaincodeStubInterface, request TransferRequest) error {
+	var lobIDs []int
+	changes := map[int]int{}
+	for _, userID := range []string{request.From, request.To} {
+		participant, registered, err := lookupParticipant(stub, userID)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return notFoundError("transferLoBCredit: Participant " + userID + " does not exist")
+		}
+		if _, ok := changes[participant.LoBID]; !ok {
+			lobIDs = append(lobIDs, participant.LoBID)
+		}
+		if userID == request.From {
+			changes[participant.LoBID] -= request.Value
+		} else {
+			changes[participant.LoBID] += request.Value
+		}
+	}
+
+	for _, lobID := range lobIDs {
+		if changes[lobID] == 0 {
+			continue
+		}
+		LoB_temp, err := retrieveLoB(stub, lobID)
+		if err != nil {
+			return wrapError("transferLoBCredit", err)
+		}
+		LoB_temp.TotalCredit += changes[lobID]
+		_, err = saveLoB(stub, LoB_temp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Invoke Route: CreditTransfer
+//moves credit from one participant to another, both LoB totals follow
+func (rdg *SmartContract) CreditTransfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request TransferRequest
+
+	err := decodeAndValidate(args[0], &request, func() error {
+		return validateTransfer(request)
+	}, "from", "to", "value")
+	if err != nil {
+		return validationResponse("CreditTransfer", err)
+	}
+
+	sender, err := retrieveSingleCredit(stub, request.From)
+	if err != nil {
+		return routeError(wrapError("CreditTransfer", err))
+	}
+	receiver, err := retrieveSingleCredit(stub, request.To)
+	if err != nil {
+		return routeError(wrapError("CreditTransfer", err))
+	}
+	if sender.Value < request.Value {
+		return errorResponse(ErrCodeInsufficientCredit, "CreditTransfer: Insufficient credit of "+request.From)
+	}
+
+	sender.Value -= request.Value
+	receiver.Value += request.Value
+	sender.Contributed -= request.Value
+	receiver.Contributed += request.Value
+
+	_, err = saveCredit(stub, sender)
+	if err != nil {
+		return routeError(err)
+	}
+	_, err = saveCredit(stub, receiver)
+	if err != nil {
+		return routeError(err)
+	}
+	err = journalCredit(stub, sender, JournalEntry{Amount: -request.Value, Reason: ReasonTransfer, Counterparty: request.To})
+	if err != nil {
+		return routeError(err)
+	}
+	err = journalCredit(stub, receiver, JournalEntry{Amount: request.Value, Reason: ReasonTransfer, Counterparty: request.From})
+	if err != nil {
+		return routeError(err)
+	}
+
+	// ==== LoB totals follow the credit ====
+	err = transferLoBCredit(stub, request)
+	if err != nil {
+		return routeError(err)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return routeError(err)
+	}
+	transfer := Transfer{
+		TransferID: stub.GetTxID(),
+		From:       request.From,
+		To:         request.To,
+		Value:      request.Value,
+		Memo:       request.Memo,
+		Timestamp:  txTime}
+	transferAsBytes, err := saveTransfer(stub, transfer)
+	if err != nil {
+		return routeError(err)
+	}
+
+	err = emitEvent(stub, ChaincodeEvent{Type: EventCreditTransferred, UserID: request.From, Value: request.Value})
+	if err != nil {
+		return routeError(err)
+	}
+	return shim.Success(transferAsBytes)
+}
+
+//Query Route: CreditTransferList
+//lists all transfers sent or received by a participant
+func (rdg *SmartContract) CreditTransferList(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	transferIterator, err := stub.GetStateByPartialCompositeKey("Transfer", []string{userID})
+	if err != nil {
+		return routeError(wrapError("CreditTransferList", err))
+	}
+	defer transferIterator.Close()
+
+	transfers := []Transfer{}
+	for transferIterator.HasNext() {
+		var transfer Transfer
+		queryResponse, err := transferIterator.Next()
+		if err != nil {
+			return routeError(err)
+		}
+		err = json.Unmarshal(queryResponse.Value, &transfer)
+		if err != nil {
+			return shim.Error("CreditTransferList: Corrupt transfer record " + queryResponse.Key)
+		}
+		transfers = append(transfers, transfer)
+	}
+	return successResponse("CreditTransferList", transfers)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreditTransfer(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", SMB)
+	stub.grant("i000001", 50)
+	hana, smb := stub.lob(HANA).TotalCredit, stub.lob(SMB).TotalCredit
+
+	stub.asUser("i000001")
+	var transfer Transfer
+	stub.mustInvoke(&transfer, "CreditTransfer", `{"from":"i000001","to":"i000002","value":20,"memo":"thanks"}`)
+	if transfer.TransferID != stub.LastTxID || transfer.Value != 20 || transfer.Memo != "thanks" || !transfer.Timestamp.Equal(stub.now) {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+	events := stub.events()
+	if len(events) != 1 || events[0].Type != EventCreditTransferred || events[0].Value != 20 {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	if value := stub.credit("i000001").Value; value != 30 {
+		t.Errorf("sender: expected 30, got %d", value)
+	}
+	if value := stub.credit("i000002").Value; value != 20 {
+		t.Errorf("receiver: expected 20, got %d", value)
+	}
+	if total := stub.lob(HANA).TotalCredit; total != hana-20 {
+		t.Errorf("LoB of sender: expected %d, got %d", hana-20, total)
+	}
+	if total := stub.lob(SMB).TotalCredit; total != smb+20 {
+		t.Errorf("LoB of receiver: expected %d, got %d", smb+20, total)
+	}
+
+	// both participants see the transfer
+	for _, userID := range []string{"i000001", "i000002"} {
+		stub.asUser(userID)
+		var transfers []Transfer
+		stub.mustInvoke(&transfers, "CreditTransferList", userID)
+		if len(transfers) != 1 || transfers[0].TransferID != transfer.TransferID {
+			t.Errorf("%s: unexpected transfers %+v", userID, transfers)
+		}
+	}
+}
+
+func TestCreditTransferRejected(t *testing.T) {
+	stub := newTestStub(t)
+	stub.addUser("i000001", HANA)
+	stub.addUser("i000002", HANA)
+	stub.grant("i000001", 10)
+
+	stub.asUser("i000001")
+	stub.expectError(ErrCodeInsufficientCredit, "CreditTransfer", `{"from":"i000001","to":"i000002","value":11}`)
+	stub.expectError(ErrCodeBadRequest, "CreditTransfer", `{"from":"i000001","to":"i000001","value":1}`)
+	stub.expectError(ErrCodeBadRequest, "CreditTransfer", `{"from":"i000001","to":"i000002","value":0}`)
+	stub.expectError(ErrCodeNotFound, "CreditTransfer", `{"from":"i000001","to":"i000009","value":1}`)
+	stub.expectError(ErrCodeForbidden, "CreditTransferList", "i000002")
+
+	// only the sender moves its credit
+	stub.asUser("i000002")
+	stub.expectError(ErrCodeForbidden, "CreditTransfer", `{"from":"i000001","to":"i000002","value":1}`)
+
+	if value := stub.credit("i000001").Value; value != 10 {
+		t.Errorf("rejected transfers changed the credit: %d", value)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//Input limits
+const (
+	MaxIDLength       = 64
+	MaxUserNameLength = 128
+	MaxMSPIDLength    = 128
+	MaxTitleLength    = 128
+	MaxCommentLength  = 1024
+	MaxPolicyLength   = 4096
+	MaxCreditValue    = 1000000000
+)
+
+//UserIDs, TicketIDs and enrollment IDs: letters, digits and . _ @ -
+//this also keeps the composite key delimiter out of IDs
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+//FieldError - one invalid field of an input
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//ValidationErrors - every invalid field of an input
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	var messages []string
+	for _, fieldError := range v {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field string, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
+
+//Helper: nil if no field is invalid
+func (v ValidationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *ValidationErrors) required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+		return false
+	}
+	return true
+}
+
+func (v *ValidationErrors) maxLength(field string, value string, max int) {
+	if len(value) > max {
+		v.add(field, "must be at most "+strconv.Itoa(max)+" characters")
+	}
+}
+
+//Helper: a required ID
+func (v *ValidationErrors) id(field string, value string) {
+	if v.required(field, value) {
+		v.optionalID(field, value)
+	}
+}
+
+func (v *ValidationErrors) optionalID(field string, value string) {
+	if value == "" {
+		return
+	}
+	if len(value) > MaxIDLength || !idPattern.MatchString(value) {
+		v.add(field, "must be 1 to "+strconv.Itoa(MaxIDLength)+" letters, digits or . _ @ -")
+	}
+}
+
+func (v *ValidationErrors) ids(field string, values []string) {
+	for i, value := range values {
+		v.id(field+"["+strconv.Itoa(i)+"]", value)
+	}
+}
+
+func (v *ValidationErrors) intRange(field string, value int, min int, max int) {
+	if value < min || value > max {
+		v.add(field, "must be between "+strconv.Itoa(min)+" and "+strconv.Itoa(max))
+	}
+}
+
+func (v *ValidationErrors) oneOf(field string, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+func (v *ValidationErrors) rfc3339(field string, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		v.add(field, "must be an RFC3339 date")
+	}
+}
+
+//Helper: decode a JSON object into v, rejecting unknown fields, wrong types and missing required fields
+func decodeStrict(input string, v interface{}, required ...string) ValidationErrors {
+	var errs ValidationErrors
+	var raw map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(input), &raw)
+	if err != nil || raw == nil {
+		errs.add("", "must be a JSON object")
+		return errs
+	}
+	for _, field := range required {
+		if value, ok := raw[field]; !ok || string(value) == "null" {
+			errs.add(field, "is required")
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(input)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err != nil {
+		switch e := err.(type) {
+		case *json.UnmarshalTypeError:
+			errs.add(e.Field, "must be of type "+e.Type.String())
+		default:
+			// unknown fields are reported as: json: unknown field "name"
+			message := err.Error()
+			if strings.HasPrefix(message, "json: unknown field ") {
+				errs.add(strings.Trim(strings.TrimPrefix(message, "json: unknown field "), "\""), "is not a known field")
+			} else {
+				errs.add("", message)
+			}
+		}
+	}
+	return errs
+}
+
+//Helper: whether a JSON object gives a field a value, tells an omitted field from one set to its zero value
+func hasField(input string, field string) bool {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal([]byte(input), &raw) != nil {
+		return false
+	}
+	value, ok := raw[field]
+	return ok && string(value) != "null"
+}
+
+//Helper: reject a call whose input is invalid, with the invalid fields as details
+func validationResponse(function string, err error) peer.Response {
+	if details, ok := err.(ValidationErrors); ok {
+		return errorDetailsResponse(ErrCodeBadRequest, function+": Invalid input", details)
+	}
+	return errorResponse(ErrCodeBadRequest, function+": "+err.Error())
+}
+
+//Helper: check a participant, LoBID must name an existing LoB
+func validateParticipant(stub shim.ChaincodeStubInterface, participant Participant) error {
+	var errs ValidationErrors
+	errs.id("Participant_UserID", participant.UserID)
+	if errs.required("Participant_UserName", participant.UserName) {
+		errs.maxLength("Participant_UserName", participant.UserName, MaxUserNameLength)
+	}
+	errs.maxLength("Participant_MSPID", participant.MSPID, MaxMSPIDLength)
+	errs.optionalID("Participant_EnrollmentID", participant.EnrollmentID)
+
+	lastID, err := getLoBLastID(stub)
+	if err != nil {
+		return err
+	}
+	errs.intRange("Participant_LoBID", participant.LoBID, 0, lastID)
+	return errs.err()
+}
+
+//Helper: check a ticket, the TicketID is only given on updates
+func validateTicket(ticket Ticket, update bool) error {
+	var errs ValidationErrors
+	if update {
+		errs.id("Ticket_TicketID", ticket.TicketID)
+		errs.optionalID("Ticket_UserID", ticket.UserID)
+	} else {
+		errs.id("Ticket_UserID", ticket.UserID)
+	}
+	if errs.required("Ticket_Title", ticket.Title) {
+		errs.maxLength("Ticket_Title", ticket.Title, MaxTitleLength)
+	}
+	errs.maxLength("Ticket_Comment", ticket.Comment, MaxCommentLength)
+	errs.maxLength("Ticket_Policy", ticket.Policy, MaxPolicyLength)
+	errs.intRange("Ticket_Value", ticket.Value, 0, MaxCreditValue)
+	errs.intRange("Ticket_Type", ticket.Type, 0, MaxCreditValue)
+	errs.intRange("Ticket_Status", ticket.Status, OrderCreated, OrderRejected)
+	errs.intRange("Ticket_MaxAssignees", ticket.MaxAssignees, 0, MaxTicketAssignees)
+	if !ticket.ApplicationOpens.IsZero() && !ticket.ApplicationCloses.IsZero() && !ticket.ApplicationOpens.Before(ticket.ApplicationCloses) {
+		errs.add("Ticket_ApplicationCloses", "must be after Ticket_ApplicationOpens")
+	}
+	if !ticket.ApplicationCloses.IsZero() && !ticket.DeadLine.IsZero() && ticket.ApplicationCloses.After(ticket.DeadLine) {
+		errs.add("Ticket_ApplicationCloses", "must not be after Ticket_Deadline")
+	}
+	return errs.err()
+}
+
+func validateOrder(order Order) error {
+	var errs ValidationErrors
+	errs.id("TicketID", order.TicketID)
+	errs.id("UserID", order.UserID)
+	return errs.err()
+}
+
+//Helper: check an order update, every user may be named once across all steps
+func validateOrderUpdate(request OrderUpdateRequest) error {
+	var errs ValidationErrors
+	errs.id("TicketID", request.TicketID)
+	steps := []struct {
+		field   string
+		userIDs []string
+	}{
+		{"Close", request.Close},
+		{"Reject", request.Reject},
+		{"Confirm", request.Confirm},
+		{"Done", request.Done},
+		{"Award", request.Award},
+	}
+	listed := map[string]string{}
+	for _, step := range steps {
+		errs.ids(step.field, step.userIDs)
+		for i, userID := range step.userIDs {
+			field := step.field + "[" + strconv.Itoa(i) + "]"
+			if first, ok := listed[userID]; ok {
+				errs.add(field, userID+" is already listed in "+first)
+				continue
+			}
+			listed[userID] = field
+		}
+	}
+	return errs.err()
+}
+
+func validateTransfer(request TransferRequest) error {
+	var errs ValidationErrors
+	errs.id("from", request.From)
+	errs.id("to", request.To)
+	if request.From != "" && request.From == request.To {
+		errs.add("to", "cannot be the sender")
+	}
+	errs.intRange("value", request.Value, 1, MaxCreditValue)
+	errs.maxLength("memo", request.Memo, MaxMemoLength)
+	return errs.err()
+}
+
+func validateTicketList(request TicketListRequest) error {
+	var errs ValidationErrors
+	errs.optionalID("creator", request.Creator)
+	errs.rfc3339("deadlineFrom", request.DeadlineFrom)
+	errs.rfc3339("deadlineTo", request.DeadlineTo)
+	if request.SortBy != "" {
+		errs.oneOf("sortBy", request.SortBy, "deadline", "value")
+	}
+	if request.SortOrder != "" {
+		errs.oneOf("sortOrder", request.SortOrder, "asc", "desc")
+	}
+	if request.PageSize != 0 {
+		errs.intRange("pageSize", int(request.PageSize), 1, MaxTicketPageSize)
+	}
+	return errs.err()
+}
+
+func validateCreditAdd(request CreditAddRequest) error {
+	var errs ValidationErrors
+	errs.id("userID", request.UserID)
+	errs.optionalID("ticketID", request.TicketID)
+	errs.intRange("value", request.Value, -MaxCreditValue, MaxCreditValue)
+	if request.Reason != "" {
+		errs.oneOf("reason", request.Reason, ReasonGrant, ReasonCorrection)
+	}
+	return errs.err()
+}
+
+func validateReward(request RewardRequest) error {
+	var errs ValidationErrors
+	errs.id("itemID", request.ItemID)
+	if errs.required("name", request.Name) {
+		errs.maxLength("name", request.Name, MaxTitleLength)
+	}
+	errs.maxLength("description", request.Description, MaxDescriptionLength)
+	errs.intRange("cost", request.Cost, 1, MaxCreditValue)
+	errs.intRange("stock", request.Stock, UnlimitedStock, MaxCreditValue)
+	for i, lobID := range request.LoBIDs {
+		errs.intRange("lobIds["+strconv.Itoa(i)+"]", lobID, 0, MaxCreditValue)
+	}
+	return errs.err()
+}
+
+func validateRedeem(request RedeemRequest) error {
+	var errs ValidationErrors
+	errs.id("userID", request.UserID)
+	errs.id("itemID", request.ItemID)
+	if request.Quantity != 0 {
+		errs.intRange("quantity", request.Quantity, 1, MaxRedeemQuantity)
+	}
+	return errs.err()
+}
+
+//Helper: check the format of a LoB name, whether another LoB has it is checked by checkLoBName
+func validateLoBName(field string, name string) error {
+	var errs ValidationErrors
+	if errs.required(field, name) {
+		errs.maxLength(field, strings.TrimSpace(name), MaxLoBNameLength)
+	}
+	return errs.err()
+}
+
+func validateLoBChange(request LoBChangeRequest) error {
+	var errs ValidationErrors
+	errs.id("userID", request.UserID)
+	errs.intRange("lobId", request.LoBID, 0, MaxCreditValue)
+	errs.rfc3339("effectiveDate", request.EffectiveDate)
+	return errs.err()
+}
+
+func validateOffboard(request OffboardRequest) error {
+	var errs ValidationErrors
+	errs.id("userID", request.UserID)
+	errs.oneOf("mode", request.Mode, OffboardArchive, OffboardPurge)
+	errs.optionalID("reassignTo", request.ReassignTo)
+	if request.ReassignTo != "" && request.ReassignTo == request.UserID {
+		errs.add("reassignTo", "cannot be the participant being offboarded")
+	}
+	return errs.err()
+}
+
+//Helper: decode and validate an input in one go
+func decodeAndValidate(input string, v interface{}, validate func() error, required ...string) error {
+	errs := decodeStrict(input, v, required...)
+	if len(errs) != 0 {
+		return errs
+	}
+	if validate == nil {
+		return nil
+	}
+	return validate()
+}
+
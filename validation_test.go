@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//Helper: fields named in the details of an error
+func detailFields(details []FieldError) []string {
+	fields := []string{}
+	for _, detail := range details {
+		fields = append(fields, detail.Field)
+	}
+	return fields
+}
+
+func TestDecodeStrict(t *testing.T) {
+	cases := []struct {
+		input  string
+		fields []string
+	}{
+		{`{"TicketID":"1","UserID":"i000001"}`, []string{}},
+		{`{"TicketID":"1","UserID":"i000001","Extra":1}`, []string{"Extra"}},
+		{`{"TicketID":1,"UserID":"i000001"}`, []string{"TicketID"}},
+		{`{"UserID":"i000001"}`, []string{"TicketID"}},
+		{`{"TicketID":null,"UserID":"i000001"}`, []string{"TicketID"}},
+		{`["1"]`, []string{""}},
+		{`TicketID=1`, []string{""}},
+	}
+	for _, c := range cases {
+		var order Order
+		errs := decodeStrict(c.input, &order, "TicketID", "UserID")
+		if !equalStrings(detailFields(errs), c.fields) {
+			t.Errorf("%s: expected %v, got %v", c.input, c.fields, errs)
+		}
+	}
+}
+
+func TestValidateTicket(t *testing.T) {
+	ticket := Ticket{Title: "t", Type: P1, Value: 10, UserID: "i000001"}
+	if err := validateTicket(ticket, false); err != nil {
+		t.Errorf("valid ticket rejected: %v", err)
+	}
+	if err := validateTicket(ticket, true); err == nil || !strings.Contains(err.Error(), "Ticket_TicketID") {
+		t.Errorf("update without TicketID: %v", err)
+	}
+
+	ticket = Ticket{Title: strings.Repeat("t", MaxTitleLength+1), Value: -1, UserID: "i 000001", Comment: strings.Repeat("c", MaxCommentLength+1)}
+	errs, _ := validateTicket(ticket, false).(ValidationErrors)
+	expected := []string{"Ticket_UserID", "Ticket_Title", "Ticket_Comment", "Ticket_Value"}
+	if !equalStrings(detailFields(errs), expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+}
+
+func TestRoutesRejectInvalidInput(t *testing.T) {
+	stub := newTestStub(t)
+
+	// every invalid field is listed
+	response := stub.expectError(ErrCodeBadRequest, "addParticipant", `{"Participant_UserID":"","Participant_UserName":"x","Participant_IsAdmin":false,"Participant_LoBID":99}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Participant_UserID", "Participant_LoBID"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+	response = stub.expectError(ErrCodeBadRequest, "addParticipant", `{"Participant_UserID":"i000001","Participant_UserName":"x","Participant_IsAdmin":"no","Participant_LoBID":1}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Participant_IsAdmin"}) {
+		t.Errorf("unexpected details %+v", response.Details)
+	}
+	stub.expectError(ErrCodeNotFound, "readParticipant", "i000001")
+
+	stub.addUser("i000001", HANA)
+	stub.asUser("i000001")
+	response = stub.expectError(ErrCodeBadRequest, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":1,"Ticket_Value":-5,"Ticket_UserID":"i000001","Ticket_Owner":"x"}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Owner"}) {
+		t.Errorf("unknown field: unexpected details %+v", response.Details)
+	}
+	response = stub.expectError(ErrCodeBadRequest, "TicketCreate", `{"Ticket_Title":"t","Ticket_Type":1,"Ticket_Value":-5,"Ticket_UserID":"i000001"}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"Ticket_Value"}) {
+		t.Errorf("negative value: unexpected details %+v", response.Details)
+	}
+	response = stub.expectError(ErrCodeBadRequest, "OrderCreate", `{"TicketID":"","UserID":"i000001"}`)
+	if fields := detailFields(response.Details); !equalStrings(fields, []string{"TicketID"}) {
+		t.Errorf("empty ID: unexpected details %+v", response.Details)
+	}
+
+	// a valid input gets past validation
+	ticket := stub.createTicket("i000001", 0, map[string]interface{}{"Ticket_Comment": "ok"})
+	if ticket.Comment != "ok" {
+		t.Errorf("unexpected ticket %+v", ticket)
+	}
+}